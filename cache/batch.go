@@ -0,0 +1,56 @@
+package cache
+
+import (
+	"context"
+	"reflect"
+	"time"
+)
+
+// BatchCache is implemented by caches that support multi-key operations.
+// InMemoryCache, RedisCache and TieredCache implement it. Check for it with
+// AsBatchCache, which also sees through wrappers such as Instrumented.
+type BatchCache interface {
+	// GetMany decodes every existing key into dest, which must be a pointer
+	// to a map[string]T. Missing and expired keys are left out of the map.
+	GetMany(ctx context.Context, keys []string, dest interface{}) error
+	// SetMany stores all items with the same expiration.
+	SetMany(ctx context.Context, items map[string]interface{}, expiration time.Duration) error
+	// DeleteMany removes all keys; missing keys are ignored.
+	DeleteMany(ctx context.Context, keys ...string) error
+	// DeleteByPrefix removes every key starting with prefix and returns how
+	// many were removed. It is meant for cache busting by tenant, e.g.
+	// DeleteByPrefix(ctx, "company:"+companyID+":").
+	DeleteByPrefix(ctx context.Context, prefix string) (int, error)
+}
+
+// AsBatchCache returns c as a BatchCache if it supports multi-key
+// operations, looking through wrappers like AsCounter.
+func AsBatchCache(c Cache) (BatchCache, bool) {
+	batch, ok := c.(BatchCache)
+	if !ok {
+		return nil, false
+	}
+	if d, ok := c.(delegator); ok {
+		if _, ok := AsBatchCache(d.delegate()); !ok {
+			return nil, false
+		}
+	}
+	return batch, true
+}
+
+// mapDest validates a GetMany destination and returns the map it points to,
+// allocating it when nil.
+func mapDest(dest interface{}) (reflect.Value, error) {
+	v := reflect.ValueOf(dest)
+	if v.Kind() != reflect.Ptr || v.IsNil() {
+		return reflect.Value{}, ErrInvalidDestination
+	}
+	m := v.Elem()
+	if m.Kind() != reflect.Map || m.Type().Key().Kind() != reflect.String {
+		return reflect.Value{}, ErrInvalidDestination
+	}
+	if m.IsNil() {
+		m.Set(reflect.MakeMap(m.Type()))
+	}
+	return m, nil
+}
@@ -1,11 +1,77 @@
 package cache
 
 import (
+	"context"
+	"errors"
 	"time"
 )
 
+// ErrNotFound is returned by every Cache implementation when the requested
+// key does not exist or has already expired.
+var ErrNotFound = errors.New("cache: key not found")
+
+// ErrInvalidExpiration is returned when Set is called with a negative
+// expiration. An expiration of zero means the value never expires.
+var ErrInvalidExpiration = errors.New("cache: expiration must not be negative")
+
+// ErrInvalidDestination is returned when Get is called with a destination
+// that is not a non-nil pointer.
+var ErrInvalidDestination = errors.New("cache: destination must be a non-nil pointer")
+
 type Cache interface {
 	Set(key string, value interface{}, expiration time.Duration) error
 	Get(key string, dest interface{}) error
 	Delete(key string) error
 }
+
+// ContextCache is the context-aware variant of Cache. Cancellation and
+// deadlines of ctx are propagated to the underlying backend.
+type ContextCache interface {
+	Cache
+	SetContext(ctx context.Context, key string, value interface{}, expiration time.Duration) error
+	GetContext(ctx context.Context, key string, dest interface{}) error
+	DeleteContext(ctx context.Context, key string) error
+}
+
+// ToContextCache returns c as a ContextCache. Implementations that already
+// support contexts are returned as is; any other Cache is wrapped in an
+// adapter that checks ctx before delegating to the context-less methods.
+func ToContextCache(c Cache) ContextCache {
+	if cc, ok := c.(ContextCache); ok {
+		return cc
+	}
+	return &contextAdapter{Cache: c}
+}
+
+// delegator is implemented by wrappers such as Instrumented and Switchable,
+// which implement every optional interface but support only those of the
+// cache they delegate to. AsCounter and AsBatchCache look through them.
+type delegator interface {
+	delegate() Cache
+}
+
+// contextAdapter lifts a legacy Cache into a ContextCache.
+type contextAdapter struct {
+	Cache
+}
+
+func (a *contextAdapter) SetContext(ctx context.Context, key string, value interface{}, expiration time.Duration) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return a.Cache.Set(key, value, expiration)
+}
+
+func (a *contextAdapter) GetContext(ctx context.Context, key string, dest interface{}) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return a.Cache.Get(key, dest)
+}
+
+func (a *contextAdapter) DeleteContext(ctx context.Context, key string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return a.Cache.Delete(key)
+}
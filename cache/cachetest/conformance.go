@@ -0,0 +1,394 @@
+// Package cachetest provides a conformance suite that every cache.Cache
+// implementation is expected to pass, so backends can be swapped without
+// changing behavior.
+package cachetest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/mauriciomartinezc/real-estate-mc-common/cache"
+)
+
+// Factory returns a fresh, empty cache for a single subtest.
+type Factory func(t *testing.T) cache.Cache
+
+type sample struct {
+	ID    string   `json:"id"`
+	Count int      `json:"count"`
+	Tags  []string `json:"tags"`
+}
+
+// Run executes the conformance suite against caches built by newCache.
+// Caches that do not implement cache.ContextCache are adapted with
+// cache.ToContextCache; caches supporting cache.BatchCache or cache.Counter,
+// as reported by cache.AsBatchCache and cache.AsCounter, also run the
+// multi-key or counter tests.
+func Run(t *testing.T, newCache Factory) {
+	t.Helper()
+
+	tests := []struct {
+		name string
+		fn   func(t *testing.T, c cache.ContextCache)
+	}{
+		{"SetGet", testSetGet},
+		{"Overwrite", testOverwrite},
+		{"MissingKey", testMissingKey},
+		{"Delete", testDelete},
+		{"DeleteMissingKey", testDeleteMissingKey},
+		{"ZeroExpirationNeverExpires", testZeroExpiration},
+		{"SubSecondExpiration", testSubSecondExpiration},
+		{"NegativeExpiration", testNegativeExpiration},
+		{"CanceledContext", testCanceledContext},
+		{"TypeMismatch", testTypeMismatch},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.fn(t, cache.ToContextCache(newCache(t)))
+		})
+	}
+
+	batchTests := []struct {
+		name string
+		fn   func(t *testing.T, c cache.BatchCache)
+	}{
+		{"SetManyGetMany", testSetManyGetMany},
+		{"DeleteMany", testDeleteMany},
+		{"DeleteByPrefix", testDeleteByPrefix},
+	}
+
+	for _, tt := range batchTests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, ok := cache.AsBatchCache(newCache(t))
+			if !ok {
+				t.Skip("cache does not support cache.BatchCache")
+			}
+			tt.fn(t, c)
+		})
+	}
+
+	counterTests := []struct {
+		name string
+		fn   func(t *testing.T, c cache.Counter)
+	}{
+		{"IncrDecr", testIncrDecr},
+		{"IncrKeepsFirstTTL", testIncrKeepsFirstTTL},
+		{"ExpireTTL", testExpireTTL},
+		{"SlidingWindow", testSlidingWindow},
+	}
+
+	for _, tt := range counterTests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, ok := cache.AsCounter(newCache(t))
+			if !ok {
+				t.Skip("cache does not support cache.Counter")
+			}
+			tt.fn(t, c)
+		})
+	}
+}
+
+func testSetGet(t *testing.T, c cache.ContextCache) {
+	ctx := context.Background()
+	want := sample{ID: "listing-1", Count: 3, Tags: []string{"house", "sale"}}
+	if err := c.SetContext(ctx, key(t), want, time.Minute); err != nil {
+		t.Fatalf("SetContext: %v", err)
+	}
+
+	var got sample
+	if err := c.GetContext(ctx, key(t), &got); err != nil {
+		t.Fatalf("GetContext: %v", err)
+	}
+	if !equal(got, want) {
+		t.Fatalf("GetContext = %+v, want %+v", got, want)
+	}
+}
+
+func testOverwrite(t *testing.T, c cache.ContextCache) {
+	ctx := context.Background()
+	if err := c.SetContext(ctx, key(t), "first", time.Minute); err != nil {
+		t.Fatalf("SetContext: %v", err)
+	}
+	if err := c.SetContext(ctx, key(t), "second", time.Minute); err != nil {
+		t.Fatalf("SetContext: %v", err)
+	}
+
+	var got string
+	if err := c.GetContext(ctx, key(t), &got); err != nil {
+		t.Fatalf("GetContext: %v", err)
+	}
+	if got != "second" {
+		t.Fatalf("GetContext = %q, want %q", got, "second")
+	}
+}
+
+func testMissingKey(t *testing.T, c cache.ContextCache) {
+	var got string
+	if err := c.GetContext(context.Background(), key(t), &got); !errors.Is(err, cache.ErrNotFound) {
+		t.Fatalf("GetContext error = %v, want ErrNotFound", err)
+	}
+}
+
+func testDelete(t *testing.T, c cache.ContextCache) {
+	ctx := context.Background()
+	if err := c.SetContext(ctx, key(t), 42, time.Minute); err != nil {
+		t.Fatalf("SetContext: %v", err)
+	}
+	if err := c.DeleteContext(ctx, key(t)); err != nil {
+		t.Fatalf("DeleteContext: %v", err)
+	}
+
+	var got int
+	if err := c.GetContext(ctx, key(t), &got); !errors.Is(err, cache.ErrNotFound) {
+		t.Fatalf("GetContext after delete error = %v, want ErrNotFound", err)
+	}
+}
+
+func testDeleteMissingKey(t *testing.T, c cache.ContextCache) {
+	if err := c.DeleteContext(context.Background(), key(t)); err != nil {
+		t.Fatalf("DeleteContext of missing key: %v", err)
+	}
+}
+
+func testZeroExpiration(t *testing.T, c cache.ContextCache) {
+	ctx := context.Background()
+	if err := c.SetContext(ctx, key(t), 7, 0); err != nil {
+		t.Fatalf("SetContext: %v", err)
+	}
+	time.Sleep(1100 * time.Millisecond)
+
+	var got int
+	if err := c.GetContext(ctx, key(t), &got); err != nil {
+		t.Fatalf("GetContext of key without expiration: %v", err)
+	}
+	if got != 7 {
+		t.Fatalf("GetContext = %d, want 7", got)
+	}
+}
+
+func testSubSecondExpiration(t *testing.T, c cache.ContextCache) {
+	ctx := context.Background()
+	if err := c.SetContext(ctx, key(t), 1, 200*time.Millisecond); err != nil {
+		t.Fatalf("SetContext: %v", err)
+	}
+
+	var got int
+	if err := c.GetContext(ctx, key(t), &got); err != nil {
+		t.Fatalf("GetContext before expiration: %v", err)
+	}
+
+	time.Sleep(400 * time.Millisecond)
+	if err := c.GetContext(ctx, key(t), &got); !errors.Is(err, cache.ErrNotFound) {
+		t.Fatalf("GetContext after expiration error = %v, want ErrNotFound", err)
+	}
+}
+
+func testNegativeExpiration(t *testing.T, c cache.ContextCache) {
+	err := c.SetContext(context.Background(), key(t), 1, -time.Second)
+	if !errors.Is(err, cache.ErrInvalidExpiration) {
+		t.Fatalf("SetContext error = %v, want ErrInvalidExpiration", err)
+	}
+}
+
+func testCanceledContext(t *testing.T, c cache.ContextCache) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if err := c.SetContext(ctx, key(t), 1, time.Minute); err == nil {
+		t.Fatal("SetContext with canceled context succeeded")
+	}
+	var got int
+	if err := c.GetContext(ctx, key(t), &got); err == nil || errors.Is(err, cache.ErrNotFound) {
+		t.Fatalf("GetContext with canceled context error = %v, want context error", err)
+	}
+}
+
+func testTypeMismatch(t *testing.T, c cache.ContextCache) {
+	ctx := context.Background()
+	if err := c.SetContext(ctx, key(t), []int{1, 2}, time.Minute); err != nil {
+		t.Fatalf("SetContext: %v", err)
+	}
+
+	var got string
+	var mismatch *cache.TypeMismatchError
+	if err := c.GetContext(ctx, key(t), &got); !errors.As(err, &mismatch) {
+		t.Fatalf("GetContext error = %v, want *TypeMismatchError", err)
+	}
+}
+
+func testSetManyGetMany(t *testing.T, c cache.BatchCache) {
+	ctx := context.Background()
+	items := map[string]interface{}{
+		key(t) + ":a": sample{ID: "a", Count: 1},
+		key(t) + ":b": sample{ID: "b", Count: 2},
+	}
+	if err := c.SetMany(ctx, items, time.Minute); err != nil {
+		t.Fatalf("SetMany: %v", err)
+	}
+
+	got := map[string]sample{}
+	keys := []string{key(t) + ":a", key(t) + ":b", key(t) + ":missing"}
+	if err := c.GetMany(ctx, keys, &got); err != nil {
+		t.Fatalf("GetMany: %v", err)
+	}
+	if len(got) != 2 || got[key(t)+":a"].Count != 1 || got[key(t)+":b"].Count != 2 {
+		t.Fatalf("GetMany = %+v, want keys a and b", got)
+	}
+}
+
+func testDeleteMany(t *testing.T, c cache.BatchCache) {
+	ctx := context.Background()
+	items := map[string]interface{}{key(t) + ":a": 1, key(t) + ":b": 2, key(t) + ":c": 3}
+	if err := c.SetMany(ctx, items, time.Minute); err != nil {
+		t.Fatalf("SetMany: %v", err)
+	}
+	if err := c.DeleteMany(ctx, key(t)+":a", key(t)+":b", key(t)+":missing"); err != nil {
+		t.Fatalf("DeleteMany: %v", err)
+	}
+
+	got := map[string]int{}
+	if err := c.GetMany(ctx, []string{key(t) + ":a", key(t) + ":b", key(t) + ":c"}, &got); err != nil {
+		t.Fatalf("GetMany: %v", err)
+	}
+	if len(got) != 1 || got[key(t)+":c"] != 3 {
+		t.Fatalf("GetMany after DeleteMany = %v, want only c", got)
+	}
+}
+
+func testDeleteByPrefix(t *testing.T, c cache.BatchCache) {
+	ctx := context.Background()
+	tenant := key(t) + ":company:1*[x]:"
+	items := map[string]interface{}{
+		tenant + "listing:1":        1,
+		tenant + "listing:2":        2,
+		key(t) + ":company:2:other": 3,
+	}
+	if err := c.SetMany(ctx, items, time.Minute); err != nil {
+		t.Fatalf("SetMany: %v", err)
+	}
+
+	deleted, err := c.DeleteByPrefix(ctx, tenant)
+	if err != nil {
+		t.Fatalf("DeleteByPrefix: %v", err)
+	}
+	if deleted != 2 {
+		t.Fatalf("DeleteByPrefix deleted %d keys, want 2", deleted)
+	}
+
+	got := map[string]int{}
+	keys := []string{tenant + "listing:1", tenant + "listing:2", key(t) + ":company:2:other"}
+	if err := c.GetMany(ctx, keys, &got); err != nil {
+		t.Fatalf("GetMany: %v", err)
+	}
+	if len(got) != 1 {
+		t.Fatalf("GetMany after DeleteByPrefix = %v, want only the other tenant", got)
+	}
+}
+
+func testIncrDecr(t *testing.T, c cache.Counter) {
+	ctx := context.Background()
+	if n, err := c.Incr(ctx, key(t), 5, 0); err != nil || n != 5 {
+		t.Fatalf("Incr = %d, %v; want 5", n, err)
+	}
+	if n, err := c.Decr(ctx, key(t), 2); err != nil || n != 3 {
+		t.Fatalf("Decr = %d, %v; want 3", n, err)
+	}
+
+	var got int64
+	if err := c.(cache.ContextCache).GetContext(ctx, key(t), &got); err != nil || got != 3 {
+		t.Fatalf("Get counter = %d, %v; want 3", got, err)
+	}
+	var small int
+	if err := c.(cache.ContextCache).GetContext(ctx, key(t), &small); err != nil || small != 3 {
+		t.Fatalf("Get counter into an int = %d, %v; want 3", small, err)
+	}
+	var narrow int8
+	if err := c.(cache.ContextCache).GetContext(ctx, key(t), &narrow); err != nil || narrow != 3 {
+		t.Fatalf("Get counter into an int8 = %d, %v; want 3", narrow, err)
+	}
+}
+
+func testIncrKeepsFirstTTL(t *testing.T, c cache.Counter) {
+	ctx := context.Background()
+	if _, err := c.Incr(ctx, key(t), 1, 200*time.Millisecond); err != nil {
+		t.Fatalf("Incr: %v", err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if _, err := c.Incr(ctx, key(t), 1, 200*time.Millisecond); err != nil {
+		t.Fatalf("Incr: %v", err)
+	}
+	time.Sleep(150 * time.Millisecond)
+
+	if n, err := c.Incr(ctx, key(t), 1, 0); err != nil || n != 1 {
+		t.Fatalf("Incr after the first TTL = %d, %v; want a fresh counter", n, err)
+	}
+}
+
+func testExpireTTL(t *testing.T, c cache.Counter) {
+	ctx := context.Background()
+	if _, err := c.TTL(ctx, key(t)); !errors.Is(err, cache.ErrNotFound) {
+		t.Fatalf("TTL of a missing key = %v, want ErrNotFound", err)
+	}
+	if exists, err := c.Expire(ctx, key(t), time.Minute); err != nil || exists {
+		t.Fatalf("Expire of a missing key = %v, %v; want false", exists, err)
+	}
+
+	if _, err := c.Incr(ctx, key(t), 1, 0); err != nil {
+		t.Fatalf("Incr: %v", err)
+	}
+	if ttl, err := c.TTL(ctx, key(t)); err != nil || ttl != 0 {
+		t.Fatalf("TTL without expiration = %v, %v; want 0", ttl, err)
+	}
+	if exists, err := c.Expire(ctx, key(t), time.Minute); err != nil || !exists {
+		t.Fatalf("Expire = %v, %v; want true", exists, err)
+	}
+	if ttl, err := c.TTL(ctx, key(t)); err != nil || ttl <= 0 || ttl > time.Minute {
+		t.Fatalf("TTL = %v, %v; want up to a minute", ttl, err)
+	}
+	if exists, err := c.Expire(ctx, key(t), 0); err != nil || !exists {
+		t.Fatalf("Expire(0) = %v, %v; want true", exists, err)
+	}
+	if ttl, err := c.TTL(ctx, key(t)); err != nil || ttl != 0 {
+		t.Fatalf("TTL after Expire(0) = %v, %v; want 0", ttl, err)
+	}
+}
+
+func testSlidingWindow(t *testing.T, c cache.Counter) {
+	ctx := context.Background()
+	window := 200 * time.Millisecond
+	for i := int64(1); i <= 3; i++ {
+		if n, err := c.IncrWindow(ctx, key(t), window); err != nil || n != i {
+			t.Fatalf("IncrWindow = %d, %v; want %d", n, err, i)
+		}
+	}
+	time.Sleep(120 * time.Millisecond)
+	if n, err := c.IncrWindow(ctx, key(t), window); err != nil || n != 4 {
+		t.Fatalf("IncrWindow = %d, %v; want 4", n, err)
+	}
+	time.Sleep(120 * time.Millisecond)
+
+	if n, err := c.WindowCount(ctx, key(t), window); err != nil || n != 1 {
+		t.Fatalf("WindowCount = %d, %v; want only the last event", n, err)
+	}
+}
+
+// key derives a key from the subtest name so suites can share a backend.
+func key(t *testing.T) string {
+	return fmt.Sprintf("cachetest:%s", t.Name())
+}
+
+func equal(a, b sample) bool {
+	if a.ID != b.ID || a.Count != b.Count || len(a.Tags) != len(b.Tags) {
+		return false
+	}
+	for i := range a.Tags {
+		if a.Tags[i] != b.Tags[i] {
+			return false
+		}
+	}
+	return true
+}
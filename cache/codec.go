@@ -0,0 +1,82 @@
+package cache
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"reflect"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// Codec serializes cached values. InMemoryCache encodes every value with
+// its codec, JSONCodec unless configured otherwise, so that every Get
+// returns a detached copy, matching the JSON round-trip done by RedisCache.
+type Codec interface {
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
+
+// JSONCodec encodes values with encoding/json, the same format RedisCache
+// stores.
+type JSONCodec struct{}
+
+func (JSONCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (JSONCodec) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+// GobCodec encodes values with encoding/gob. It is faster than JSON for
+// large structs; concrete types stored behind interface fields must be
+// registered with gob.Register.
+type GobCodec struct{}
+
+func (GobCodec) Marshal(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (GobCodec) Unmarshal(data []byte, v interface{}) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
+
+// MsgpackCodec encodes values with MessagePack, which is more compact than
+// JSON and keeps the distinction between integers and floats. Like JSON it
+// only encodes exported struct fields, named by their "msgpack" tags.
+type MsgpackCodec struct{}
+
+func (MsgpackCodec) Marshal(v interface{}) ([]byte, error) {
+	return msgpack.Marshal(v)
+}
+
+func (MsgpackCodec) Unmarshal(data []byte, v interface{}) error {
+	return msgpack.Unmarshal(data, v)
+}
+
+// TypeMismatchError is returned by Get when the cached value cannot be
+// stored in the destination, either because the stored Go type is not
+// assignable to it or because decoding into it failed.
+type TypeMismatchError struct {
+	Key    string
+	Stored reflect.Type // nil when the value was decoded by a codec
+	Dest   reflect.Type
+	Err    error // decoding error, if any
+}
+
+func (e *TypeMismatchError) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("cache: cannot decode key %q into %v: %v", e.Key, e.Dest, e.Err)
+	}
+	return fmt.Sprintf("cache: key %q holds %v, not assignable to %v", e.Key, e.Stored, e.Dest)
+}
+
+func (e *TypeMismatchError) Unwrap() error {
+	return e.Err
+}
@@ -0,0 +1,58 @@
+package cache
+
+import (
+	"context"
+	"time"
+)
+
+// Counter is implemented by caches that support atomic integer counters and
+// sliding-window event counts, the building blocks of rate limiting and view
+// counters. Callers should check for it with AsCounter, which also sees
+// through wrappers such as Instrumented:
+//
+//	if counter, ok := cache.AsCounter(c); ok { ... }
+//
+// Counters are plain cache entries and can be read with Get into any
+// signed integer type that holds their value, and removed with Delete. Sliding windows use a backend specific
+// representation and are only accessible through IncrWindow and WindowCount,
+// besides Delete.
+type Counter interface {
+	// Incr atomically adds delta to the integer stored under key, starting
+	// from zero if it is missing, and returns the new value. If ttl is
+	// positive and the key has no expiration yet, the key expires after
+	// ttl, so the first increment starts a fixed window.
+	Incr(ctx context.Context, key string, delta int64, ttl time.Duration) (int64, error)
+	// Decr atomically subtracts delta from the integer stored under key and
+	// returns the new value. The expiration of the key is left unchanged.
+	Decr(ctx context.Context, key string, delta int64) (int64, error)
+	// Expire sets the expiration of an existing key; zero removes it. It
+	// reports whether the key exists.
+	Expire(ctx context.Context, key string, ttl time.Duration) (bool, error)
+	// TTL returns the remaining time to live of key, zero if it never
+	// expires, or ErrNotFound if it is missing.
+	TTL(ctx context.Context, key string) (time.Duration, error)
+
+	// IncrWindow records an event under key and returns the number of
+	// events recorded during the last window, including this one.
+	IncrWindow(ctx context.Context, key string, window time.Duration) (int64, error)
+	// WindowCount returns the number of events recorded under key during
+	// the last window without recording one.
+	WindowCount(ctx context.Context, key string, window time.Duration) (int64, error)
+}
+
+// AsCounter returns c as a Counter if it supports counters. Unlike a type
+// assertion, it reports false for an Instrumented or Switchable wrapping a
+// cache without them, whose Counter methods return ErrNotSupported. The
+// cache behind a Switchable may change afterwards.
+func AsCounter(c Cache) (Counter, bool) {
+	counter, ok := c.(Counter)
+	if !ok {
+		return nil, false
+	}
+	if d, ok := c.(delegator); ok {
+		if _, ok := AsCounter(d.delegate()); !ok {
+			return nil, false
+		}
+	}
+	return counter, true
+}
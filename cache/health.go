@@ -0,0 +1,29 @@
+package cache
+
+import (
+	"context"
+	"errors"
+)
+
+// HealthChecker is implemented by caches that can verify their backend is
+// reachable, for readiness probes.
+type HealthChecker interface {
+	HealthCheck(ctx context.Context) error
+}
+
+// healthProbeKey is never written, so reading it only tests connectivity.
+const healthProbeKey = "health:probe"
+
+// CheckHealth runs the HealthCheck of c, or for caches without one reads a
+// key that never exists, which a reachable backend answers with
+// ErrNotFound.
+func CheckHealth(ctx context.Context, c Cache) error {
+	if checker, ok := c.(HealthChecker); ok {
+		return checker.HealthCheck(ctx)
+	}
+	err := ToContextCache(c).GetContext(ctx, healthProbeKey, new(struct{}))
+	if err == nil || errors.Is(err, ErrNotFound) {
+		return nil
+	}
+	return err
+}
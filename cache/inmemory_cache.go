@@ -1,61 +1,517 @@
 package cache
 
 import (
+	"container/list"
+	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"reflect"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
+// ErrValueTooLarge is returned when a value is bigger than the WithMaxBytes
+// bound of an InMemoryCache. The value is not stored and any previous value
+// of the key is removed.
+var ErrValueTooLarge = errors.New("cache: value exceeds the size bound")
+
 type InMemoryCache struct {
-	data map[string]cacheItem
-	mu   sync.RWMutex
+	data  map[string]*list.Element
+	lru   *list.List // front is the most recently used entry
+	bytes int64
+	mu    sync.Mutex
+
+	maxEntries      int
+	maxBytes        int64
+	sizeFunc        func(value interface{}) int64
+	cleanupInterval time.Duration
+	codec           Codec
+
+	// locks and fence back NewInMemoryLocker. One fencing counter serves
+	// every key, so tokens keep increasing across acquisitions of a key
+	// without state kept per key once its lock is gone.
+	locks map[string]*memoryLock
+	fence uint64
+
+	evictions   atomic.Uint64
+	expirations atomic.Uint64
+
+	stop      chan struct{}
+	closeOnce sync.Once
+	wg        sync.WaitGroup
 }
 
 type cacheItem struct {
-	value      interface{}
-	expiration int64
+	key        string
+	value      interface{} // raw value, used without a codec
+	data       []byte      // encoded value, used with a codec
+	expiration int64       // UnixNano; zero means the item never expires
+	size       int64
+}
+
+// expired reports whether the item is past its expiration at now (UnixNano).
+func (i *cacheItem) expired(now int64) bool {
+	return i.expiration > 0 && now >= i.expiration
+}
+
+// InMemoryStats is a point-in-time snapshot of an InMemoryCache.
+type InMemoryStats struct {
+	Entries     int
+	Bytes       int64
+	Evictions   uint64
+	Expirations uint64
 }
 
-func NewInMemoryCache() *InMemoryCache {
-	return &InMemoryCache{
-		data: make(map[string]cacheItem),
+// InMemoryOption configures an InMemoryCache.
+type InMemoryOption func(*InMemoryCache)
+
+// WithMaxEntries bounds the number of entries; the least recently used
+// entry is evicted once the bound is exceeded. Zero means unbounded.
+func WithMaxEntries(n int) InMemoryOption {
+	return func(c *InMemoryCache) {
+		c.maxEntries = n
+	}
+}
+
+// WithMaxBytes bounds the estimated size of all stored values; least
+// recently used entries are evicted until the cache fits. Zero means
+// unbounded.
+func WithMaxBytes(n int64) InMemoryOption {
+	return func(c *InMemoryCache) {
+		c.maxBytes = n
 	}
 }
 
+// WithSizeFunc overrides how the size of a value stored without a codec is
+// estimated for WithMaxBytes. By default strings and byte slices count
+// their length and any other value the length of its JSON encoding.
+func WithSizeFunc(fn func(value interface{}) int64) InMemoryOption {
+	return func(c *InMemoryCache) {
+		c.sizeFunc = fn
+	}
+}
+
+// WithCodec replaces JSONCodec, the default, as the encoding of stored
+// values. The encoded length is the entry size for WithMaxBytes. A nil
+// codec stores values as is, which saves the encoding but makes Get alias
+// the stored value: slices, maps and pointers are shared with the caller
+// that set them and every caller that gets them, and the destination must
+// have a compatible type.
+func WithCodec(codec Codec) InMemoryOption {
+	return func(c *InMemoryCache) {
+		c.codec = codec
+	}
+}
+
+// WithCleanupInterval starts a background janitor that removes expired
+// entries every d; Close stops it. Without it expired entries are removed
+// when read or evicted.
+func WithCleanupInterval(d time.Duration) InMemoryOption {
+	return func(c *InMemoryCache) {
+		c.cleanupInterval = d
+	}
+}
+
+// NewInMemoryCache creates an in-memory cache. Without options it is
+// unbounded, encodes values with JSONCodec and starts no goroutine.
+func NewInMemoryCache(opts ...InMemoryOption) *InMemoryCache {
+	c := &InMemoryCache{
+		data:     make(map[string]*list.Element),
+		lru:      list.New(),
+		locks:    make(map[string]*memoryLock),
+		sizeFunc: estimateSize,
+		codec:    JSONCodec{},
+		stop:     make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	if c.cleanupInterval > 0 {
+		c.wg.Add(1)
+		go c.janitor(c.cleanupInterval)
+	}
+
+	return c
+}
+
 func (c *InMemoryCache) Set(key string, value interface{}, expiration time.Duration) error {
+	return c.SetContext(context.Background(), key, value, expiration)
+}
+
+func (c *InMemoryCache) Get(key string, dest interface{}) error {
+	return c.GetContext(context.Background(), key, dest)
+}
+
+func (c *InMemoryCache) Delete(key string) error {
+	return c.DeleteContext(context.Background(), key)
+}
+
+// SetContext stores value under key. An expiration of zero keeps the value
+// until it is deleted or evicted; negative expirations are rejected with
+// ErrInvalidExpiration. ctx is only checked for cancellation since the
+// in-memory backend never blocks.
+func (c *InMemoryCache) SetContext(ctx context.Context, key string, value interface{}, expiration time.Duration) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if expiration < 0 {
+		return ErrInvalidExpiration
+	}
+
+	item, err := c.newItem(key, value, expiration)
+	if err != nil {
+		return err
+	}
+
 	c.mu.Lock()
 	defer c.mu.Unlock()
-	c.data[key] = cacheItem{
-		value:      value,
-		expiration: time.Now().Add(expiration).Unix(),
+	if err := c.rejectTooLarge(item); err != nil {
+		return err
 	}
+	c.store(item)
+	c.evict()
 	return nil
 }
 
-func (c *InMemoryCache) Get(key string, dest interface{}) error {
-	c.mu.RLock()
-	defer c.mu.RUnlock()
-	item, found := c.data[key]
-	if !found || time.Now().Unix() > item.expiration {
-		return fmt.Errorf("key not found")
+// rejectTooLarge removes the key of an item that can never fit in the
+// cache, so a stale value is not served, and returns ErrValueTooLarge.
+// Storing it would evict every other entry and then the item itself. The
+// caller must hold c.mu.
+func (c *InMemoryCache) rejectTooLarge(item *cacheItem) error {
+	if c.maxBytes <= 0 || item.size <= c.maxBytes {
+		return nil
+	}
+	if el, found := c.data[item.key]; found {
+		c.removeElement(el)
+	}
+	return fmt.Errorf("%w: key %q takes %d of %d bytes", ErrValueTooLarge, item.key, item.size, c.maxBytes)
+}
+
+// newItem builds the entry stored for value, encoding it when a codec is
+// configured.
+func (c *InMemoryCache) newItem(key string, value interface{}, expiration time.Duration) (*cacheItem, error) {
+	item := &cacheItem{key: key}
+	if c.codec != nil {
+		data, err := c.codec.Marshal(value)
+		if err != nil {
+			return nil, fmt.Errorf("cache: encoding key %q: %w", key, err)
+		}
+		item.data = data
+		item.size = int64(len(data))
+	} else {
+		item.value = value
+		if c.maxBytes > 0 {
+			item.size = c.sizeFunc(value)
+		}
+	}
+	if expiration > 0 {
+		item.expiration = time.Now().Add(expiration).UnixNano()
+	}
+	return item, nil
+}
+
+// store inserts item as the most recently used entry, replacing any previous
+// entry for the same key. The caller must hold c.mu and call evict.
+func (c *InMemoryCache) store(item *cacheItem) {
+	if el, found := c.data[item.key]; found {
+		c.removeElement(el)
+	}
+	c.data[item.key] = c.lru.PushFront(item)
+	c.bytes += item.size
+}
+
+// GetContext copies the value stored under key into dest, returning
+// ErrNotFound when the key is missing or expired and a *TypeMismatchError
+// when the value does not fit into dest.
+func (c *InMemoryCache) GetContext(ctx context.Context, key string, dest interface{}) error {
+	if err := ctx.Err(); err != nil {
+		return err
 	}
 
-	// Verificar si `dest` es un puntero
 	v := reflect.ValueOf(dest)
 	if v.Kind() != reflect.Ptr || v.IsNil() {
-		return errors.New("destination must be a non-nil pointer")
+		return ErrInvalidDestination
+	}
+
+	c.mu.Lock()
+	el, found := c.data[key]
+	if !found {
+		c.mu.Unlock()
+		return ErrNotFound
+	}
+	item := el.Value.(*cacheItem)
+	if item.expired(time.Now().UnixNano()) {
+		c.removeElement(el)
+		c.expirations.Add(1)
+		c.mu.Unlock()
+		return ErrNotFound
 	}
+	c.lru.MoveToFront(el)
+	c.mu.Unlock()
 
-	// Copiar el valor almacenado al valor apuntado por `dest`
-	v.Elem().Set(reflect.ValueOf(item.value))
+	// Items are never mutated once stored, so decoding can happen unlocked.
+	return c.decode(item, v)
+}
+
+// DeleteContext removes key from the cache.
+func (c *InMemoryCache) DeleteContext(ctx context.Context, key string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, found := c.data[key]; found {
+		c.removeElement(el)
+	}
 	return nil
 }
 
-func (c *InMemoryCache) Delete(key string) error {
+// decode copies item into the value pointed to by v.
+func (c *InMemoryCache) decode(item *cacheItem, v reflect.Value) error {
+	if c.codec != nil {
+		if err := c.codec.Unmarshal(item.data, v.Interface()); err != nil {
+			return &TypeMismatchError{Key: item.key, Dest: v.Elem().Type(), Err: err}
+		}
+		return nil
+	}
+
+	elem := v.Elem()
+	if item.value == nil {
+		elem.Set(reflect.Zero(elem.Type()))
+		return nil
+	}
+	stored := reflect.ValueOf(item.value)
+	if stored.Type().AssignableTo(elem.Type()) {
+		elem.Set(stored)
+		return nil
+	}
+	if convertInteger(stored, elem) {
+		return nil
+	}
+	return &TypeMismatchError{Key: item.key, Stored: stored.Type(), Dest: elem.Type()}
+}
+
+// convertInteger sets dest to the integer stored when both are of signed
+// integer kinds and the value fits, as decoding the JSON of a RedisCache
+// would, so counters can be read into any signed integer type.
+func convertInteger(stored, dest reflect.Value) bool {
+	var n int64
+	switch stored.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n = stored.Int()
+	default:
+		return false
+	}
+	switch dest.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if dest.OverflowInt(n) {
+			return false
+		}
+		dest.SetInt(n)
+		return true
+	}
+	return false
+}
+
+// GetMany decodes every existing, unexpired key into dest, a pointer to a
+// map[string]T.
+func (c *InMemoryCache) GetMany(ctx context.Context, keys []string, dest interface{}) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m, err := mapDest(dest)
+	if err != nil {
+		return err
+	}
+
+	items := make([]*cacheItem, 0, len(keys))
+	now := time.Now().UnixNano()
+	c.mu.Lock()
+	for _, key := range keys {
+		el, found := c.data[key]
+		if !found {
+			continue
+		}
+		item := el.Value.(*cacheItem)
+		if item.expired(now) {
+			c.removeElement(el)
+			c.expirations.Add(1)
+			continue
+		}
+		c.lru.MoveToFront(el)
+		items = append(items, item)
+	}
+	c.mu.Unlock()
+
+	elemType := m.Type().Elem()
+	for _, item := range items {
+		v := reflect.New(elemType)
+		if err := c.decode(item, v); err != nil {
+			return err
+		}
+		m.SetMapIndex(reflect.ValueOf(item.key).Convert(m.Type().Key()), v.Elem())
+	}
+	return nil
+}
+
+// SetMany stores all items with the same expiration.
+func (c *InMemoryCache) SetMany(ctx context.Context, items map[string]interface{}, expiration time.Duration) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if expiration < 0 {
+		return ErrInvalidExpiration
+	}
+
+	entries := make([]*cacheItem, 0, len(items))
+	for key, value := range items {
+		item, err := c.newItem(key, value, expiration)
+		if err != nil {
+			return err
+		}
+		entries = append(entries, item)
+	}
+
+	// Values that can never fit are skipped; the rest are stored.
+	var tooLarge error
 	c.mu.Lock()
 	defer c.mu.Unlock()
-	delete(c.data, key)
+	for _, item := range entries {
+		if err := c.rejectTooLarge(item); err != nil {
+			if tooLarge == nil {
+				tooLarge = err
+			}
+			continue
+		}
+		c.store(item)
+	}
+	c.evict()
+	return tooLarge
+}
+
+// DeleteMany removes all keys.
+func (c *InMemoryCache) DeleteMany(ctx context.Context, keys ...string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, key := range keys {
+		if el, found := c.data[key]; found {
+			c.removeElement(el)
+		}
+	}
 	return nil
 }
+
+// DeleteByPrefix removes every key starting with prefix.
+func (c *InMemoryCache) DeleteByPrefix(ctx context.Context, prefix string) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	deleted := 0
+	for key, el := range c.data {
+		if strings.HasPrefix(key, prefix) {
+			c.removeElement(el)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+// Stats returns the current size of the cache together with the number of
+// entries evicted by the size bounds and removed because they expired.
+func (c *InMemoryCache) Stats() InMemoryStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return InMemoryStats{
+		Entries:     len(c.data),
+		Bytes:       c.bytes,
+		Evictions:   c.evictions.Load(),
+		Expirations: c.expirations.Load(),
+	}
+}
+
+// Close stops the background janitor. It is safe to call more than once
+// and the cache remains usable afterwards.
+func (c *InMemoryCache) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.stop)
+	})
+	c.wg.Wait()
+	return nil
+}
+
+// janitor periodically removes expired entries until Close is called.
+func (c *InMemoryCache) janitor(interval time.Duration) {
+	defer c.wg.Done()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			c.deleteExpired()
+		case <-c.stop:
+			return
+		}
+	}
+}
+
+// deleteExpired removes every expired entry and lock.
+func (c *InMemoryCache) deleteExpired() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now().UnixNano()
+	for _, el := range c.data {
+		if el.Value.(*cacheItem).expired(now) {
+			c.removeElement(el)
+			c.expirations.Add(1)
+		}
+	}
+	for key, lock := range c.locks {
+		if lock.expiration <= now {
+			delete(c.locks, key)
+		}
+	}
+}
+
+// evict drops least recently used entries until the configured bounds are
+// satisfied. The caller must hold c.mu.
+func (c *InMemoryCache) evict() {
+	for c.lru.Len() > 0 &&
+		((c.maxEntries > 0 && c.lru.Len() > c.maxEntries) ||
+			(c.maxBytes > 0 && c.bytes > c.maxBytes)) {
+		c.removeElement(c.lru.Back())
+		c.evictions.Add(1)
+	}
+}
+
+// removeElement unlinks el from the index and the LRU list. The caller must
+// hold c.mu.
+func (c *InMemoryCache) removeElement(el *list.Element) {
+	item := c.lru.Remove(el).(*cacheItem)
+	delete(c.data, item.key)
+	c.bytes -= item.size
+}
+
+// estimateSize approximates the memory used by value.
+func estimateSize(value interface{}) int64 {
+	switch v := value.(type) {
+	case []byte:
+		return int64(len(v))
+	case string:
+		return int64(len(v))
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return 0
+	}
+	return int64(len(data))
+}
@@ -0,0 +1,113 @@
+package cache_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/mauriciomartinezc/real-estate-mc-common/cache"
+	"github.com/mauriciomartinezc/real-estate-mc-common/cache/cachetest"
+)
+
+func TestInMemoryCacheConformance(t *testing.T) {
+	cachetest.Run(t, func(t *testing.T) cache.Cache {
+		return cache.NewInMemoryCache()
+	})
+}
+
+func TestInMemoryCacheCodecConformance(t *testing.T) {
+	codecs := map[string]cache.Codec{
+		"None":    nil,
+		"Gob":     cache.GobCodec{},
+		"Msgpack": cache.MsgpackCodec{},
+	}
+	for name, codec := range codecs {
+		t.Run(name, func(t *testing.T) {
+			cachetest.Run(t, func(t *testing.T) cache.Cache {
+				return cache.NewInMemoryCache(cache.WithCodec(codec))
+			})
+		})
+	}
+}
+
+func TestInMemoryCacheBoundedConformance(t *testing.T) {
+	cachetest.Run(t, func(t *testing.T) cache.Cache {
+		c := cache.NewInMemoryCache(
+			cache.WithMaxEntries(1000),
+			cache.WithMaxBytes(1<<20),
+			cache.WithCleanupInterval(50*time.Millisecond),
+		)
+		t.Cleanup(func() { c.Close() })
+		return c
+	})
+}
+
+func TestInMemoryCacheReturnsCopies(t *testing.T) {
+	ctx := context.Background()
+	c := cache.NewInMemoryCache()
+
+	tags := []string{"a", "b"}
+	if err := c.SetContext(ctx, "tags", tags, 0); err != nil {
+		t.Fatalf("SetContext: %v", err)
+	}
+	tags[0] = "changed after Set"
+	var got []string
+	if err := c.GetContext(ctx, "tags", &got); err != nil {
+		t.Fatalf("GetContext: %v", err)
+	}
+	got[1] = "changed after Get"
+	var again []string
+	if err := c.GetContext(ctx, "tags", &again); err != nil {
+		t.Fatalf("GetContext: %v", err)
+	}
+	if len(again) != 2 || again[0] != "a" || again[1] != "b" {
+		t.Fatalf("cached value = %v, want [a b]", again)
+	}
+
+	// A value of another type is a TypeMismatchError, not a panic.
+	var n int
+	var mismatch *cache.TypeMismatchError
+	if err := c.GetContext(ctx, "tags", &n); !errors.As(err, &mismatch) || mismatch.Err == nil {
+		t.Fatalf("GetContext into an int error = %v, want a TypeMismatchError", err)
+	}
+}
+
+func TestInMemoryCacheWithoutCodecSharesValues(t *testing.T) {
+	ctx := context.Background()
+	c := cache.NewInMemoryCache(cache.WithCodec(nil))
+
+	tags := []string{"a"}
+	if err := c.SetContext(ctx, "tags", tags, 0); err != nil {
+		t.Fatalf("SetContext: %v", err)
+	}
+	tags[0] = "changed"
+	var got []string
+	if err := c.GetContext(ctx, "tags", &got); err != nil || got[0] != "changed" {
+		t.Fatalf("GetContext = %v, %v, want the stored slice itself", got, err)
+	}
+
+	var n int
+	var mismatch *cache.TypeMismatchError
+	if err := c.GetContext(ctx, "tags", &n); !errors.As(err, &mismatch) || mismatch.Stored == nil {
+		t.Fatalf("GetContext into an int error = %v, want a TypeMismatchError", err)
+	}
+}
+
+func TestInMemoryCacheCounterOverflow(t *testing.T) {
+	ctx := context.Background()
+	// Without a codec the conversion is done by the cache itself.
+	c := cache.NewInMemoryCache(cache.WithCodec(nil))
+	if _, err := c.Incr(ctx, "hits", 300, 0); err != nil {
+		t.Fatalf("Incr: %v", err)
+	}
+	var narrow int8
+	var mismatch *cache.TypeMismatchError
+	if err := c.GetContext(ctx, "hits", &narrow); !errors.As(err, &mismatch) {
+		t.Fatalf("Get of 300 into an int8 error = %v, want a TypeMismatchError", err)
+	}
+	var unsigned uint
+	if err := c.GetContext(ctx, "hits", &unsigned); !errors.As(err, &mismatch) {
+		t.Fatalf("Get into a uint error = %v, want a TypeMismatchError", err)
+	}
+}
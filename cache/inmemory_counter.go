@@ -0,0 +1,201 @@
+package cache
+
+import (
+	"context"
+	"reflect"
+	"time"
+)
+
+var int64Type = reflect.TypeOf(int64(0))
+
+// Incr adds delta to the integer stored under key, see Counter.
+func (c *InMemoryCache) Incr(ctx context.Context, key string, delta int64, ttl time.Duration) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	if ttl < 0 {
+		return 0, ErrInvalidExpiration
+	}
+
+	now := time.Now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var current, expiration int64
+	if el, found := c.data[key]; found {
+		item := el.Value.(*cacheItem)
+		if !item.expired(now.UnixNano()) {
+			n, err := c.integer(item)
+			if err != nil {
+				return 0, err
+			}
+			current, expiration = n, item.expiration
+		}
+	}
+	if expiration == 0 && ttl > 0 {
+		expiration = now.Add(ttl).UnixNano()
+	}
+
+	// Items are never mutated once stored, so the counter is replaced.
+	item, err := c.newItem(key, current+delta, 0)
+	if err != nil {
+		return 0, err
+	}
+	item.expiration = expiration
+	c.store(item)
+	c.evict()
+	return current + delta, nil
+}
+
+// Decr subtracts delta from the integer stored under key, see Counter.
+func (c *InMemoryCache) Decr(ctx context.Context, key string, delta int64) (int64, error) {
+	return c.Incr(ctx, key, -delta, 0)
+}
+
+// Expire sets the expiration of an existing key, see Counter.
+func (c *InMemoryCache) Expire(ctx context.Context, key string, ttl time.Duration) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+	if ttl < 0 {
+		return false, ErrInvalidExpiration
+	}
+
+	now := time.Now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, found := c.data[key]
+	if !found {
+		return false, nil
+	}
+	item := el.Value.(*cacheItem)
+	if item.expired(now.UnixNano()) {
+		c.removeElement(el)
+		c.expirations.Add(1)
+		return false, nil
+	}
+
+	updated := *item
+	updated.expiration = 0
+	if ttl > 0 {
+		updated.expiration = now.Add(ttl).UnixNano()
+	}
+	c.store(&updated)
+	return true, nil
+}
+
+// TTL returns the remaining time to live of key, see Counter.
+func (c *InMemoryCache) TTL(ctx context.Context, key string) (time.Duration, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	now := time.Now().UnixNano()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, found := c.data[key]
+	if !found {
+		return 0, ErrNotFound
+	}
+	item := el.Value.(*cacheItem)
+	if item.expired(now) {
+		c.removeElement(el)
+		c.expirations.Add(1)
+		return 0, ErrNotFound
+	}
+	if item.expiration == 0 {
+		return 0, nil
+	}
+	return time.Duration(item.expiration - now), nil
+}
+
+// IncrWindow records an event under key, see Counter. Events are kept as
+// a list of timestamps, bypassing the codec, that expires once the window
+// has passed without new events.
+func (c *InMemoryCache) IncrWindow(ctx context.Context, key string, window time.Duration) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	if window <= 0 {
+		return 0, ErrInvalidExpiration
+	}
+
+	now := time.Now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	events, err := c.windowEvents(key, now.Add(-window).UnixNano())
+	if err != nil {
+		return 0, err
+	}
+	events = append(events, now.UnixNano())
+
+	item := &cacheItem{
+		key:        key,
+		value:      events,
+		expiration: now.Add(window).UnixNano(),
+		size:       int64(len(events)) * 8,
+	}
+	c.store(item)
+	c.evict()
+	return int64(len(events)), nil
+}
+
+// WindowCount returns the number of events recorded under key during the
+// last window, see Counter.
+func (c *InMemoryCache) WindowCount(ctx context.Context, key string, window time.Duration) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	if window <= 0 {
+		return 0, ErrInvalidExpiration
+	}
+
+	since := time.Now().Add(-window).UnixNano()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	events, err := c.windowEvents(key, since)
+	return int64(len(events)), err
+}
+
+// windowEvents returns a copy of the timestamps stored under key that are
+// after since. The caller must hold c.mu.
+func (c *InMemoryCache) windowEvents(key string, since int64) ([]int64, error) {
+	el, found := c.data[key]
+	if !found {
+		return nil, nil
+	}
+	item := el.Value.(*cacheItem)
+	if item.expired(time.Now().UnixNano()) {
+		return nil, nil
+	}
+	stored, ok := item.value.([]int64)
+	if !ok {
+		return nil, &TypeMismatchError{Key: key, Stored: reflect.TypeOf(item.value), Dest: reflect.TypeOf(stored)}
+	}
+
+	events := make([]int64, 0, len(stored)+1)
+	for _, at := range stored {
+		if at > since {
+			events = append(events, at)
+		}
+	}
+	return events, nil
+}
+
+// integer returns the value of item as an int64.
+func (c *InMemoryCache) integer(item *cacheItem) (int64, error) {
+	if c.codec != nil {
+		var n int64
+		if err := c.codec.Unmarshal(item.data, &n); err != nil {
+			return 0, &TypeMismatchError{Key: item.key, Dest: int64Type, Err: err}
+		}
+		return n, nil
+	}
+
+	v := reflect.ValueOf(item.value)
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int(), nil
+	}
+	return 0, &TypeMismatchError{Key: item.key, Stored: reflect.TypeOf(item.value), Dest: int64Type}
+}
@@ -0,0 +1,75 @@
+package cache
+
+import (
+	"context"
+	"time"
+)
+
+// memoryLock is a lock held in an InMemoryCache.
+type memoryLock struct {
+	owner      string
+	expiration int64 // UnixNano
+}
+
+type memoryLockBackend struct {
+	cache *InMemoryCache
+}
+
+// NewInMemoryLocker returns a Locker keeping locks inside c, the in-process
+// counterpart of NewRedisLocker for tests and single replica deployments.
+// Lockers created from the same cache exclude each other. Locks are kept
+// apart from cached entries, so they are never evicted.
+func NewInMemoryLocker(c *InMemoryCache, opts ...LockerOption) Locker {
+	return newLocker(&memoryLockBackend{cache: c}, opts)
+}
+
+func (b *memoryLockBackend) acquire(ctx context.Context, key, owner string, ttl time.Duration) (uint64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	c := b.cache
+	now := time.Now()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if lock, found := c.locks[key]; found && lock.expiration > now.UnixNano() {
+		return 0, nil
+	}
+	c.locks[key] = &memoryLock{owner: owner, expiration: now.Add(ttl).UnixNano()}
+	c.fence++
+	return c.fence, nil
+}
+
+func (b *memoryLockBackend) extend(ctx context.Context, key, owner string, ttl time.Duration) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+	c := b.cache
+	now := time.Now()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	lock, found := c.locks[key]
+	if !found || lock.owner != owner || lock.expiration <= now.UnixNano() {
+		return false, nil
+	}
+	lock.expiration = now.Add(ttl).UnixNano()
+	return true, nil
+}
+
+func (b *memoryLockBackend) release(ctx context.Context, key, owner string) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+	c := b.cache
+	now := time.Now()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	lock, found := c.locks[key]
+	if !found || lock.owner != owner {
+		return false, nil
+	}
+	delete(c.locks, key)
+	return lock.expiration > now.UnixNano(), nil
+}
@@ -0,0 +1,385 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mauriciomartinezc/real-estate-mc-common/logger"
+)
+
+// DefaultLatencyBuckets are the histogram upper bounds, in seconds, used by
+// Instrumented when no buckets are configured.
+var DefaultLatencyBuckets = []float64{0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1}
+
+// InstrumentedConfig configures an Instrumented cache.
+type InstrumentedConfig struct {
+	// Name identifies the cache in metrics, e.g. the service name.
+	Name string
+	// Logger receives one LogCacheOperation call per operation. Defaults to
+	// the global logger; nothing is logged if neither is set.
+	Logger *logger.Logger
+	// Buckets overrides DefaultLatencyBuckets.
+	Buckets []float64
+}
+
+// OperationStats is a snapshot of the metrics recorded for one operation.
+// Hits and Misses count keys, so a single GetMany may add several.
+type OperationStats struct {
+	Calls         uint64
+	Hits          uint64
+	Misses        uint64
+	Errors        uint64
+	TotalDuration time.Duration
+}
+
+// HitRatio returns hits / (hits + misses), or zero before the first lookup.
+func (s OperationStats) HitRatio() float64 {
+	if s.Hits+s.Misses == 0 {
+		return 0
+	}
+	return float64(s.Hits) / float64(s.Hits+s.Misses)
+}
+
+// Instrumented decorates a Cache, recording hits, misses, errors and latency
+// per operation and logging every call through logger.LogCacheOperation.
+// It implements BatchCache and Counter whatever it wraps, returning
+// ErrNotSupported if the wrapped cache does not; use AsBatchCache and
+// AsCounter to check.
+type Instrumented struct {
+	cache   ContextCache
+	name    string
+	logger  *logger.Logger
+	buckets []float64
+
+	mu         sync.Mutex
+	operations map[string]*operationMetrics
+}
+
+type operationMetrics struct {
+	OperationStats
+	bucketCounts []uint64
+}
+
+// NewInstrumented wraps c with metrics and logging.
+func NewInstrumented(c Cache, config InstrumentedConfig) *Instrumented {
+	if config.Logger == nil {
+		config.Logger = logger.GetGlobalLogger()
+	}
+	if len(config.Buckets) == 0 {
+		config.Buckets = DefaultLatencyBuckets
+	}
+	buckets := append([]float64(nil), config.Buckets...)
+	sort.Float64s(buckets)
+
+	return &Instrumented{
+		cache:      ToContextCache(c),
+		name:       config.Name,
+		logger:     config.Logger,
+		buckets:    buckets,
+		operations: make(map[string]*operationMetrics),
+	}
+}
+
+// Unwrap returns the decorated cache.
+func (i *Instrumented) Unwrap() ContextCache {
+	return i.cache
+}
+
+func (i *Instrumented) delegate() Cache {
+	return i.cache
+}
+
+func (i *Instrumented) Set(key string, value interface{}, expiration time.Duration) error {
+	start := time.Now()
+	err := i.cache.Set(key, value, expiration)
+	i.record("set", key, start, 0, 0, err)
+	return err
+}
+
+func (i *Instrumented) Get(key string, dest interface{}) error {
+	start := time.Now()
+	err := i.cache.Get(key, dest)
+	i.recordLookup("get", key, start, err)
+	return err
+}
+
+func (i *Instrumented) Delete(key string) error {
+	start := time.Now()
+	err := i.cache.Delete(key)
+	i.record("delete", key, start, 0, 0, err)
+	return err
+}
+
+func (i *Instrumented) SetContext(ctx context.Context, key string, value interface{}, expiration time.Duration) error {
+	start := time.Now()
+	err := i.cache.SetContext(ctx, key, value, expiration)
+	i.record("set", key, start, 0, 0, err)
+	return err
+}
+
+func (i *Instrumented) GetContext(ctx context.Context, key string, dest interface{}) error {
+	start := time.Now()
+	err := i.cache.GetContext(ctx, key, dest)
+	i.recordLookup("get", key, start, err)
+	return err
+}
+
+func (i *Instrumented) DeleteContext(ctx context.Context, key string) error {
+	start := time.Now()
+	err := i.cache.DeleteContext(ctx, key)
+	i.record("delete", key, start, 0, 0, err)
+	return err
+}
+
+// GetMany counts every requested key as a hit or a miss.
+func (i *Instrumented) GetMany(ctx context.Context, keys []string, dest interface{}) error {
+	batch, ok := i.cache.(BatchCache)
+	if !ok {
+		return ErrNotSupported
+	}
+	start := time.Now()
+	err := batch.GetMany(ctx, keys, dest)
+	var hits uint64
+	if err == nil {
+		m := reflect.ValueOf(dest).Elem()
+		for _, key := range keys {
+			if m.MapIndex(reflect.ValueOf(key).Convert(m.Type().Key())).IsValid() {
+				hits++
+			}
+		}
+	}
+	i.record("get_many", strings.Join(keys, ","), start, hits, uint64(len(keys))-hits, err)
+	return err
+}
+
+func (i *Instrumented) SetMany(ctx context.Context, items map[string]interface{}, expiration time.Duration) error {
+	batch, ok := i.cache.(BatchCache)
+	if !ok {
+		return ErrNotSupported
+	}
+	start := time.Now()
+	err := batch.SetMany(ctx, items, expiration)
+	keys := make([]string, 0, len(items))
+	for key := range items {
+		keys = append(keys, key)
+	}
+	i.record("set_many", strings.Join(keys, ","), start, 0, 0, err)
+	return err
+}
+
+func (i *Instrumented) DeleteMany(ctx context.Context, keys ...string) error {
+	batch, ok := i.cache.(BatchCache)
+	if !ok {
+		return ErrNotSupported
+	}
+	start := time.Now()
+	err := batch.DeleteMany(ctx, keys...)
+	i.record("delete_many", strings.Join(keys, ","), start, 0, 0, err)
+	return err
+}
+
+func (i *Instrumented) DeleteByPrefix(ctx context.Context, prefix string) (int, error) {
+	batch, ok := i.cache.(BatchCache)
+	if !ok {
+		return 0, ErrNotSupported
+	}
+	start := time.Now()
+	deleted, err := batch.DeleteByPrefix(ctx, prefix)
+	i.record("delete_by_prefix", prefix+"*", start, 0, 0, err)
+	return deleted, err
+}
+
+func (i *Instrumented) Incr(ctx context.Context, key string, delta int64, ttl time.Duration) (int64, error) {
+	counter, ok := i.cache.(Counter)
+	if !ok {
+		return 0, ErrNotSupported
+	}
+	start := time.Now()
+	n, err := counter.Incr(ctx, key, delta, ttl)
+	i.record("incr", key, start, 0, 0, err)
+	return n, err
+}
+
+func (i *Instrumented) Decr(ctx context.Context, key string, delta int64) (int64, error) {
+	counter, ok := i.cache.(Counter)
+	if !ok {
+		return 0, ErrNotSupported
+	}
+	start := time.Now()
+	n, err := counter.Decr(ctx, key, delta)
+	i.record("decr", key, start, 0, 0, err)
+	return n, err
+}
+
+func (i *Instrumented) Expire(ctx context.Context, key string, ttl time.Duration) (bool, error) {
+	counter, ok := i.cache.(Counter)
+	if !ok {
+		return false, ErrNotSupported
+	}
+	start := time.Now()
+	exists, err := counter.Expire(ctx, key, ttl)
+	i.record("expire", key, start, 0, 0, err)
+	return exists, err
+}
+
+// TTL counts a missing key as a miss.
+func (i *Instrumented) TTL(ctx context.Context, key string) (time.Duration, error) {
+	counter, ok := i.cache.(Counter)
+	if !ok {
+		return 0, ErrNotSupported
+	}
+	start := time.Now()
+	ttl, err := counter.TTL(ctx, key)
+	i.recordLookup("ttl", key, start, err)
+	return ttl, err
+}
+
+func (i *Instrumented) IncrWindow(ctx context.Context, key string, window time.Duration) (int64, error) {
+	counter, ok := i.cache.(Counter)
+	if !ok {
+		return 0, ErrNotSupported
+	}
+	start := time.Now()
+	n, err := counter.IncrWindow(ctx, key, window)
+	i.record("incr_window", key, start, 0, 0, err)
+	return n, err
+}
+
+func (i *Instrumented) WindowCount(ctx context.Context, key string, window time.Duration) (int64, error) {
+	counter, ok := i.cache.(Counter)
+	if !ok {
+		return 0, ErrNotSupported
+	}
+	start := time.Now()
+	n, err := counter.WindowCount(ctx, key, window)
+	i.record("window_count", key, start, 0, 0, err)
+	return n, err
+}
+
+// HealthCheck checks the wrapped cache, see CheckHealth.
+func (i *Instrumented) HealthCheck(ctx context.Context) error {
+	return CheckHealth(ctx, i.cache)
+}
+
+// Stats returns a snapshot of the metrics recorded per operation.
+func (i *Instrumented) Stats() map[string]OperationStats {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	stats := make(map[string]OperationStats, len(i.operations))
+	for name, op := range i.operations {
+		stats[name] = op.OperationStats
+	}
+	return stats
+}
+
+// WritePrometheus writes the metrics in the Prometheus text exposition
+// format.
+func (i *Instrumented) WritePrometheus(w io.Writer) error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	names := make([]string, 0, len(i.operations))
+	for name := range i.operations {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	b.WriteString("# HELP cache_operations_total Cache operations by outcome.\n")
+	b.WriteString("# TYPE cache_operations_total counter\n")
+	for _, name := range names {
+		op := i.operations[name]
+		labels := fmt.Sprintf(`cache=%q,operation=%q`, i.name, name)
+		fmt.Fprintf(&b, "cache_operations_total{%s,result=\"ok\"} %d\n", labels, op.Calls-op.Errors)
+		fmt.Fprintf(&b, "cache_operations_total{%s,result=\"error\"} %d\n", labels, op.Errors)
+	}
+
+	b.WriteString("# HELP cache_lookups_total Keys looked up, by hit or miss.\n")
+	b.WriteString("# TYPE cache_lookups_total counter\n")
+	for _, name := range names {
+		op := i.operations[name]
+		if op.Hits+op.Misses == 0 {
+			continue
+		}
+		labels := fmt.Sprintf(`cache=%q,operation=%q`, i.name, name)
+		fmt.Fprintf(&b, "cache_lookups_total{%s,result=\"hit\"} %d\n", labels, op.Hits)
+		fmt.Fprintf(&b, "cache_lookups_total{%s,result=\"miss\"} %d\n", labels, op.Misses)
+	}
+
+	b.WriteString("# HELP cache_operation_duration_seconds Cache operation latency.\n")
+	b.WriteString("# TYPE cache_operation_duration_seconds histogram\n")
+	for _, name := range names {
+		op := i.operations[name]
+		labels := fmt.Sprintf(`cache=%q,operation=%q`, i.name, name)
+		var cumulative uint64
+		for idx, bound := range i.buckets {
+			cumulative += op.bucketCounts[idx]
+			fmt.Fprintf(&b, "cache_operation_duration_seconds_bucket{%s,le=\"%g\"} %d\n", labels, bound, cumulative)
+		}
+		fmt.Fprintf(&b, "cache_operation_duration_seconds_bucket{%s,le=\"+Inf\"} %d\n", labels, op.Calls)
+		fmt.Fprintf(&b, "cache_operation_duration_seconds_sum{%s} %g\n", labels, op.TotalDuration.Seconds())
+		fmt.Fprintf(&b, "cache_operation_duration_seconds_count{%s} %d\n", labels, op.Calls)
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// ServeHTTP exposes the metrics so the cache can be mounted as a scrape
+// endpoint, e.g. e.GET("/metrics/cache", echo.WrapHandler(instrumented)).
+func (i *Instrumented) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	i.WritePrometheus(w)
+}
+
+// recordLookup records a single-key read, treating ErrNotFound as a miss
+// rather than an error.
+func (i *Instrumented) recordLookup(operation, key string, start time.Time, err error) {
+	switch {
+	case err == nil:
+		i.record(operation, key, start, 1, 0, nil)
+	case errors.Is(err, ErrNotFound):
+		i.record(operation, key, start, 0, 1, nil)
+	default:
+		i.record(operation, key, start, 0, 0, err)
+	}
+}
+
+// record updates the metrics of operation and logs the call.
+func (i *Instrumented) record(operation, key string, start time.Time, hits, misses uint64, err error) {
+	elapsed := time.Since(start)
+
+	i.mu.Lock()
+	op, found := i.operations[operation]
+	if !found {
+		op = &operationMetrics{bucketCounts: make([]uint64, len(i.buckets))}
+		i.operations[operation] = op
+	}
+	op.Calls++
+	op.Hits += hits
+	op.Misses += misses
+	if err != nil {
+		op.Errors++
+	}
+	op.TotalDuration += elapsed
+	seconds := elapsed.Seconds()
+	for idx, bound := range i.buckets {
+		if seconds <= bound {
+			op.bucketCounts[idx]++
+			break
+		}
+	}
+	i.mu.Unlock()
+
+	if i.logger != nil {
+		i.logger.LogCacheOperation(operation, key, hits > 0, err)
+	}
+}
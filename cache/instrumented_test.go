@@ -0,0 +1,172 @@
+package cache_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mauriciomartinezc/real-estate-mc-common/cache"
+)
+
+// legacyCache only has the methods of cache.Cache.
+type legacyCache struct {
+	cache.Cache
+}
+
+func TestInstrumentedStats(t *testing.T) {
+	ctx := context.Background()
+	c := cache.NewInstrumented(cache.NewInMemoryCache(), cache.InstrumentedConfig{Name: "listings"})
+
+	if err := c.SetContext(ctx, "a", "x", time.Minute); err != nil {
+		t.Fatalf("SetContext: %v", err)
+	}
+	var s string
+	if err := c.GetContext(ctx, "a", &s); err != nil || s != "x" {
+		t.Fatalf("GetContext = %q, %v, want x", s, err)
+	}
+	if err := c.GetContext(ctx, "b", &s); !errors.Is(err, cache.ErrNotFound) {
+		t.Fatalf("GetContext of a missing key error = %v, want ErrNotFound", err)
+	}
+	var n int
+	var mismatch *cache.TypeMismatchError
+	if err := c.GetContext(ctx, "a", &n); !errors.As(err, &mismatch) {
+		t.Fatalf("GetContext into an int error = %v, want the TypeMismatchError passed through", err)
+	}
+	many := map[string]string{}
+	if err := c.GetMany(ctx, []string{"a", "b", "c"}, &many); err != nil {
+		t.Fatalf("GetMany: %v", err)
+	}
+	if _, err := c.TTL(ctx, "b"); !errors.Is(err, cache.ErrNotFound) {
+		t.Fatalf("TTL of a missing key error = %v, want ErrNotFound", err)
+	}
+	if _, err := c.Incr(ctx, "hits", 1, 0); err != nil {
+		t.Fatalf("Incr: %v", err)
+	}
+
+	want := map[string]cache.OperationStats{
+		"set":      {Calls: 1},
+		"get":      {Calls: 3, Hits: 1, Misses: 1, Errors: 1},
+		"get_many": {Calls: 1, Hits: 1, Misses: 2},
+		"ttl":      {Calls: 1, Misses: 1},
+		"incr":     {Calls: 1},
+	}
+	stats := c.Stats()
+	if len(stats) != len(want) {
+		t.Fatalf("Stats has %d operations, want %d: %v", len(stats), len(want), stats)
+	}
+	for name, w := range want {
+		got := stats[name]
+		if got.Calls != w.Calls || got.Hits != w.Hits || got.Misses != w.Misses || got.Errors != w.Errors {
+			t.Fatalf("Stats[%s] = %+v, want %+v", name, got, w)
+		}
+		if got.TotalDuration <= 0 {
+			t.Fatalf("Stats[%s] recorded no duration", name)
+		}
+	}
+	if ratio := stats["get"].HitRatio(); ratio != 0.5 {
+		t.Fatalf("get HitRatio = %v, want 0.5", ratio)
+	}
+}
+
+func TestInstrumentedPassesErrorsThrough(t *testing.T) {
+	c := cache.NewInstrumented(cache.NewInMemoryCache(), cache.InstrumentedConfig{})
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if err := c.Set("a", "x", -time.Second); !errors.Is(err, cache.ErrInvalidExpiration) {
+		t.Fatalf("Set with a negative expiration error = %v, want ErrInvalidExpiration", err)
+	}
+	if err := c.DeleteContext(ctx, "a"); !errors.Is(err, context.Canceled) {
+		t.Fatalf("DeleteContext with a canceled context error = %v, want Canceled", err)
+	}
+	if err := c.Get("a", nil); !errors.Is(err, cache.ErrInvalidDestination) {
+		t.Fatalf("Get into nil error = %v, want ErrInvalidDestination", err)
+	}
+
+	stats := c.Stats()
+	for _, name := range []string{"set", "delete", "get"} {
+		if stats[name].Errors != 1 || stats[name].Misses != 0 {
+			t.Fatalf("Stats[%s] = %+v, want one error", name, stats[name])
+		}
+	}
+}
+
+func TestInstrumentedPrometheus(t *testing.T) {
+	ctx := context.Background()
+	c := cache.NewInstrumented(cache.NewInMemoryCache(), cache.InstrumentedConfig{
+		Name:    "listings",
+		Buckets: []float64{10, 5},
+	})
+	_ = c.SetContext(ctx, "a", "x", 0)
+	var s string
+	_ = c.GetContext(ctx, "a", &s)
+	_ = c.GetContext(ctx, "b", &s)
+	_ = c.SetContext(ctx, "c", "x", -1)
+
+	rec := httptest.NewRecorder()
+	c.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics/cache", nil))
+	if contentType := rec.Header().Get("Content-Type"); !strings.HasPrefix(contentType, "text/plain") {
+		t.Fatalf("Content-Type = %q, want text/plain", contentType)
+	}
+	body := rec.Body.String()
+	for _, line := range []string{
+		`cache_operations_total{cache="listings",operation="get",result="ok"} 2`,
+		`cache_operations_total{cache="listings",operation="set",result="ok"} 1`,
+		`cache_operations_total{cache="listings",operation="set",result="error"} 1`,
+		`cache_lookups_total{cache="listings",operation="get",result="hit"} 1`,
+		`cache_lookups_total{cache="listings",operation="get",result="miss"} 1`,
+		// The buckets are sorted and cumulative.
+		`cache_operation_duration_seconds_bucket{cache="listings",operation="get",le="5"} 2`,
+		`cache_operation_duration_seconds_bucket{cache="listings",operation="get",le="10"} 2`,
+		`cache_operation_duration_seconds_bucket{cache="listings",operation="get",le="+Inf"} 2`,
+		`cache_operation_duration_seconds_count{cache="listings",operation="set"} 2`,
+	} {
+		if !strings.Contains(body, line+"\n") {
+			t.Fatalf("metrics lack %s:\n%s", line, body)
+		}
+	}
+	if strings.Contains(body, `cache_lookups_total{cache="listings",operation="set"`) {
+		t.Fatalf("lookups reported for set:\n%s", body)
+	}
+	if strings.Index(body, `le="5"`) > strings.Index(body, `le="10"`) {
+		t.Fatalf("buckets out of order:\n%s", body)
+	}
+}
+
+func TestInstrumentedCapabilities(t *testing.T) {
+	ctx := context.Background()
+
+	full := cache.NewInstrumented(cache.NewInMemoryCache(), cache.InstrumentedConfig{})
+	if _, ok := cache.AsCounter(full); !ok {
+		t.Fatal("AsCounter of an instrumented InMemoryCache = false")
+	}
+	if _, ok := cache.AsBatchCache(full); !ok {
+		t.Fatal("AsBatchCache of an instrumented InMemoryCache = false")
+	}
+
+	legacy := cache.NewInstrumented(legacyCache{cache.NewInMemoryCache()}, cache.InstrumentedConfig{})
+	if _, ok := cache.AsCounter(legacy); ok {
+		t.Fatal("AsCounter of an instrumented cache without counters = true")
+	}
+	if _, ok := cache.AsBatchCache(legacy); ok {
+		t.Fatal("AsBatchCache of an instrumented cache without batches = true")
+	}
+	if _, err := legacy.Incr(ctx, "hits", 1, 0); !errors.Is(err, cache.ErrNotSupported) {
+		t.Fatalf("Incr error = %v, want ErrNotSupported", err)
+	}
+	if err := legacy.DeleteMany(ctx, "a"); !errors.Is(err, cache.ErrNotSupported) {
+		t.Fatalf("DeleteMany error = %v, want ErrNotSupported", err)
+	}
+	// Nor through more than one wrapper.
+	nested := cache.NewInstrumented(cache.NewSwitchable(legacyCache{cache.NewInMemoryCache()}), cache.InstrumentedConfig{})
+	if _, ok := cache.AsCounter(nested); ok {
+		t.Fatal("AsCounter of nested wrappers without counters = true")
+	}
+	if _, ok := cache.AsCounter(legacyCache{cache.NewInMemoryCache()}); ok {
+		t.Fatal("AsCounter of a cache without counters = true")
+	}
+}
@@ -0,0 +1,165 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+)
+
+const (
+	defaultLoaderSoftTTL     = time.Minute
+	defaultLoaderLoadTimeout = 30 * time.Second
+	// defaultLoaderHardTTLFactor sets the default HardTTL as a multiple of
+	// SoftTTL, so values that are no longer requested eventually leave the
+	// cache.
+	defaultLoaderHardTTLFactor = 10
+)
+
+// LoaderConfig configures a Loader.
+type LoaderConfig struct {
+	// SoftTTL is how long a loaded value is fresh. Once it passes the value
+	// is still served, but a single background refresh is started.
+	// Defaults to one minute.
+	SoftTTL time.Duration
+	// HardTTL is how long a value stays in the cache at all; after it,
+	// callers wait for the loader. Defaults to ten times SoftTTL, and is
+	// raised to SoftTTL if lower.
+	HardTTL time.Duration
+	// NegativeTTL caches "not found" results for this long so repeated
+	// lookups of missing records do not reach the backend. Zero disables
+	// negative caching.
+	NegativeTTL time.Duration
+	// IsNotFound reports whether a loader error means the record does not
+	// exist, e.g. errors.Is(err, gorm.ErrRecordNotFound). Defaults to
+	// errors.Is(err, ErrNotFound).
+	IsNotFound func(err error) bool
+	// LoadTimeout bounds every loader call, which is detached from the
+	// caller's cancellation so one caller giving up does not fail the
+	// others. Defaults to 30 seconds.
+	LoadTimeout time.Duration
+	// OnRefreshError is called when a background refresh fails; the stale
+	// value keeps being served until HardTTL.
+	OnRefreshError func(key string, err error)
+}
+
+// Loader puts a slow backend behind a Cache with stale-while-revalidate and
+// negative caching semantics.
+type Loader[T any] struct {
+	entries *Typed[loaderEntry[T]]
+	config  LoaderConfig
+	group   singleflight.Group
+}
+
+// loaderEntry is what Loader stores in the cache.
+type loaderEntry[T any] struct {
+	Value      T         `json:"value"`
+	NotFound   bool      `json:"not_found,omitempty"`
+	FreshUntil time.Time `json:"fresh_until"`
+}
+
+// NewLoader stores entries in c under prefix, see NewTyped.
+func NewLoader[T any](c Cache, prefix string, config LoaderConfig) *Loader[T] {
+	if config.SoftTTL <= 0 {
+		config.SoftTTL = defaultLoaderSoftTTL
+	}
+	if config.HardTTL <= 0 {
+		config.HardTTL = defaultLoaderHardTTLFactor * config.SoftTTL
+	}
+	if config.HardTTL < config.SoftTTL {
+		config.HardTTL = config.SoftTTL
+	}
+	if config.IsNotFound == nil {
+		config.IsNotFound = func(err error) bool {
+			return errors.Is(err, ErrNotFound)
+		}
+	}
+	if config.LoadTimeout <= 0 {
+		config.LoadTimeout = defaultLoaderLoadTimeout
+	}
+
+	return &Loader[T]{
+		entries: NewTyped[loaderEntry[T]](c, prefix),
+		config:  config,
+	}
+}
+
+// Get returns the value for key. Fresh values come straight from the cache;
+// stale ones are returned immediately while one background call to load
+// refreshes them; on a miss the caller waits for load, and concurrent
+// callers share that call. Records reported missing by load, or cached as
+// missing, are returned as ErrNotFound.
+func (l *Loader[T]) Get(ctx context.Context, key string, load func(ctx context.Context) (T, error)) (T, error) {
+	entry, found, err := l.entries.Get(ctx, key)
+	if err == nil && found {
+		if entry.NotFound {
+			var zero T
+			return zero, ErrNotFound
+		}
+		if time.Now().After(entry.FreshUntil) {
+			l.refresh(ctx, key, load)
+		}
+		return entry.Value, nil
+	}
+
+	ch := l.group.DoChan(key, func() (interface{}, error) {
+		return l.load(ctx, key, load)
+	})
+	select {
+	case res := <-ch:
+		if res.Err != nil {
+			var zero T
+			return zero, res.Err
+		}
+		value, _ := res.Val.(T)
+		return value, nil
+	case <-ctx.Done():
+		var zero T
+		return zero, ctx.Err()
+	}
+}
+
+// Invalidate drops the cached entry for key, e.g. after the record changed.
+func (l *Loader[T]) Invalidate(ctx context.Context, key string) error {
+	return l.entries.Delete(ctx, key)
+}
+
+// refresh starts a background reload of key unless one is in flight.
+func (l *Loader[T]) refresh(ctx context.Context, key string, load func(ctx context.Context) (T, error)) {
+	ch := l.group.DoChan(key, func() (interface{}, error) {
+		return l.load(ctx, key, load)
+	})
+	go func() {
+		res := <-ch
+		if res.Err != nil && !errors.Is(res.Err, ErrNotFound) && l.config.OnRefreshError != nil {
+			l.config.OnRefreshError(key, res.Err)
+		}
+	}()
+}
+
+// load calls the loader and caches its outcome.
+func (l *Loader[T]) load(ctx context.Context, key string, load func(ctx context.Context) (T, error)) (T, error) {
+	loadCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), l.config.LoadTimeout)
+	defer cancel()
+
+	value, err := load(loadCtx)
+	if err != nil {
+		if !l.config.IsNotFound(err) {
+			return value, err
+		}
+		if l.config.NegativeTTL > 0 {
+			entry := loaderEntry[T]{NotFound: true, FreshUntil: time.Now().Add(l.config.NegativeTTL)}
+			_ = l.entries.Set(loadCtx, key, entry, l.config.NegativeTTL)
+		} else {
+			// Drop a stale value for a record that no longer exists.
+			_ = l.entries.Delete(loadCtx, key)
+		}
+		var zero T
+		return zero, ErrNotFound
+	}
+
+	entry := loaderEntry[T]{Value: value, FreshUntil: time.Now().Add(l.config.SoftTTL)}
+	_ = l.entries.Set(loadCtx, key, entry, l.config.HardTTL)
+	return value, nil
+}
@@ -0,0 +1,236 @@
+package cache_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mauriciomartinezc/real-estate-mc-common/cache"
+)
+
+// backend counts the calls to a loader returning value and err, blocking
+// while block is open.
+type backend struct {
+	mu    sync.Mutex
+	value string
+	err   error
+	block chan struct{}
+	calls atomic.Int32
+}
+
+func (b *backend) set(value string, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.value, b.err = value, err
+}
+
+func (b *backend) load(ctx context.Context) (string, error) {
+	b.calls.Add(1)
+	b.mu.Lock()
+	block := b.block
+	b.mu.Unlock()
+	if block != nil {
+		<-block
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.value, b.err
+}
+
+func waitUntil(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestLoaderServesStaleWhileRevalidating(t *testing.T) {
+	ctx := context.Background()
+	refreshErrs := make(chan error, 10)
+	loader := cache.NewLoader[string](cache.NewInMemoryCache(), "prices", cache.LoaderConfig{
+		SoftTTL: 20 * time.Millisecond,
+		HardTTL: time.Minute,
+		OnRefreshError: func(key string, err error) {
+			refreshErrs <- err
+		},
+	})
+	b := &backend{value: "v1"}
+
+	if value, err := loader.Get(ctx, "p1", b.load); err != nil || value != "v1" {
+		t.Fatalf("Get = %q, %v, want v1", value, err)
+	}
+	if value, err := loader.Get(ctx, "p1", b.load); err != nil || value != "v1" || b.calls.Load() != 1 {
+		t.Fatalf("Get of a fresh value = %q, %v after %d loads, want v1 from the cache", value, err, b.calls.Load())
+	}
+
+	// Once stale, the old value is served at once while a single refresh
+	// runs in the background.
+	time.Sleep(30 * time.Millisecond)
+	b.set("v2", nil)
+	b.block = make(chan struct{})
+	for i := 0; i < 5; i++ {
+		if value, err := loader.Get(ctx, "p1", b.load); err != nil || value != "v1" {
+			t.Fatalf("Get of a stale value = %q, %v, want v1", value, err)
+		}
+	}
+	waitUntil(t, "the refresh", func() bool { return b.calls.Load() == 2 })
+	close(b.block)
+	waitUntil(t, "the refreshed value", func() bool {
+		value, _ := loader.Get(ctx, "p1", b.load)
+		return value == "v2"
+	})
+	if n := b.calls.Load(); n != 2 {
+		t.Fatalf("loader called %d times, want 2", n)
+	}
+
+	// A failed refresh is reported and the stale value kept.
+	time.Sleep(30 * time.Millisecond)
+	b.block = nil
+	failure := errors.New("backend unavailable")
+	b.set("", failure)
+	if value, err := loader.Get(ctx, "p1", b.load); err != nil || value != "v2" {
+		t.Fatalf("Get of a stale value = %q, %v, want v2", value, err)
+	}
+	select {
+	case err := <-refreshErrs:
+		if !errors.Is(err, failure) {
+			t.Fatalf("refresh error = %v, want the loader error", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("refresh error not reported")
+	}
+	if value, err := loader.Get(ctx, "p1", b.load); err != nil || value != "v2" {
+		t.Fatalf("Get after a failed refresh = %q, %v, want v2", value, err)
+	}
+}
+
+func TestLoaderSharesConcurrentMisses(t *testing.T) {
+	ctx := context.Background()
+	loader := cache.NewLoader[string](cache.NewInMemoryCache(), "prices", cache.LoaderConfig{})
+	b := &backend{value: "v1", block: make(chan struct{})}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if value, err := loader.Get(ctx, "p1", b.load); err != nil || value != "v1" {
+				t.Errorf("Get = %q, %v, want v1", value, err)
+			}
+		}()
+	}
+	waitUntil(t, "the load", func() bool { return b.calls.Load() == 1 })
+	time.Sleep(20 * time.Millisecond)
+	close(b.block)
+	wg.Wait()
+	if n := b.calls.Load(); n != 1 {
+		t.Fatalf("loader called %d times, want 1", n)
+	}
+
+	// Errors other than not found are returned and not cached.
+	failure := errors.New("backend unavailable")
+	b2 := &backend{err: failure}
+	for i := 1; i <= 2; i++ {
+		if _, err := loader.Get(ctx, "p2", b2.load); !errors.Is(err, failure) || b2.calls.Load() != int32(i) {
+			t.Fatalf("Get = %v after %d loads, want the loader error after %d", err, b2.calls.Load(), i)
+		}
+	}
+}
+
+func TestLoaderNegativeCaching(t *testing.T) {
+	ctx := context.Background()
+	errNoRecord := errors.New("record not found")
+	loader := cache.NewLoader[string](cache.NewInMemoryCache(), "properties", cache.LoaderConfig{
+		NegativeTTL: 30 * time.Millisecond,
+		IsNotFound: func(err error) bool {
+			return errors.Is(err, errNoRecord)
+		},
+	})
+	b := &backend{err: errNoRecord}
+
+	for i := 0; i < 3; i++ {
+		if _, err := loader.Get(ctx, "missing", b.load); !errors.Is(err, cache.ErrNotFound) {
+			t.Fatalf("Get of a missing record error = %v, want ErrNotFound", err)
+		}
+	}
+	if n := b.calls.Load(); n != 1 {
+		t.Fatalf("loader called %d times, want 1", n)
+	}
+
+	// The record is looked up again once the negative entry expires.
+	b.set("created", nil)
+	time.Sleep(40 * time.Millisecond)
+	if value, err := loader.Get(ctx, "missing", b.load); err != nil || value != "created" {
+		t.Fatalf("Get after the negative entry expired = %q, %v, want created", value, err)
+	}
+	if n := b.calls.Load(); n != 2 {
+		t.Fatalf("loader called %d times, want 2", n)
+	}
+
+	// Invalidate drops the entry at once.
+	if err := loader.Invalidate(ctx, "missing"); err != nil {
+		t.Fatalf("Invalidate: %v", err)
+	}
+	if _, err := loader.Get(ctx, "missing", b.load); err != nil || b.calls.Load() != 3 {
+		t.Fatalf("Get after Invalidate = %v after %d loads, want a new load", err, b.calls.Load())
+	}
+}
+
+func TestLoaderWithoutNegativeCaching(t *testing.T) {
+	ctx := context.Background()
+	loader := cache.NewLoader[string](cache.NewInMemoryCache(), "properties", cache.LoaderConfig{SoftTTL: 10 * time.Millisecond})
+	b := &backend{value: "v1"}
+	if _, err := loader.Get(ctx, "p1", b.load); err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+
+	// A record found missing on refresh is dropped rather than served
+	// stale, and every lookup reaches the backend.
+	time.Sleep(20 * time.Millisecond)
+	b.set("", cache.ErrNotFound)
+	_, _ = loader.Get(ctx, "p1", b.load)
+	waitUntil(t, "the stale value to be dropped", func() bool {
+		_, err := loader.Get(ctx, "p1", b.load)
+		return errors.Is(err, cache.ErrNotFound)
+	})
+	calls := b.calls.Load()
+	if _, err := loader.Get(ctx, "p1", b.load); !errors.Is(err, cache.ErrNotFound) || b.calls.Load() != calls+1 {
+		t.Fatalf("Get of a missing record = %v after %d loads, want ErrNotFound after %d", err, b.calls.Load(), calls+1)
+	}
+}
+
+func TestLoaderHardTTL(t *testing.T) {
+	ctx := context.Background()
+	c := cache.NewInMemoryCache()
+	counter, _ := cache.AsCounter(c)
+	b := &backend{value: "v1"}
+
+	for _, tt := range []struct {
+		name   string
+		config cache.LoaderConfig
+		want   time.Duration
+	}{
+		{"default", cache.LoaderConfig{}, 10 * time.Minute},
+		{"multiple of SoftTTL", cache.LoaderConfig{SoftTTL: time.Second}, 10 * time.Second},
+		{"raised to SoftTTL", cache.LoaderConfig{SoftTTL: time.Hour, HardTTL: time.Minute}, time.Hour},
+		{"set", cache.LoaderConfig{SoftTTL: time.Second, HardTTL: time.Minute}, time.Minute},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			loader := cache.NewLoader[string](c, tt.name, tt.config)
+			if _, err := loader.Get(ctx, "p1", b.load); err != nil {
+				t.Fatalf("Get: %v", err)
+			}
+			ttl, err := counter.TTL(ctx, tt.name+":p1")
+			if err != nil || ttl <= tt.want-time.Second || ttl > tt.want {
+				t.Fatalf("TTL = %v, %v, want %v", ttl, err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,291 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var (
+	// ErrLockNotObtained is returned when a lock is held by someone else.
+	ErrLockNotObtained = errors.New("cache: lock not obtained")
+	// ErrLockLost is returned when a lock expired or was taken over before
+	// it was extended or released.
+	ErrLockLost = errors.New("cache: lock ownership lost")
+)
+
+// LockError reports a failed lock operation on Key. Err is
+// ErrLockNotObtained, ErrLockLost or the backend error.
+type LockError struct {
+	Key   string
+	Token uint64
+	Err   error
+}
+
+func (e *LockError) Error() string {
+	return fmt.Sprintf("lock %q: %v", e.Key, e.Err)
+}
+
+func (e *LockError) Unwrap() error {
+	return e.Err
+}
+
+// Locker hands out locks that are mutually exclusive across every process
+// sharing the same backend.
+type Locker interface {
+	// Lock blocks until key is acquired for ttl or ctx is done.
+	Lock(ctx context.Context, key string, ttl time.Duration) (*Lock, error)
+	// TryLock makes a single attempt, failing with ErrLockNotObtained if
+	// key is held.
+	TryLock(ctx context.Context, key string, ttl time.Duration) (*Lock, error)
+}
+
+const (
+	defaultLockPrefix        = "lock:"
+	defaultLockRetryInterval = 100 * time.Millisecond
+)
+
+// LockerOption configures a Locker.
+type LockerOption func(*lockerOptions)
+
+type lockerOptions struct {
+	prefix        string
+	retryInterval time.Duration
+	autoRenew     bool
+}
+
+// WithLockPrefix sets the prefix of lock keys. Defaults to "lock:".
+func WithLockPrefix(prefix string) LockerOption {
+	return func(o *lockerOptions) {
+		o.prefix = prefix
+	}
+}
+
+// WithLockRetryInterval sets how often Lock retries while the key is held.
+// Defaults to 100ms.
+func WithLockRetryInterval(d time.Duration) LockerOption {
+	return func(o *lockerOptions) {
+		o.retryInterval = d
+	}
+}
+
+// WithAutoRenew controls whether held locks are extended in the background
+// every third of their TTL. Enabled by default; without it the holder must
+// call Extend before the TTL runs out.
+func WithAutoRenew(enabled bool) LockerOption {
+	return func(o *lockerOptions) {
+		o.autoRenew = enabled
+	}
+}
+
+// lockBackend is the storage a locker runs on. Every method is atomic with
+// respect to the others.
+type lockBackend interface {
+	// acquire takes key for owner unless it is held and returns the new
+	// fencing token, or zero if the key is held.
+	acquire(ctx context.Context, key, owner string, ttl time.Duration) (uint64, error)
+	// extend resets the TTL of key if owner still holds it.
+	extend(ctx context.Context, key, owner string, ttl time.Duration) (bool, error)
+	// release deletes key if owner still holds it.
+	release(ctx context.Context, key, owner string) (bool, error)
+}
+
+type locker struct {
+	backend lockBackend
+	options lockerOptions
+}
+
+// NewLocker returns the Locker matching the backend of c: Redis for
+// RedisCache and TieredCache, in-process for InMemoryCache. Instrumented
+// caches are unwrapped; anything else, including Switchable whose backend
+// may change, returns ErrNotSupported.
+func NewLocker(c Cache, opts ...LockerOption) (Locker, error) {
+	switch c := c.(type) {
+	case *RedisCache:
+		return NewRedisLocker(c, opts...), nil
+	case *TieredCache:
+		return NewRedisLocker(c.l2, opts...), nil
+	case *InMemoryCache:
+		return NewInMemoryLocker(c, opts...), nil
+	case *Instrumented:
+		return NewLocker(c.Unwrap(), opts...)
+	}
+	return nil, ErrNotSupported
+}
+
+func newLocker(backend lockBackend, opts []LockerOption) *locker {
+	options := lockerOptions{
+		prefix:        defaultLockPrefix,
+		retryInterval: defaultLockRetryInterval,
+		autoRenew:     true,
+	}
+	for _, opt := range opts {
+		opt(&options)
+	}
+	if options.retryInterval <= 0 {
+		options.retryInterval = defaultLockRetryInterval
+	}
+	return &locker{backend: backend, options: options}
+}
+
+func (l *locker) Lock(ctx context.Context, key string, ttl time.Duration) (*Lock, error) {
+	for {
+		lock, err := l.TryLock(ctx, key, ttl)
+		if !errors.Is(err, ErrLockNotObtained) {
+			return lock, err
+		}
+		select {
+		case <-time.After(l.options.retryInterval):
+		case <-ctx.Done():
+			return nil, &LockError{Key: key, Err: fmt.Errorf("%w: %w", ErrLockNotObtained, ctx.Err())}
+		}
+	}
+}
+
+func (l *locker) TryLock(ctx context.Context, key string, ttl time.Duration) (*Lock, error) {
+	if ttl <= 0 {
+		return nil, ErrInvalidExpiration
+	}
+
+	owner := uuid.NewString()
+	token, err := l.backend.acquire(ctx, l.options.prefix+key, owner, ttl)
+	if err != nil {
+		return nil, &LockError{Key: key, Err: err}
+	}
+	if token == 0 {
+		return nil, &LockError{Key: key, Err: ErrLockNotObtained}
+	}
+
+	lock := &Lock{
+		key:     key,
+		name:    l.options.prefix + key,
+		owner:   owner,
+		token:   token,
+		ttl:     ttl,
+		backend: l.backend,
+		lost:    make(chan struct{}),
+		stop:    make(chan struct{}),
+	}
+	if l.options.autoRenew {
+		lock.wg.Add(1)
+		go lock.renew()
+	}
+	return lock, nil
+}
+
+// Lock is a held lock. It is safe for concurrent use.
+type Lock struct {
+	key     string
+	name    string // key including the locker prefix
+	owner   string
+	token   uint64
+	backend lockBackend
+
+	mu  sync.Mutex
+	ttl time.Duration
+
+	lost     chan struct{}
+	lostOnce sync.Once
+	stop     chan struct{}
+	stopOnce sync.Once
+	wg       sync.WaitGroup
+}
+
+// Key returns the locked key.
+func (l *Lock) Key() string {
+	return l.key
+}
+
+// Token returns the fencing token of this acquisition. Tokens of a key
+// increase with every acquisition, so a resource can reject writes carrying
+// a token lower than one it has already seen from a newer holder.
+func (l *Lock) Token() uint64 {
+	return l.token
+}
+
+// Lost is closed once the lock is known to be lost: an extension found it
+// expired or held by someone else, or auto-renewal failed for a whole TTL.
+// Work guarded by the lock should stop when it fires.
+func (l *Lock) Lost() <-chan struct{} {
+	return l.lost
+}
+
+// Extend resets the TTL of the lock, which is also used for auto-renewal
+// from now on. It returns ErrLockLost if the lock is no longer held.
+func (l *Lock) Extend(ctx context.Context, ttl time.Duration) error {
+	if ttl <= 0 {
+		return ErrInvalidExpiration
+	}
+	held, err := l.backend.extend(ctx, l.name, l.owner, ttl)
+	if err != nil {
+		return &LockError{Key: l.key, Token: l.token, Err: err}
+	}
+	if !held {
+		l.markLost()
+		return &LockError{Key: l.key, Token: l.token, Err: ErrLockLost}
+	}
+	l.mu.Lock()
+	l.ttl = ttl
+	l.mu.Unlock()
+	return nil
+}
+
+// Unlock stops auto-renewal and releases the lock. It returns ErrLockLost if
+// the lock had already expired or been taken over.
+func (l *Lock) Unlock(ctx context.Context) error {
+	l.stopOnce.Do(func() { close(l.stop) })
+	l.wg.Wait()
+
+	held, err := l.backend.release(ctx, l.name, l.owner)
+	if err != nil {
+		return &LockError{Key: l.key, Token: l.token, Err: err}
+	}
+	if !held {
+		l.markLost()
+		return &LockError{Key: l.key, Token: l.token, Err: ErrLockLost}
+	}
+	return nil
+}
+
+func (l *Lock) markLost() {
+	l.lostOnce.Do(func() { close(l.lost) })
+}
+
+// renew extends the lock every third of its TTL until it is unlocked or
+// lost. Transient errors are retried until a whole TTL has passed without a
+// successful extension, after which the lock may have been taken over.
+func (l *Lock) renew() {
+	defer l.wg.Done()
+
+	lastExtended := time.Now()
+	for {
+		l.mu.Lock()
+		ttl := l.ttl
+		l.mu.Unlock()
+
+		select {
+		case <-time.After(ttl / 3):
+		case <-l.stop:
+			return
+		case <-l.lost:
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), ttl/3)
+		err := l.Extend(ctx, ttl)
+		cancel()
+		switch {
+		case err == nil:
+			lastExtended = time.Now()
+		case errors.Is(err, ErrLockLost):
+			return
+		case time.Since(lastExtended) >= ttl:
+			l.markLost()
+			return
+		}
+	}
+}
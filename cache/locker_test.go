@@ -0,0 +1,260 @@
+package cache_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mauriciomartinezc/real-estate-mc-common/cache"
+)
+
+// newLockers returns a function creating lockers that share one backend,
+// as replicas of a service would.
+type newLockers func(t *testing.T, opts ...cache.LockerOption) cache.Locker
+
+// runLockerTests runs the Locker conformance tests against both backends.
+func runLockerTests(t *testing.T, test func(t *testing.T, newLocker newLockers)) {
+	t.Run("InMemory", func(t *testing.T) {
+		c := cache.NewInMemoryCache()
+		test(t, func(t *testing.T, opts ...cache.LockerOption) cache.Locker {
+			return cache.NewInMemoryLocker(c, opts...)
+		})
+	})
+	t.Run("Redis", func(t *testing.T) {
+		addr := newMiniredis(t).Addr()
+		test(t, func(t *testing.T, opts ...cache.LockerOption) cache.Locker {
+			return cache.NewRedisLocker(newRedisCache(t, addr), opts...)
+		})
+	})
+}
+
+func tryLock(t *testing.T, locker cache.Locker, key string, ttl time.Duration) *cache.Lock {
+	t.Helper()
+	lock, err := locker.TryLock(context.Background(), key, ttl)
+	if err != nil {
+		t.Fatalf("TryLock: %v", err)
+	}
+	return lock
+}
+
+func TestLockerMutualExclusion(t *testing.T) {
+	runLockerTests(t, func(t *testing.T, newLocker newLockers) {
+		ctx := context.Background()
+		a, b := newLocker(t), newLocker(t)
+
+		held := tryLock(t, a, "job", time.Minute)
+		_, err := b.TryLock(ctx, "job", time.Minute)
+		var lockErr *cache.LockError
+		if !errors.Is(err, cache.ErrLockNotObtained) || !errors.As(err, &lockErr) || lockErr.Key != "job" {
+			t.Fatalf("TryLock of a held key error = %v, want a LockError with ErrLockNotObtained", err)
+		}
+		if other := tryLock(t, b, "other", time.Minute); other.Key() != "other" {
+			t.Fatalf("Key = %q, want other", other.Key())
+		}
+		if err := held.Unlock(ctx); err != nil {
+			t.Fatalf("Unlock: %v", err)
+		}
+		tryLock(t, b, "job", time.Minute)
+
+		if _, err := a.TryLock(ctx, "job", 0); !errors.Is(err, cache.ErrInvalidExpiration) {
+			t.Fatalf("TryLock with a zero TTL error = %v, want ErrInvalidExpiration", err)
+		}
+	})
+}
+
+func TestLockerSerializesConcurrentHolders(t *testing.T) {
+	runLockerTests(t, func(t *testing.T, newLocker newLockers) {
+		var inside, overlaps, done atomic.Int32
+		var wg sync.WaitGroup
+		for i := 0; i < 4; i++ {
+			locker := newLocker(t, cache.WithLockRetryInterval(time.Millisecond))
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for j := 0; j < 5; j++ {
+					ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+					lock, err := locker.Lock(ctx, "counter", time.Minute)
+					cancel()
+					if err != nil {
+						t.Errorf("Lock: %v", err)
+						return
+					}
+					if inside.Add(1) > 1 {
+						overlaps.Add(1)
+					}
+					time.Sleep(time.Millisecond)
+					inside.Add(-1)
+					done.Add(1)
+					if err := lock.Unlock(context.Background()); err != nil {
+						t.Errorf("Unlock: %v", err)
+					}
+				}
+			}()
+		}
+		wg.Wait()
+		if overlaps.Load() != 0 || done.Load() != 20 {
+			t.Fatalf("%d overlapping holders over %d acquisitions, want none over 20", overlaps.Load(), done.Load())
+		}
+	})
+}
+
+func TestLockerLockWaitsForRelease(t *testing.T) {
+	runLockerTests(t, func(t *testing.T, newLocker newLockers) {
+		a := newLocker(t)
+		b := newLocker(t, cache.WithLockRetryInterval(5*time.Millisecond))
+		held := tryLock(t, a, "job", time.Minute)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Millisecond)
+		_, err := b.Lock(ctx, "job", time.Minute)
+		cancel()
+		// The deadline may end a backend call too, so only it is checked.
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("Lock of a held key error = %v, want DeadlineExceeded", err)
+		}
+
+		go func() {
+			time.Sleep(30 * time.Millisecond)
+			_ = held.Unlock(context.Background())
+		}()
+		ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if _, err := b.Lock(ctx, "job", time.Minute); err != nil {
+			t.Fatalf("Lock after the release: %v", err)
+		}
+	})
+}
+
+func TestLockerFencingTokensIncrease(t *testing.T) {
+	runLockerTests(t, func(t *testing.T, newLocker newLockers) {
+		ctx := context.Background()
+		a, b := newLocker(t, cache.WithAutoRenew(false)), newLocker(t, cache.WithAutoRenew(false))
+
+		first := tryLock(t, a, "job", 50*time.Millisecond)
+		time.Sleep(100 * time.Millisecond)
+		second := tryLock(t, b, "job", time.Minute)
+		if second.Token() <= first.Token() {
+			t.Fatalf("token after a takeover = %d, want more than %d", second.Token(), first.Token())
+		}
+		if err := second.Unlock(ctx); err != nil {
+			t.Fatalf("Unlock: %v", err)
+		}
+		tryLock(t, b, "other", time.Minute)
+		third := tryLock(t, a, "job", time.Minute)
+		if third.Token() <= second.Token() {
+			t.Fatalf("token after a release = %d, want more than %d", third.Token(), second.Token())
+		}
+	})
+}
+
+func TestLockerUnlockAfterLoss(t *testing.T) {
+	runLockerTests(t, func(t *testing.T, newLocker newLockers) {
+		ctx := context.Background()
+		a, b := newLocker(t, cache.WithAutoRenew(false)), newLocker(t, cache.WithAutoRenew(false))
+
+		// Expired, not taken over.
+		expired := tryLock(t, a, "expired", 30*time.Millisecond)
+		time.Sleep(80 * time.Millisecond)
+		if err := expired.Unlock(ctx); !errors.Is(err, cache.ErrLockLost) {
+			t.Fatalf("Unlock of an expired lock error = %v, want ErrLockLost", err)
+		}
+		select {
+		case <-expired.Lost():
+		default:
+			t.Fatal("Lost not closed after Unlock found the lock expired")
+		}
+
+		// Taken over: the stale holder must not release the new one.
+		stale := tryLock(t, a, "job", 30*time.Millisecond)
+		time.Sleep(80 * time.Millisecond)
+		current := tryLock(t, b, "job", time.Minute)
+		if err := stale.Unlock(ctx); !errors.Is(err, cache.ErrLockLost) {
+			t.Fatalf("Unlock of a taken over lock error = %v, want ErrLockLost", err)
+		}
+		if _, err := a.TryLock(ctx, "job", time.Minute); !errors.Is(err, cache.ErrLockNotObtained) {
+			t.Fatalf("TryLock after the stale Unlock error = %v, want the new holder kept", err)
+		}
+		if err := stale.Extend(ctx, time.Minute); !errors.Is(err, cache.ErrLockLost) {
+			t.Fatalf("Extend of a taken over lock error = %v, want ErrLockLost", err)
+		}
+		if err := current.Unlock(ctx); err != nil {
+			t.Fatalf("Unlock of the current holder: %v", err)
+		}
+	})
+}
+
+func TestLockerExtend(t *testing.T) {
+	runLockerTests(t, func(t *testing.T, newLocker newLockers) {
+		ctx := context.Background()
+		a, b := newLocker(t, cache.WithAutoRenew(false)), newLocker(t)
+
+		lock := tryLock(t, a, "job", 50*time.Millisecond)
+		if err := lock.Extend(ctx, time.Minute); err != nil {
+			t.Fatalf("Extend: %v", err)
+		}
+		time.Sleep(100 * time.Millisecond)
+		if _, err := b.TryLock(ctx, "job", time.Minute); !errors.Is(err, cache.ErrLockNotObtained) {
+			t.Fatalf("TryLock of an extended lock error = %v, want ErrLockNotObtained", err)
+		}
+		if err := lock.Extend(ctx, 0); !errors.Is(err, cache.ErrInvalidExpiration) {
+			t.Fatalf("Extend with a zero TTL error = %v, want ErrInvalidExpiration", err)
+		}
+		if err := lock.Unlock(ctx); err != nil {
+			t.Fatalf("Unlock: %v", err)
+		}
+	})
+}
+
+func TestLockerAutoRenew(t *testing.T) {
+	runLockerTests(t, func(t *testing.T, newLocker newLockers) {
+		ctx := context.Background()
+		a, b := newLocker(t), newLocker(t)
+
+		lock := tryLock(t, a, "job", 60*time.Millisecond)
+		time.Sleep(250 * time.Millisecond)
+		if _, err := b.TryLock(ctx, "job", time.Minute); !errors.Is(err, cache.ErrLockNotObtained) {
+			t.Fatalf("TryLock of a renewed lock error = %v, want ErrLockNotObtained", err)
+		}
+		select {
+		case <-lock.Lost():
+			t.Fatal("renewed lock reported lost")
+		default:
+		}
+		if err := lock.Unlock(ctx); err != nil {
+			t.Fatalf("Unlock: %v", err)
+		}
+
+		// Renewal stops at Unlock, so the key is free.
+		time.Sleep(100 * time.Millisecond)
+		tryLock(t, b, "job", time.Minute)
+	})
+}
+
+func TestRedisLockerSubMillisecondTTL(t *testing.T) {
+	locker := cache.NewRedisLocker(newRedisCache(t, newMiniredis(t).Addr()), cache.WithAutoRenew(false))
+	lock, err := locker.TryLock(context.Background(), "job", 500*time.Microsecond)
+	if err != nil {
+		t.Fatalf("TryLock with a TTL under 1ms: %v", err)
+	}
+	if lock.Token() == 0 {
+		t.Fatal("lock has no fencing token")
+	}
+}
+
+func TestNewLocker(t *testing.T) {
+	memory := cache.NewInMemoryCache()
+	for name, c := range map[string]cache.Cache{
+		"InMemory":     memory,
+		"Redis":        newRedisCache(t, newMiniredis(t).Addr()),
+		"Instrumented": cache.NewInstrumented(memory, cache.InstrumentedConfig{}),
+	} {
+		if _, err := cache.NewLocker(c); err != nil {
+			t.Fatalf("NewLocker of %s: %v", name, err)
+		}
+	}
+	if _, err := cache.NewLocker(cache.NewSwitchable(memory)); !errors.Is(err, cache.ErrNotSupported) {
+		t.Fatalf("NewLocker of a Switchable error = %v, want ErrNotSupported", err)
+	}
+}
@@ -6,72 +6,460 @@ import (
 	"errors"
 	"fmt"
 	"github.com/go-redis/redis/v8"
+	"reflect"
+	"strings"
+	"sync"
 	"time"
 )
 
+// defaultTimeout bounds the context-less methods, which have no caller
+// deadline to honor.
+const defaultTimeout = 5 * time.Second
+
 type RedisCache struct {
-	client *redis.Client
+	client redis.UniversalClient
 }
 
-func NewRedisCache(addr, password string, db int) *RedisCache {
-	client := redis.NewClient(&redis.Options{
-		Addr:     addr,
+// NewRedisCache connects to a single Redis node, verifying the connection
+// with a Ping bounded by a 5 second timeout.
+func NewRedisCache(addr, password string, db int) (*RedisCache, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+	return NewRedisCacheWithConfig(ctx, RedisConfig{
+		Addrs:    []string{addr},
 		Password: password,
 		DB:       db,
 	})
+}
+
+// NewRedisCacheWithConfig connects to the single node, Sentinel or Cluster
+// deployment described by config and verifies the connection with a Ping,
+// retrying with exponential backoff up to config.ConnectRetries times or
+// until ctx is done.
+func NewRedisCacheWithConfig(ctx context.Context, config RedisConfig) (*RedisCache, error) {
+	client := config.NewClient()
 
 	// Test the connection to Redis
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-	if err := client.Ping(ctx).Err(); err != nil {
-		panic(fmt.Sprintf("Failed to connect to Redis: %v", err))
+	err := client.Ping(ctx).Err()
+	for attempt := 1; err != nil && attempt <= config.ConnectRetries; attempt++ {
+		select {
+		case <-time.After(config.backoff(attempt)):
+		case <-ctx.Done():
+			client.Close()
+			return nil, fmt.Errorf("failed to connect to Redis: %w", err)
+		}
+		err = client.Ping(ctx).Err()
+	}
+	if err != nil {
+		client.Close()
+		return nil, fmt.Errorf("failed to connect to Redis: %w", err)
 	}
 
+	return &RedisCache{client: client}, nil
+}
+
+// NewRedisCacheFromClient wraps an existing client, e.g. one shared with
+// other components or pointed at a test server. No Ping is performed.
+func NewRedisCacheFromClient(client redis.UniversalClient) *RedisCache {
 	return &RedisCache{client: client}
 }
 
+// Client returns the underlying Redis client.
+func (r *RedisCache) Client() redis.UniversalClient {
+	return r.client
+}
+
+// Close closes the underlying Redis client.
+func (r *RedisCache) Close() error {
+	return r.client.Close()
+}
+
+// HealthCheck pings Redis, see HealthChecker.
+func (r *RedisCache) HealthCheck(ctx context.Context) error {
+	if err := r.client.Ping(ctx).Err(); err != nil {
+		return fmt.Errorf("error pinging Redis: %w", err)
+	}
+	return nil
+}
+
 // Set saves data in Redis cache with JSON serialization and a specified expiration
 func (r *RedisCache) Set(key string, value interface{}, expiration time.Duration) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+	return r.SetContext(ctx, key, value, expiration)
+}
+
+// Get retrieves data from Redis cache and deserializes it into the provided destination
+func (r *RedisCache) Get(key string, dest interface{}) error {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+	return r.GetContext(ctx, key, dest)
+}
+
+// Delete removes a key from Redis cache
+func (r *RedisCache) Delete(key string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
 	defer cancel()
+	return r.DeleteContext(ctx, key)
+}
+
+// SetContext saves data in Redis with JSON serialization, bounded by ctx.
+// Sub-second expirations are kept with millisecond precision and zero means
+// the key never expires.
+func (r *RedisCache) SetContext(ctx context.Context, key string, value interface{}, expiration time.Duration) error {
+	if expiration < 0 {
+		return ErrInvalidExpiration
+	}
 
 	// Serialize the value to JSON
 	jsonData, err := json.Marshal(value)
 	if err != nil {
-		return fmt.Errorf("error marshaling data to JSON: %v", err)
+		return fmt.Errorf("error marshaling data to JSON: %w", err)
 	}
 
 	// Set the data in Redis with expiration
 	return r.client.Set(ctx, key, jsonData, expiration).Err()
 }
 
-// Get retrieves data from Redis cache and deserializes it into the provided destination
-func (r *RedisCache) Get(key string, dest interface{}) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
+// GetContext retrieves data from Redis into dest, bounded by ctx. A missing
+// key is reported as ErrNotFound and undecodable data as *TypeMismatchError.
+func (r *RedisCache) GetContext(ctx context.Context, key string, dest interface{}) error {
+	val, err := r.getRaw(ctx, key)
+	if err != nil {
+		return err
+	}
+
+	// Deserialize JSON data into the destination
+	return decodeJSON(key, val, dest)
+}
+
+// DeleteContext removes a key from Redis, bounded by ctx
+func (r *RedisCache) DeleteContext(ctx context.Context, key string) error {
+	return r.client.Del(ctx, key).Err()
+}
+
+// scanBatchSize is the COUNT hint used when scanning keys for
+// DeleteByPrefix; matching keys are unlinked in batches of the same size.
+const scanBatchSize = 500
+
+// GetMany fetches all keys with a single MGET (a pipeline of GETs on Redis
+// Cluster) and decodes the existing ones into dest, a pointer to a
+// map[string]T.
+func (r *RedisCache) GetMany(ctx context.Context, keys []string, dest interface{}) error {
+	m, err := mapDest(dest)
+	if err != nil {
+		return err
+	}
+
+	values, err := r.getManyRaw(ctx, keys)
+	if err != nil {
+		return err
+	}
+	return decodeJSONMap(values, m)
+}
+
+// SetMany stores all items in a single pipeline.
+func (r *RedisCache) SetMany(ctx context.Context, items map[string]interface{}, expiration time.Duration) error {
+	if expiration < 0 {
+		return ErrInvalidExpiration
+	}
+
+	encoded, err := encodeJSONMap(items)
+	if err != nil {
+		return err
+	}
+	return r.setManyRaw(ctx, encoded, expiration)
+}
+
+// DeleteMany removes all keys with a single DEL, or a pipeline of DELs on
+// Redis Cluster where keys may live in different slots.
+func (r *RedisCache) DeleteMany(ctx context.Context, keys ...string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	if !r.isCluster() {
+		return r.client.Del(ctx, keys...).Err()
+	}
+	_, err := r.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, key := range keys {
+			pipe.Del(ctx, key)
+		}
+		return nil
+	})
+	return err
+}
+
+// DeleteByPrefix walks the keyspace with SCAN and unlinks every key starting
+// with prefix. On Redis Cluster every master is scanned. It does not block
+// Redis the way KEYS would, but keys written concurrently may be missed.
+func (r *RedisCache) DeleteByPrefix(ctx context.Context, prefix string) (int, error) {
+	pattern := escapeGlob(prefix) + "*"
+
+	cluster, ok := r.client.(*redis.ClusterClient)
+	if !ok {
+		return r.scanDelete(ctx, r.client, pattern)
+	}
+
+	var mu sync.Mutex
+	deleted := 0
+	err := cluster.ForEachMaster(ctx, func(ctx context.Context, node *redis.Client) error {
+		n, err := r.scanDelete(ctx, node, pattern)
+		mu.Lock()
+		deleted += n
+		mu.Unlock()
+		return err
+	})
+	return deleted, err
+}
+
+// scanDelete unlinks every key of node matching pattern.
+func (r *RedisCache) scanDelete(ctx context.Context, node redis.Cmdable, pattern string) (int, error) {
+	deleted := 0
+	var cursor uint64
+	for {
+		keys, next, err := node.Scan(ctx, cursor, pattern, scanBatchSize).Result()
+		if err != nil {
+			return deleted, fmt.Errorf("error scanning Redis keys: %w", err)
+		}
+		if len(keys) > 0 {
+			n, err := r.unlink(ctx, keys)
+			if err != nil {
+				return deleted, fmt.Errorf("error deleting Redis keys: %w", err)
+			}
+			deleted += n
+		}
+		cursor = next
+		if cursor == 0 {
+			return deleted, nil
+		}
+	}
+}
+
+// unlink removes keys without blocking Redis, one key per command on
+// Redis Cluster to avoid cross-slot errors.
+func (r *RedisCache) unlink(ctx context.Context, keys []string) (int, error) {
+	if !r.isCluster() {
+		n, err := r.client.Unlink(ctx, keys...).Result()
+		return int(n), err
+	}
+
+	cmds, err := r.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, key := range keys {
+			pipe.Unlink(ctx, key)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	deleted := 0
+	for _, cmd := range cmds {
+		deleted += int(cmd.(*redis.IntCmd).Val())
+	}
+	return deleted, nil
+}
+
+// isCluster reports whether the client talks to Redis Cluster, where
+// multi-key commands must not span hash slots.
+func (r *RedisCache) isCluster() bool {
+	_, ok := r.client.(*redis.ClusterClient)
+	return ok
+}
+
+// escapeGlob escapes the characters Redis treats as glob patterns in MATCH.
+func escapeGlob(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch r {
+		case '*', '?', '[', ']', '\\':
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
 
+// getRaw returns the JSON stored under key.
+func (r *RedisCache) getRaw(ctx context.Context, key string) ([]byte, error) {
 	// Get the JSON data from Redis
-	val, err := r.client.Get(ctx, key).Result()
+	val, err := r.client.Get(ctx, key).Bytes()
 	if errors.Is(err, redis.Nil) {
 		// Key does not exist in Redis
-		return fmt.Errorf("key not found")
+		return nil, ErrNotFound
 	} else if err != nil {
 		// Other Redis errors
-		return fmt.Errorf("error retrieving data from Redis: %v", err)
+		return nil, fmt.Errorf("error retrieving data from Redis: %w", err)
 	}
+	return val, nil
+}
 
-	// Deserialize JSON data into the destination
-	if err := json.Unmarshal([]byte(val), dest); err != nil {
-		return fmt.Errorf("error unmarshaling JSON data: %v", err)
+// getManyRaw returns the JSON stored under every existing key.
+func (r *RedisCache) getManyRaw(ctx context.Context, keys []string) (map[string][]byte, error) {
+	found := make(map[string][]byte, len(keys))
+	if len(keys) == 0 {
+		return found, nil
+	}
+	if r.isCluster() {
+		return r.getManyPipelined(ctx, keys)
+	}
+
+	values, err := r.client.MGet(ctx, keys...).Result()
+	if err != nil {
+		return nil, fmt.Errorf("error retrieving data from Redis: %w", err)
+	}
+	for i, raw := range values {
+		// nil means the key does not exist
+		if s, ok := raw.(string); ok {
+			found[keys[i]] = []byte(s)
+		}
+	}
+	return found, nil
+}
+
+// getManyPipelined fetches keys with a pipeline of GETs, which Redis
+// Cluster routes per slot.
+func (r *RedisCache) getManyPipelined(ctx context.Context, keys []string) (map[string][]byte, error) {
+	cmds, err := r.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, key := range keys {
+			pipe.Get(ctx, key)
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return nil, fmt.Errorf("error retrieving data from Redis: %w", err)
+	}
+
+	found := make(map[string][]byte, len(keys))
+	for i, cmd := range cmds {
+		val, err := cmd.(*redis.StringCmd).Bytes()
+		if errors.Is(err, redis.Nil) {
+			continue
+		} else if err != nil {
+			return nil, fmt.Errorf("error retrieving data from Redis: %w", err)
+		}
+		found[keys[i]] = val
+	}
+	return found, nil
+}
+
+// getRawTTL returns the JSON stored under key and its remaining time to
+// live, zero if it has none, read together in one transaction.
+func (r *RedisCache) getRawTTL(ctx context.Context, key string) ([]byte, time.Duration, error) {
+	var get *redis.StringCmd
+	var pttl *redis.DurationCmd
+	_, err := r.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		get = pipe.Get(ctx, key)
+		pttl = pipe.PTTL(ctx, key)
+		return nil
+	})
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return nil, 0, fmt.Errorf("error retrieving data from Redis: %w", err)
+	}
+	val, err := get.Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, 0, ErrNotFound
+	} else if err != nil {
+		return nil, 0, fmt.Errorf("error retrieving data from Redis: %w", err)
+	}
+	return val, remainingTTL(pttl.Val()), nil
+}
+
+// getManyRawTTL returns the JSON stored under every existing key and its
+// remaining time to live, zero if it has none, with a pipeline of GET and
+// PTTL per key.
+func (r *RedisCache) getManyRawTTL(ctx context.Context, keys []string) (map[string][]byte, map[string]time.Duration, error) {
+	found := make(map[string][]byte, len(keys))
+	ttls := make(map[string]time.Duration, len(keys))
+	if len(keys) == 0 {
+		return found, ttls, nil
+	}
+	gets := make([]*redis.StringCmd, len(keys))
+	pttls := make([]*redis.DurationCmd, len(keys))
+	_, err := r.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, key := range keys {
+			gets[i] = pipe.Get(ctx, key)
+			pttls[i] = pipe.PTTL(ctx, key)
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return nil, nil, fmt.Errorf("error retrieving data from Redis: %w", err)
+	}
+	for i, key := range keys {
+		val, err := gets[i].Bytes()
+		if errors.Is(err, redis.Nil) {
+			continue
+		} else if err != nil {
+			return nil, nil, fmt.Errorf("error retrieving data from Redis: %w", err)
+		}
+		found[key] = val
+		ttls[key] = remainingTTL(pttls[i].Val())
+	}
+	return found, ttls, nil
+}
+
+// remainingTTL maps the -1 (no TTL) and -2 (missing) PTTL replies, which
+// go-redis passes through unscaled, to zero.
+func remainingTTL(ttl time.Duration) time.Duration {
+	if ttl < 0 {
+		return 0
+	}
+	return ttl
+}
+
+// setRaw stores already encoded JSON under key.
+func (r *RedisCache) setRaw(ctx context.Context, key string, data []byte, expiration time.Duration) error {
+	return r.client.Set(ctx, key, data, expiration).Err()
+}
+
+// setManyRaw stores already encoded JSON values in a single pipeline.
+func (r *RedisCache) setManyRaw(ctx context.Context, items map[string][]byte, expiration time.Duration) error {
+	if len(items) == 0 {
+		return nil
+	}
+	_, err := r.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for key, data := range items {
+			pipe.Set(ctx, key, data, expiration)
+		}
+		return nil
+	})
+	return err
+}
+
+// decodeJSON unmarshals data stored under key into dest.
+func decodeJSON(key string, data []byte, dest interface{}) error {
+	if err := json.Unmarshal(data, dest); err != nil {
+		var invalid *json.InvalidUnmarshalError
+		if errors.As(err, &invalid) {
+			return ErrInvalidDestination
+		}
+		return &TypeMismatchError{Key: key, Dest: reflect.TypeOf(dest).Elem(), Err: err}
 	}
 	return nil
 }
 
-// Delete removes a key from Redis cache
-func (r *RedisCache) Delete(key string) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
+// decodeJSONMap unmarshals every value of values into a new element of m.
+func decodeJSONMap(values map[string][]byte, m reflect.Value) error {
+	elemType := m.Type().Elem()
+	for key, data := range values {
+		v := reflect.New(elemType)
+		if err := decodeJSON(key, data, v.Interface()); err != nil {
+			return err
+		}
+		m.SetMapIndex(reflect.ValueOf(key).Convert(m.Type().Key()), v.Elem())
+	}
+	return nil
+}
 
-	// Delete the key from Redis
-	return r.client.Del(ctx, key).Err()
+// encodeJSONMap marshals every value of items.
+func encodeJSONMap(items map[string]interface{}) (map[string][]byte, error) {
+	encoded := make(map[string][]byte, len(items))
+	for key, value := range items {
+		jsonData, err := json.Marshal(value)
+		if err != nil {
+			return nil, fmt.Errorf("error marshaling data to JSON for key %q: %w", key, err)
+		}
+		encoded[key] = jsonData
+	}
+	return encoded, nil
 }
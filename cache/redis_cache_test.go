@@ -0,0 +1,64 @@
+package cache_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/mauriciomartinezc/real-estate-mc-common/cache"
+	"github.com/mauriciomartinezc/real-estate-mc-common/cache/cachetest"
+)
+
+// newMiniredis starts a miniredis server whose clock follows real time, so
+// keys expire as they would in Redis.
+func newMiniredis(t *testing.T) *miniredis.Miniredis {
+	t.Helper()
+	s := miniredis.RunT(t)
+	const step = 10 * time.Millisecond
+	done := make(chan struct{})
+	t.Cleanup(func() { close(done) })
+	go func() {
+		ticker := time.NewTicker(step)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				s.FastForward(step)
+			case <-done:
+				return
+			}
+		}
+	}()
+	return s
+}
+
+func newRedisCache(t *testing.T, addr string) *cache.RedisCache {
+	t.Helper()
+	r, err := cache.NewRedisCache(addr, "", 0)
+	if err != nil {
+		t.Fatalf("NewRedisCache: %v", err)
+	}
+	t.Cleanup(func() { r.Close() })
+	return r
+}
+
+func TestRedisCacheConformance(t *testing.T) {
+	cachetest.Run(t, func(t *testing.T) cache.Cache {
+		return newRedisCache(t, newMiniredis(t).Addr())
+	})
+}
+
+func TestRedisCacheIncrSubMillisecondTTL(t *testing.T) {
+	ctx := context.Background()
+	server := miniredis.RunT(t)
+	r := newRedisCache(t, server.Addr())
+
+	if _, err := r.Incr(ctx, "hits", 1, 500*time.Microsecond); err != nil {
+		t.Fatalf("Incr: %v", err)
+	}
+	// Rounded up to 1ms instead of down to no expiration.
+	if ttl := server.TTL("hits"); ttl != time.Millisecond {
+		t.Fatalf("TTL = %s, want 1ms", ttl)
+	}
+}
@@ -0,0 +1,95 @@
+package cache
+
+import (
+	"crypto/tls"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// RedisConfig describes how to connect to Redis. The topology is derived
+// from the fields: MasterName selects Sentinel failover, Cluster or more
+// than one address selects Redis Cluster, anything else a single node.
+type RedisConfig struct {
+	// Addrs holds a single host:port, or the seed list of Sentinel or
+	// Cluster nodes.
+	Addrs []string
+	// MasterName is the Sentinel master name.
+	MasterName       string
+	SentinelUsername string
+	SentinelPassword string
+	// Cluster forces a Cluster client even with a single seed address.
+	Cluster bool
+
+	// Username and Password authenticate with Redis 6 ACLs; Username may be
+	// empty for the legacy AUTH password.
+	Username string
+	Password string
+	// DB is ignored by Redis Cluster.
+	DB int
+
+	// TLS enables TLS when non-nil, see security.NewTLSConfig.
+	TLS *tls.Config
+
+	PoolSize     int
+	MinIdleConns int
+	DialTimeout  time.Duration
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+
+	// ConnectRetries is how many times the initial Ping is retried before
+	// NewRedisCacheWithConfig gives up.
+	ConnectRetries int
+	// ConnectBackoff is the wait before the first retry; it doubles after
+	// every attempt up to ConnectMaxBackoff. Defaults to 500ms and 10s.
+	ConnectBackoff    time.Duration
+	ConnectMaxBackoff time.Duration
+}
+
+const (
+	defaultConnectBackoff    = 500 * time.Millisecond
+	defaultConnectMaxBackoff = 10 * time.Second
+)
+
+// backoff returns the wait before retry number attempt (starting at 1).
+func (c RedisConfig) backoff(attempt int) time.Duration {
+	wait, limit := c.ConnectBackoff, c.ConnectMaxBackoff
+	if wait <= 0 {
+		wait = defaultConnectBackoff
+	}
+	if limit <= 0 {
+		limit = defaultConnectMaxBackoff
+	}
+	for i := 1; i < attempt && wait < limit; i++ {
+		wait *= 2
+	}
+	if wait > limit {
+		wait = limit
+	}
+	return wait
+}
+
+// NewClient creates the redis.UniversalClient matching the configured
+// topology. It does not contact the server.
+func (c RedisConfig) NewClient() redis.UniversalClient {
+	options := &redis.UniversalOptions{
+		Addrs:            c.Addrs,
+		MasterName:       c.MasterName,
+		SentinelUsername: c.SentinelUsername,
+		SentinelPassword: c.SentinelPassword,
+		Username:         c.Username,
+		Password:         c.Password,
+		DB:               c.DB,
+		TLSConfig:        c.TLS,
+		PoolSize:         c.PoolSize,
+		MinIdleConns:     c.MinIdleConns,
+		DialTimeout:      c.DialTimeout,
+		ReadTimeout:      c.ReadTimeout,
+		WriteTimeout:     c.WriteTimeout,
+	}
+
+	if c.Cluster && c.MasterName == "" {
+		return redis.NewClusterClient(options.Cluster())
+	}
+	return redis.NewUniversalClient(options)
+}
@@ -0,0 +1,111 @@
+package cache
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+)
+
+// incrScript runs INCRBY and sets the TTL only when the key has none, so a
+// window started by the first increment is not pushed back by later ones.
+var incrScript = redis.NewScript(`
+local value = redis.call("INCRBY", KEYS[1], ARGV[1])
+if tonumber(ARGV[2]) > 0 and redis.call("PTTL", KEYS[1]) == -1 then
+	redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+return value
+`)
+
+// incrWindowScript keeps one sorted set member per event scored by its time
+// in microseconds. The server clock is used so replicas with skewed clocks
+// still agree on the window.
+var incrWindowScript = redis.NewScript(`
+local time = redis.call("TIME")
+local now = tonumber(time[1]) * 1000000 + tonumber(time[2])
+local window = tonumber(ARGV[1])
+redis.call("ZREMRANGEBYSCORE", KEYS[1], "-inf", now - window)
+redis.call("ZADD", KEYS[1], now, ARGV[2])
+redis.call("PEXPIRE", KEYS[1], math.ceil(window / 1000))
+return redis.call("ZCARD", KEYS[1])
+`)
+
+var windowCountScript = redis.NewScript(`
+local time = redis.call("TIME")
+local now = tonumber(time[1]) * 1000000 + tonumber(time[2])
+return redis.call("ZCOUNT", KEYS[1], "(" .. (now - tonumber(ARGV[1])), "+inf")
+`)
+
+// Incr adds delta to the integer stored under key with INCRBY, see Counter.
+func (r *RedisCache) Incr(ctx context.Context, key string, delta int64, ttl time.Duration) (int64, error) {
+	if ttl < 0 {
+		return 0, ErrInvalidExpiration
+	}
+	return incrScript.Run(ctx, r.client, []string{key}, delta, ceilMillis(ttl)).Int64()
+}
+
+// ceilMillis rounds ttl up to whole milliseconds for PX and PEXPIRE, so a
+// key never expires earlier than requested and TTLs under 1ms do not
+// become 0, which Redis rejects or the scripts read as no expiration.
+func ceilMillis(ttl time.Duration) int64 {
+	return int64((ttl + time.Millisecond - 1) / time.Millisecond)
+}
+
+// Decr subtracts delta from the integer stored under key with DECRBY, see
+// Counter.
+func (r *RedisCache) Decr(ctx context.Context, key string, delta int64) (int64, error) {
+	return r.client.DecrBy(ctx, key, delta).Result()
+}
+
+// Expire sets the expiration of an existing key with PEXPIRE, or PERSIST for
+// a zero ttl, see Counter.
+func (r *RedisCache) Expire(ctx context.Context, key string, ttl time.Duration) (bool, error) {
+	if ttl < 0 {
+		return false, ErrInvalidExpiration
+	}
+	if ttl == 0 {
+		// PERSIST reports false for keys without a TTL too.
+		persisted, err := r.client.Persist(ctx, key).Result()
+		if err != nil || persisted {
+			return persisted, err
+		}
+		exists, err := r.client.Exists(ctx, key).Result()
+		return exists == 1, err
+	}
+	return r.client.PExpire(ctx, key, ttl).Result()
+}
+
+// TTL returns the remaining time to live of key with PTTL, see Counter.
+func (r *RedisCache) TTL(ctx context.Context, key string) (time.Duration, error) {
+	ttl, err := r.client.PTTL(ctx, key).Result()
+	if err != nil {
+		return 0, err
+	}
+	// go-redis passes the -2 (missing) and -1 (no TTL) replies through
+	// unscaled.
+	switch ttl {
+	case -2:
+		return 0, ErrNotFound
+	case -1:
+		return 0, nil
+	}
+	return ttl, nil
+}
+
+// IncrWindow records an event in a sorted set under key, see Counter.
+func (r *RedisCache) IncrWindow(ctx context.Context, key string, window time.Duration) (int64, error) {
+	if window <= 0 {
+		return 0, ErrInvalidExpiration
+	}
+	return incrWindowScript.Run(ctx, r.client, []string{key}, window.Microseconds(), uuid.NewString()).Int64()
+}
+
+// WindowCount counts the events recorded under key during the last window,
+// see Counter.
+func (r *RedisCache) WindowCount(ctx context.Context, key string, window time.Duration) (int64, error) {
+	if window <= 0 {
+		return 0, ErrInvalidExpiration
+	}
+	return windowCountScript.Run(ctx, r.client, []string{key}, window.Microseconds()).Int64()
+}
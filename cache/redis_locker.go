@@ -0,0 +1,73 @@
+package cache
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// acquireScript sets the lock key if absent and bumps its fencing counter.
+// Both keys share a hash tag so the script also runs on Redis Cluster.
+var acquireScript = redis.NewScript(`
+if redis.call("SET", KEYS[1], ARGV[1], "NX", "PX", ARGV[2]) then
+	return redis.call("INCR", KEYS[2])
+end
+return 0
+`)
+
+// extendScript resets the TTL only if the caller still owns the lock.
+var extendScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+return 0
+`)
+
+// releaseScript deletes the lock only if the caller still owns it.
+var releaseScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0
+`)
+
+type redisLockBackend struct {
+	client redis.UniversalClient
+}
+
+// NewRedisLocker returns a Locker storing locks in the Redis behind r. Each
+// lock is a key set with SET NX PX holding a random owner ID; extension and
+// release are Lua scripts that check the owner first. Fencing tokens come
+// from a per-key counter that is never expired.
+func NewRedisLocker(r *RedisCache, opts ...LockerOption) Locker {
+	return newLocker(&redisLockBackend{client: r.client}, opts)
+}
+
+func (b *redisLockBackend) acquire(ctx context.Context, key, owner string, ttl time.Duration) (uint64, error) {
+	lockKey, fenceKey := redisLockKeys(key)
+	token, err := acquireScript.Run(ctx, b.client, []string{lockKey, fenceKey}, owner, ceilMillis(ttl)).Int64()
+	if err != nil {
+		return 0, err
+	}
+	return uint64(token), nil
+}
+
+func (b *redisLockBackend) extend(ctx context.Context, key, owner string, ttl time.Duration) (bool, error) {
+	lockKey, _ := redisLockKeys(key)
+	n, err := extendScript.Run(ctx, b.client, []string{lockKey}, owner, ceilMillis(ttl)).Int64()
+	return n == 1, err
+}
+
+func (b *redisLockBackend) release(ctx context.Context, key, owner string) (bool, error) {
+	lockKey, _ := redisLockKeys(key)
+	n, err := releaseScript.Run(ctx, b.client, []string{lockKey}, owner).Int64()
+	return n == 1, err
+}
+
+// redisLockKeys returns the lock and fencing counter keys of key, hash
+// tagged to the same cluster slot.
+func redisLockKeys(key string) (string, string) {
+	tagged := "{" + key + "}"
+	return tagged, tagged + ":fence"
+}
@@ -0,0 +1,171 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"time"
+)
+
+// ErrNotSupported is returned when an operation is not implemented by the
+// cache currently in use.
+var ErrNotSupported = errors.New("cache: operation not supported")
+
+// Switchable delegates to a cache that can be replaced at runtime, e.g. to
+// serve from memory while Redis is unreachable and switch over once it
+// recovers. It is safe for concurrent use. It implements BatchCache and
+// Counter whatever the current cache is, returning ErrNotSupported if it
+// does not; use AsBatchCache and AsCounter to check.
+type Switchable struct {
+	current atomic.Pointer[switchableTarget]
+}
+
+type switchableTarget struct {
+	cache ContextCache
+}
+
+// NewSwitchable returns a Switchable that initially delegates to c.
+func NewSwitchable(c Cache) *Switchable {
+	s := &Switchable{}
+	s.Switch(c)
+	return s
+}
+
+// Switch makes every subsequent call go to c and returns the previous cache
+// so the caller can close it.
+func (s *Switchable) Switch(c Cache) Cache {
+	previous := s.current.Swap(&switchableTarget{cache: ToContextCache(c)})
+	if previous == nil {
+		return nil
+	}
+	return previous.cache
+}
+
+// Current returns the cache calls are currently delegated to.
+func (s *Switchable) Current() ContextCache {
+	return s.current.Load().cache
+}
+
+func (s *Switchable) delegate() Cache {
+	return s.Current()
+}
+
+func (s *Switchable) Set(key string, value interface{}, expiration time.Duration) error {
+	return s.Current().Set(key, value, expiration)
+}
+
+func (s *Switchable) Get(key string, dest interface{}) error {
+	return s.Current().Get(key, dest)
+}
+
+func (s *Switchable) Delete(key string) error {
+	return s.Current().Delete(key)
+}
+
+func (s *Switchable) SetContext(ctx context.Context, key string, value interface{}, expiration time.Duration) error {
+	return s.Current().SetContext(ctx, key, value, expiration)
+}
+
+func (s *Switchable) GetContext(ctx context.Context, key string, dest interface{}) error {
+	return s.Current().GetContext(ctx, key, dest)
+}
+
+func (s *Switchable) DeleteContext(ctx context.Context, key string) error {
+	return s.Current().DeleteContext(ctx, key)
+}
+
+// GetMany delegates to the current cache, which must implement BatchCache.
+func (s *Switchable) GetMany(ctx context.Context, keys []string, dest interface{}) error {
+	batch, ok := s.Current().(BatchCache)
+	if !ok {
+		return ErrNotSupported
+	}
+	return batch.GetMany(ctx, keys, dest)
+}
+
+// SetMany delegates to the current cache, which must implement BatchCache.
+func (s *Switchable) SetMany(ctx context.Context, items map[string]interface{}, expiration time.Duration) error {
+	batch, ok := s.Current().(BatchCache)
+	if !ok {
+		return ErrNotSupported
+	}
+	return batch.SetMany(ctx, items, expiration)
+}
+
+// DeleteMany delegates to the current cache, which must implement BatchCache.
+func (s *Switchable) DeleteMany(ctx context.Context, keys ...string) error {
+	batch, ok := s.Current().(BatchCache)
+	if !ok {
+		return ErrNotSupported
+	}
+	return batch.DeleteMany(ctx, keys...)
+}
+
+// DeleteByPrefix delegates to the current cache, which must implement
+// BatchCache.
+func (s *Switchable) DeleteByPrefix(ctx context.Context, prefix string) (int, error) {
+	batch, ok := s.Current().(BatchCache)
+	if !ok {
+		return 0, ErrNotSupported
+	}
+	return batch.DeleteByPrefix(ctx, prefix)
+}
+
+// Incr delegates to the current cache, which must implement Counter.
+func (s *Switchable) Incr(ctx context.Context, key string, delta int64, ttl time.Duration) (int64, error) {
+	counter, ok := s.Current().(Counter)
+	if !ok {
+		return 0, ErrNotSupported
+	}
+	return counter.Incr(ctx, key, delta, ttl)
+}
+
+// Decr delegates to the current cache, which must implement Counter.
+func (s *Switchable) Decr(ctx context.Context, key string, delta int64) (int64, error) {
+	counter, ok := s.Current().(Counter)
+	if !ok {
+		return 0, ErrNotSupported
+	}
+	return counter.Decr(ctx, key, delta)
+}
+
+// Expire delegates to the current cache, which must implement Counter.
+func (s *Switchable) Expire(ctx context.Context, key string, ttl time.Duration) (bool, error) {
+	counter, ok := s.Current().(Counter)
+	if !ok {
+		return false, ErrNotSupported
+	}
+	return counter.Expire(ctx, key, ttl)
+}
+
+// TTL delegates to the current cache, which must implement Counter.
+func (s *Switchable) TTL(ctx context.Context, key string) (time.Duration, error) {
+	counter, ok := s.Current().(Counter)
+	if !ok {
+		return 0, ErrNotSupported
+	}
+	return counter.TTL(ctx, key)
+}
+
+// IncrWindow delegates to the current cache, which must implement Counter.
+func (s *Switchable) IncrWindow(ctx context.Context, key string, window time.Duration) (int64, error) {
+	counter, ok := s.Current().(Counter)
+	if !ok {
+		return 0, ErrNotSupported
+	}
+	return counter.IncrWindow(ctx, key, window)
+}
+
+// WindowCount delegates to the current cache, which must implement Counter.
+func (s *Switchable) WindowCount(ctx context.Context, key string, window time.Duration) (int64, error) {
+	counter, ok := s.Current().(Counter)
+	if !ok {
+		return 0, ErrNotSupported
+	}
+	return counter.WindowCount(ctx, key, window)
+}
+
+// HealthCheck checks the current cache, see CheckHealth.
+func (s *Switchable) HealthCheck(ctx context.Context) error {
+	return CheckHealth(ctx, s.Current())
+}
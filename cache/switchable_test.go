@@ -0,0 +1,43 @@
+package cache_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/mauriciomartinezc/real-estate-mc-common/cache"
+	"github.com/mauriciomartinezc/real-estate-mc-common/cache/cachetest"
+)
+
+func TestSwitchableConformance(t *testing.T) {
+	cachetest.Run(t, func(t *testing.T) cache.Cache {
+		return cache.NewSwitchable(cache.NewInMemoryCache())
+	})
+}
+
+func TestSwitchableCapabilitiesFollowTheCurrentCache(t *testing.T) {
+	ctx := context.Background()
+	s := cache.NewSwitchable(legacyCache{cache.NewInMemoryCache()})
+
+	if _, ok := cache.AsCounter(s); ok {
+		t.Fatal("AsCounter of a Switchable over a cache without counters = true")
+	}
+	if _, ok := cache.AsBatchCache(s); ok {
+		t.Fatal("AsBatchCache of a Switchable over a cache without batches = true")
+	}
+	if _, err := s.Incr(ctx, "hits", 1, 0); !errors.Is(err, cache.ErrNotSupported) {
+		t.Fatalf("Incr error = %v, want ErrNotSupported", err)
+	}
+
+	s.Switch(cache.NewInMemoryCache())
+	counter, ok := cache.AsCounter(s)
+	if !ok {
+		t.Fatal("AsCounter after switching to an InMemoryCache = false")
+	}
+	if n, err := counter.Incr(ctx, "hits", 1, 0); err != nil || n != 1 {
+		t.Fatalf("Incr = %d, %v, want 1", n, err)
+	}
+	if _, ok := cache.AsBatchCache(s); !ok {
+		t.Fatal("AsBatchCache after switching to an InMemoryCache = false")
+	}
+}
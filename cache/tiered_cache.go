@@ -0,0 +1,462 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+)
+
+const (
+	// DefaultTieredL1TTL is how long values read from Redis are kept locally
+	// when TieredConfig.L1TTL is not set.
+	DefaultTieredL1TTL = 30 * time.Second
+	// DefaultTieredChannel is the Redis pub/sub channel used to broadcast
+	// invalidations when TieredConfig.Channel is not set.
+	DefaultTieredChannel = "cache:invalidations"
+)
+
+// TieredConfig configures a TieredCache.
+type TieredConfig struct {
+	// L1 is the local cache. When nil an unbounded InMemoryCache is created
+	// and closed together with the TieredCache. Values are kept in L1 as
+	// JSON already, so a provided L1 is best created with WithCodec(nil).
+	L1 *InMemoryCache
+	// L1TTL bounds how long a value is served from L1. It is also the upper
+	// bound on staleness if an invalidation message is lost.
+	L1TTL time.Duration
+	// Channel is the pub/sub channel shared by every replica.
+	Channel string
+}
+
+// TieredCache reads through a short-lived local L1 cache and falls back to
+// Redis as L2. Writes and deletes go to Redis and are broadcast over pub/sub
+// so that every other replica drops its L1 copy.
+type TieredCache struct {
+	l1      *InMemoryCache
+	l2      *RedisCache
+	l1TTL   time.Duration
+	channel string
+	nodeID  string
+	ownsL1  bool
+
+	// fills keeps a read from Redis that raced with a write or an
+	// invalidation of the same key from storing the value it read in L1.
+	fills fillGuard
+
+	pubsub    *redis.PubSub
+	closeOnce sync.Once
+	wg        sync.WaitGroup
+}
+
+// fillStripes is the number of generation counters of a fillGuard.
+const fillStripes = 256
+
+// fillGuard counts the invalidations of keys, hashed over a fixed number
+// of stripes so its size does not grow with the keys. Keys sharing a
+// stripe only skip some L1 fills.
+type fillGuard struct {
+	mu   sync.Mutex
+	gens [fillStripes]uint64
+}
+
+func fillStripe(key string) int {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return int(h.Sum32() % fillStripes)
+}
+
+// start returns the generation of key before it is read from Redis.
+func (g *fillGuard) start(key string) uint64 {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.gens[fillStripe(key)]
+}
+
+// fill calls set unless key was invalidated since start returned gen.
+func (g *fillGuard) fill(key string, gen uint64, set func() error) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.gens[fillStripe(key)] != gen {
+		return nil
+	}
+	return set()
+}
+
+// invalidate must be called before keys are written to or dropped from
+// L1, so reads that started earlier do not fill them.
+func (g *fillGuard) invalidate(keys ...string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for _, key := range keys {
+		g.gens[fillStripe(key)]++
+	}
+}
+
+// invalidateAll is invalidate for every key, used for prefixes.
+func (g *fillGuard) invalidateAll() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for i := range g.gens {
+		g.gens[i]++
+	}
+}
+
+// invalidation is the message published on the pub/sub channel.
+type invalidation struct {
+	Origin string   `json:"origin"`
+	Keys   []string `json:"keys,omitempty"`
+	Prefix string   `json:"prefix,omitempty"`
+}
+
+// NewTieredCache layers a local cache over l2 and subscribes to the
+// invalidation channel. The subscription is confirmed before returning.
+// Close must be called to stop listening; l2 is not closed.
+func NewTieredCache(ctx context.Context, l2 *RedisCache, config TieredConfig) (*TieredCache, error) {
+	if l2 == nil {
+		return nil, errors.New("cache: tiered cache requires a Redis L2")
+	}
+	if config.L1TTL <= 0 {
+		config.L1TTL = DefaultTieredL1TTL
+	}
+	if config.Channel == "" {
+		config.Channel = DefaultTieredChannel
+	}
+
+	t := &TieredCache{
+		l1:      config.L1,
+		l2:      l2,
+		l1TTL:   config.L1TTL,
+		channel: config.Channel,
+		nodeID:  uuid.NewString(),
+	}
+	if t.l1 == nil {
+		t.l1 = NewInMemoryCache(WithCodec(nil), WithCleanupInterval(time.Minute))
+		t.ownsL1 = true
+	}
+
+	t.pubsub = l2.client.Subscribe(ctx, t.channel)
+	if _, err := t.pubsub.Receive(ctx); err != nil {
+		t.pubsub.Close()
+		if t.ownsL1 {
+			t.l1.Close()
+		}
+		return nil, fmt.Errorf("cache: subscribing to %q: %w", t.channel, err)
+	}
+
+	t.wg.Add(1)
+	go t.listen()
+
+	return t, nil
+}
+
+// L1 returns the local cache, e.g. to inspect its Stats.
+func (t *TieredCache) L1() *InMemoryCache {
+	return t.l1
+}
+
+// L2 returns the shared Redis cache.
+func (t *TieredCache) L2() *RedisCache {
+	return t.l2
+}
+
+func (t *TieredCache) Set(key string, value interface{}, expiration time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+	return t.SetContext(ctx, key, value, expiration)
+}
+
+func (t *TieredCache) Get(key string, dest interface{}) error {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+	return t.GetContext(ctx, key, dest)
+}
+
+func (t *TieredCache) Delete(key string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+	return t.DeleteContext(ctx, key)
+}
+
+// SetContext writes value to Redis, refreshes the local copy and tells the
+// other replicas to drop theirs.
+func (t *TieredCache) SetContext(ctx context.Context, key string, value interface{}, expiration time.Duration) error {
+	if expiration < 0 {
+		return ErrInvalidExpiration
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Errorf("error marshaling data to JSON: %w", err)
+	}
+	if err := t.l2.setRaw(ctx, key, data, expiration); err != nil {
+		return err
+	}
+	t.fills.invalidate(key)
+	if err := l1Error(t.l1.SetContext(ctx, key, data, t.localTTL(expiration))); err != nil {
+		return err
+	}
+	return t.publish(ctx, invalidation{Keys: []string{key}})
+}
+
+// GetContext serves key from L1 when present and reads it through from
+// Redis otherwise. The L1 copy expires no later than the key in Redis, and
+// is not stored if key was written or invalidated during the read.
+func (t *TieredCache) GetContext(ctx context.Context, key string, dest interface{}) error {
+	var data []byte
+	err := t.l1.GetContext(ctx, key, &data)
+	if errors.Is(err, ErrNotFound) {
+		gen := t.fills.start(key)
+		var ttl time.Duration
+		if data, ttl, err = t.l2.getRawTTL(ctx, key); err != nil {
+			return err
+		}
+		err := t.fills.fill(key, gen, func() error {
+			return l1Error(t.l1.SetContext(ctx, key, data, t.localTTL(ttl)))
+		})
+		if err != nil {
+			return err
+		}
+	} else if err != nil {
+		return err
+	}
+	return decodeJSON(key, data, dest)
+}
+
+// l1Error ignores ErrValueTooLarge: values too big for a bounded L1 are
+// served from Redis.
+func l1Error(err error) error {
+	if errors.Is(err, ErrValueTooLarge) {
+		return nil
+	}
+	return err
+}
+
+// DeleteContext removes key from Redis and from every replica's L1.
+func (t *TieredCache) DeleteContext(ctx context.Context, key string) error {
+	if err := t.l2.DeleteContext(ctx, key); err != nil {
+		return err
+	}
+	if err := t.dropLocal(ctx, key); err != nil {
+		return err
+	}
+	return t.publish(ctx, invalidation{Keys: []string{key}})
+}
+
+// GetMany serves the keys found in L1 and reads the rest from Redis in one
+// pipeline, filling L1 like GetContext.
+func (t *TieredCache) GetMany(ctx context.Context, keys []string, dest interface{}) error {
+	m, err := mapDest(dest)
+	if err != nil {
+		return err
+	}
+
+	values := map[string][]byte{}
+	if err := t.l1.GetMany(ctx, keys, &values); err != nil {
+		return err
+	}
+	missing := make([]string, 0, len(keys))
+	for _, key := range keys {
+		if _, found := values[key]; !found {
+			missing = append(missing, key)
+		}
+	}
+	if len(missing) > 0 {
+		gens := make([]uint64, len(missing))
+		for i, key := range missing {
+			gens[i] = t.fills.start(key)
+		}
+		fetched, ttls, err := t.l2.getManyRawTTL(ctx, missing)
+		if err != nil {
+			return err
+		}
+		for i, key := range missing {
+			data, found := fetched[key]
+			if !found {
+				continue
+			}
+			values[key] = data
+			err := t.fills.fill(key, gens[i], func() error {
+				return l1Error(t.l1.SetContext(ctx, key, data, t.localTTL(ttls[key])))
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return decodeJSONMap(values, m)
+}
+
+// SetMany writes all items to Redis in one pipeline and broadcasts a single
+// invalidation for them.
+func (t *TieredCache) SetMany(ctx context.Context, items map[string]interface{}, expiration time.Duration) error {
+	if expiration < 0 {
+		return ErrInvalidExpiration
+	}
+	encoded, err := encodeJSONMap(items)
+	if err != nil {
+		return err
+	}
+	if err := t.l2.setManyRaw(ctx, encoded, expiration); err != nil {
+		return err
+	}
+
+	local := make(map[string]interface{}, len(encoded))
+	keys := make([]string, 0, len(encoded))
+	for key, data := range encoded {
+		local[key] = data
+		keys = append(keys, key)
+	}
+	t.fills.invalidate(keys...)
+	if err := l1Error(t.l1.SetMany(ctx, local, t.localTTL(expiration))); err != nil {
+		return err
+	}
+	return t.publish(ctx, invalidation{Keys: keys})
+}
+
+// DeleteMany removes keys from Redis and from every replica's L1.
+func (t *TieredCache) DeleteMany(ctx context.Context, keys ...string) error {
+	if err := t.l2.DeleteMany(ctx, keys...); err != nil {
+		return err
+	}
+	if err := t.dropLocal(ctx, keys...); err != nil {
+		return err
+	}
+	return t.publish(ctx, invalidation{Keys: keys})
+}
+
+// DeleteByPrefix removes every key starting with prefix from Redis and from
+// every replica's L1. The returned count refers to Redis.
+func (t *TieredCache) DeleteByPrefix(ctx context.Context, prefix string) (int, error) {
+	deleted, err := t.l2.DeleteByPrefix(ctx, prefix)
+	if err != nil {
+		return deleted, err
+	}
+	if err := t.dropLocalPrefix(ctx, prefix); err != nil {
+		return deleted, err
+	}
+	return deleted, t.publish(ctx, invalidation{Prefix: prefix})
+}
+
+// Incr increments the counter in Redis, see Counter. Only the local L1 copy
+// is dropped, so other replicas may read a counter through Get up to the L1
+// TTL late; use L2 directly when exact reads matter.
+func (t *TieredCache) Incr(ctx context.Context, key string, delta int64, ttl time.Duration) (int64, error) {
+	n, err := t.l2.Incr(ctx, key, delta, ttl)
+	if err != nil {
+		return 0, err
+	}
+	return n, t.dropLocal(ctx, key)
+}
+
+// Decr decrements the counter in Redis, see Incr.
+func (t *TieredCache) Decr(ctx context.Context, key string, delta int64) (int64, error) {
+	n, err := t.l2.Decr(ctx, key, delta)
+	if err != nil {
+		return 0, err
+	}
+	return n, t.dropLocal(ctx, key)
+}
+
+// Expire sets the expiration of key in Redis and drops the local L1 copy.
+func (t *TieredCache) Expire(ctx context.Context, key string, ttl time.Duration) (bool, error) {
+	exists, err := t.l2.Expire(ctx, key, ttl)
+	if err != nil {
+		return false, err
+	}
+	return exists, t.dropLocal(ctx, key)
+}
+
+// TTL returns the remaining time to live of key in Redis.
+func (t *TieredCache) TTL(ctx context.Context, key string) (time.Duration, error) {
+	return t.l2.TTL(ctx, key)
+}
+
+// IncrWindow records an event in Redis; windows are never cached in L1.
+func (t *TieredCache) IncrWindow(ctx context.Context, key string, window time.Duration) (int64, error) {
+	return t.l2.IncrWindow(ctx, key, window)
+}
+
+// WindowCount counts the events recorded in Redis.
+func (t *TieredCache) WindowCount(ctx context.Context, key string, window time.Duration) (int64, error) {
+	return t.l2.WindowCount(ctx, key, window)
+}
+
+// HealthCheck pings the Redis L2, see HealthChecker.
+func (t *TieredCache) HealthCheck(ctx context.Context) error {
+	return t.l2.HealthCheck(ctx)
+}
+
+// Close stops listening for invalidations and closes L1 if it was created
+// by NewTieredCache. The Redis L2 is left open.
+func (t *TieredCache) Close() error {
+	var err error
+	t.closeOnce.Do(func() {
+		err = t.pubsub.Close()
+		t.wg.Wait()
+		if t.ownsL1 {
+			t.l1.Close()
+		}
+	})
+	return err
+}
+
+// localTTL caps expiration at the L1 TTL.
+func (t *TieredCache) localTTL(expiration time.Duration) time.Duration {
+	if expiration > 0 && expiration < t.l1TTL {
+		return expiration
+	}
+	return t.l1TTL
+}
+
+// dropLocal removes keys from L1 and keeps reads in flight from filling
+// them again.
+func (t *TieredCache) dropLocal(ctx context.Context, keys ...string) error {
+	t.fills.invalidate(keys...)
+	return t.l1.DeleteMany(ctx, keys...)
+}
+
+// dropLocalPrefix is dropLocal for every key starting with prefix.
+func (t *TieredCache) dropLocalPrefix(ctx context.Context, prefix string) error {
+	t.fills.invalidateAll()
+	_, err := t.l1.DeleteByPrefix(ctx, prefix)
+	return err
+}
+
+// publish broadcasts msg to the other replicas.
+func (t *TieredCache) publish(ctx context.Context, msg invalidation) error {
+	msg.Origin = t.nodeID
+	payload, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	if err := t.l2.client.Publish(ctx, t.channel, payload).Err(); err != nil {
+		return fmt.Errorf("cache: broadcasting invalidation: %w", err)
+	}
+	return nil
+}
+
+// listen applies invalidations published by other replicas until the
+// subscription is closed. go-redis resubscribes after connection errors;
+// messages published meanwhile are lost and L1TTL bounds the staleness.
+func (t *TieredCache) listen() {
+	defer t.wg.Done()
+	ctx := context.Background()
+	for msg := range t.pubsub.Channel() {
+		var inv invalidation
+		if err := json.Unmarshal([]byte(msg.Payload), &inv); err != nil || inv.Origin == t.nodeID {
+			continue
+		}
+		if len(inv.Keys) > 0 {
+			t.dropLocal(ctx, inv.Keys...)
+		}
+		if inv.Prefix != "" {
+			t.dropLocalPrefix(ctx, inv.Prefix)
+		}
+	}
+}
@@ -0,0 +1,167 @@
+package cache_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mauriciomartinezc/real-estate-mc-common/cache"
+	"github.com/mauriciomartinezc/real-estate-mc-common/cache/cachetest"
+)
+
+func newTieredCache(t *testing.T, addr string, config cache.TieredConfig) *cache.TieredCache {
+	t.Helper()
+	c, err := cache.NewTieredCache(context.Background(), newRedisCache(t, addr), config)
+	if err != nil {
+		t.Fatalf("NewTieredCache: %v", err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+func TestTieredCacheConformance(t *testing.T) {
+	cachetest.Run(t, func(t *testing.T) cache.Cache {
+		return newTieredCache(t, newMiniredis(t).Addr(), cache.TieredConfig{})
+	})
+}
+
+func TestTieredCacheInvalidatesOtherInstances(t *testing.T) {
+	ctx := context.Background()
+	addr := newMiniredis(t).Addr()
+	// A long L1 TTL makes the test rely on invalidations, not expiry.
+	config := cache.TieredConfig{L1TTL: time.Hour}
+	a := newTieredCache(t, addr, config)
+	b := newTieredCache(t, addr, config)
+
+	if err := a.SetContext(ctx, "user:1", "ana", 0); err != nil {
+		t.Fatalf("SetContext: %v", err)
+	}
+	var got string
+	if err := b.GetContext(ctx, "user:1", &got); err != nil || got != "ana" {
+		t.Fatalf("GetContext = %q, %v, want ana", got, err)
+	}
+
+	if err := a.SetContext(ctx, "user:1", "eva", 0); err != nil {
+		t.Fatalf("SetContext: %v", err)
+	}
+	waitForL1Miss(t, b, "user:1")
+	if err := b.GetContext(ctx, "user:1", &got); err != nil || got != "eva" {
+		t.Fatalf("GetContext after update = %q, %v, want eva", got, err)
+	}
+
+	if err := a.DeleteContext(ctx, "user:1"); err != nil {
+		t.Fatalf("DeleteContext: %v", err)
+	}
+	waitForL1Miss(t, b, "user:1")
+	if err := b.GetContext(ctx, "user:1", &got); !errors.Is(err, cache.ErrNotFound) {
+		t.Fatalf("GetContext after delete error = %v, want ErrNotFound", err)
+	}
+}
+
+func TestTieredCacheInvalidatesPrefixOnOtherInstances(t *testing.T) {
+	ctx := context.Background()
+	addr := newMiniredis(t).Addr()
+	config := cache.TieredConfig{L1TTL: time.Hour}
+	a := newTieredCache(t, addr, config)
+	b := newTieredCache(t, addr, config)
+
+	items := map[string]interface{}{"list:1": 1, "list:2": 2}
+	if err := a.SetMany(ctx, items, 0); err != nil {
+		t.Fatalf("SetMany: %v", err)
+	}
+	got := map[string]int{}
+	if err := b.GetMany(ctx, []string{"list:1", "list:2"}, &got); err != nil || len(got) != 2 {
+		t.Fatalf("GetMany = %v, %v, want both keys", got, err)
+	}
+
+	if _, err := a.DeleteByPrefix(ctx, "list:"); err != nil {
+		t.Fatalf("DeleteByPrefix: %v", err)
+	}
+	waitForL1Miss(t, b, "list:1")
+	waitForL1Miss(t, b, "list:2")
+}
+
+func TestTieredCacheL1ExpiresWithRedis(t *testing.T) {
+	ctx := context.Background()
+	c := newTieredCache(t, newMiniredis(t).Addr(), cache.TieredConfig{L1TTL: time.Hour})
+
+	if err := c.L2().SetContext(ctx, "session", "s1", 50*time.Millisecond); err != nil {
+		t.Fatalf("SetContext: %v", err)
+	}
+	if err := c.L2().SetContext(ctx, "list:1", 1, 50*time.Millisecond); err != nil {
+		t.Fatalf("SetContext: %v", err)
+	}
+	var got string
+	if err := c.GetContext(ctx, "session", &got); err != nil || got != "s1" {
+		t.Fatalf("GetContext = %q, %v, want s1", got, err)
+	}
+	many := map[string]int{}
+	if err := c.GetMany(ctx, []string{"list:1"}, &many); err != nil || many["list:1"] != 1 {
+		t.Fatalf("GetMany = %v, %v, want list:1", many, err)
+	}
+
+	// The L1 copies expire with the keys in Redis, not after the L1 TTL.
+	waitForL1Miss(t, c, "session")
+	waitForL1Miss(t, c, "list:1")
+	if err := c.GetContext(ctx, "session", &got); !errors.Is(err, cache.ErrNotFound) {
+		t.Fatalf("GetContext after the Redis TTL error = %v, want ErrNotFound", err)
+	}
+}
+
+// A read from Redis that overlaps a write must not leave the value it read
+// in L1.
+func TestTieredCacheReadRacingWrite(t *testing.T) {
+	ctx := context.Background()
+	c := newTieredCache(t, newMiniredis(t).Addr(), cache.TieredConfig{L1TTL: time.Hour})
+
+	var wg sync.WaitGroup
+	done := make(chan struct{})
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				var n int64
+				_ = c.GetContext(ctx, "views", &n)
+			}
+		}()
+	}
+	var last int64
+	for i := 0; i < 100; i++ {
+		n, err := c.Incr(ctx, "views", 1, 0)
+		if err != nil {
+			t.Fatalf("Incr: %v", err)
+		}
+		last = n
+	}
+	close(done)
+	wg.Wait()
+
+	var got int64
+	if err := c.GetContext(ctx, "views", &got); err != nil || got != last {
+		t.Fatalf("GetContext after the writes = %d, %v, want %d", got, err, last)
+	}
+}
+
+// waitForL1Miss waits until key is no longer in the L1 of c.
+func waitForL1Miss(t *testing.T, c *cache.TieredCache, key string) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		var data []byte
+		if err := c.L1().GetContext(context.Background(), key, &data); errors.Is(err, cache.ErrNotFound) {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("key %q still in L1 after the invalidation", key)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
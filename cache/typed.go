@@ -0,0 +1,93 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+)
+
+// Typed is a type-safe façade over a Cache that stores values of type T
+// under a namespace prefix.
+type Typed[T any] struct {
+	cache  ContextCache
+	prefix string
+	group  singleflight.Group
+}
+
+// NewTyped wraps c so that every key is stored as "<prefix>:<key>". An empty
+// prefix leaves keys untouched.
+func NewTyped[T any](c Cache, prefix string) *Typed[T] {
+	return &Typed[T]{
+		cache:  ToContextCache(c),
+		prefix: prefix,
+	}
+}
+
+// Key returns the key under which key is stored in the underlying cache.
+func (t *Typed[T]) Key(key string) string {
+	if t.prefix == "" {
+		return key
+	}
+	return t.prefix + ":" + key
+}
+
+// Get returns the value stored under key. A cache miss is reported as
+// found == false with a nil error.
+func (t *Typed[T]) Get(ctx context.Context, key string) (T, bool, error) {
+	var value T
+	err := t.cache.GetContext(ctx, t.Key(key), &value)
+	if errors.Is(err, ErrNotFound) {
+		var zero T
+		return zero, false, nil
+	}
+	if err != nil {
+		var zero T
+		return zero, false, err
+	}
+	return value, true, nil
+}
+
+// Set stores value under key for ttl. A ttl of zero never expires.
+func (t *Typed[T]) Set(ctx context.Context, key string, value T, ttl time.Duration) error {
+	return t.cache.SetContext(ctx, t.Key(key), value, ttl)
+}
+
+// Delete removes key.
+func (t *Typed[T]) Delete(ctx context.Context, key string) error {
+	return t.cache.DeleteContext(ctx, t.Key(key))
+}
+
+// GetOrLoad returns the cached value for key, calling loader on a miss and
+// caching its result for ttl. Concurrent misses for the same key share a
+// single loader call. Cache read and write failures are not fatal: the
+// loader result is returned even if it could not be cached.
+func (t *Typed[T]) GetOrLoad(ctx context.Context, key string, ttl time.Duration, loader func() (T, error)) (T, error) {
+	if value, found, err := t.Get(ctx, key); err == nil && found {
+		return value, nil
+	}
+
+	ch := t.group.DoChan(t.Key(key), func() (interface{}, error) {
+		value, err := loader()
+		if err != nil {
+			return value, err
+		}
+		// The shared call must not be bound to the first caller's context.
+		_ = t.cache.SetContext(context.WithoutCancel(ctx), t.Key(key), value, ttl)
+		return value, nil
+	})
+
+	select {
+	case res := <-ch:
+		if res.Err != nil {
+			var zero T
+			return zero, res.Err
+		}
+		value, _ := res.Val.(T)
+		return value, nil
+	case <-ctx.Done():
+		var zero T
+		return zero, ctx.Err()
+	}
+}
@@ -0,0 +1,151 @@
+package cache_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mauriciomartinezc/real-estate-mc-common/cache"
+)
+
+type listing struct {
+	ID    int
+	Title string
+}
+
+func TestTypedGetSet(t *testing.T) {
+	ctx := context.Background()
+	c := cache.NewInMemoryCache()
+	listings := cache.NewTyped[listing](c, "listings")
+
+	if _, found, err := listings.Get(ctx, "1"); err != nil || found {
+		t.Fatalf("Get of a missing key = %v, %v, want a miss", found, err)
+	}
+	want := listing{ID: 1, Title: "Casa"}
+	if err := listings.Set(ctx, "1", want, time.Minute); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+	if got, found, err := listings.Get(ctx, "1"); err != nil || !found || got != want {
+		t.Fatalf("Get = %+v, %v, %v, want %+v", got, found, err, want)
+	}
+
+	// Keys are namespaced.
+	if listings.Key("1") != "listings:1" || cache.NewTyped[listing](c, "").Key("1") != "1" {
+		t.Fatalf("Key = %q", listings.Key("1"))
+	}
+	var raw listing
+	if err := c.Get("listings:1", &raw); err != nil || raw != want {
+		t.Fatalf("Get of the prefixed key = %+v, %v, want %+v", raw, err, want)
+	}
+	if _, found, _ := cache.NewTyped[listing](c, "other").Get(ctx, "1"); found {
+		t.Fatal("found a value under another prefix")
+	}
+
+	if err := listings.Delete(ctx, "1"); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if _, found, err := listings.Get(ctx, "1"); err != nil || found {
+		t.Fatalf("Get after Delete = %v, %v, want a miss", found, err)
+	}
+}
+
+func TestTypedGetOrLoadSharesConcurrentLoads(t *testing.T) {
+	ctx := context.Background()
+	listings := cache.NewTyped[listing](cache.NewInMemoryCache(), "listings")
+
+	var calls atomic.Int32
+	started := make(chan struct{})
+	release := make(chan struct{})
+	loader := func() (listing, error) {
+		if calls.Add(1) == 1 {
+			close(started)
+		}
+		<-release
+		return listing{ID: 1, Title: "Casa"}, nil
+	}
+
+	const callers = 10
+	results := make(chan listing, callers)
+	var wg sync.WaitGroup
+	load := func() {
+		defer wg.Done()
+		value, err := listings.GetOrLoad(ctx, "1", time.Minute, loader)
+		if err != nil {
+			t.Errorf("GetOrLoad: %v", err)
+		}
+		results <- value
+	}
+	wg.Add(1)
+	go load()
+	<-started
+	for i := 1; i < callers; i++ {
+		wg.Add(1)
+		go load()
+	}
+	// Let the other callers join the load in flight.
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	close(results)
+
+	if n := calls.Load(); n != 1 {
+		t.Fatalf("loader called %d times, want 1", n)
+	}
+	for value := range results {
+		if value.ID != 1 {
+			t.Fatalf("GetOrLoad = %+v, want listing 1", value)
+		}
+	}
+
+	// The result is cached.
+	if _, err := listings.GetOrLoad(ctx, "1", time.Minute, loader); err != nil || calls.Load() != 1 {
+		t.Fatalf("GetOrLoad of a cached value = %v after %d loads, want no new load", err, calls.Load())
+	}
+}
+
+func TestTypedGetOrLoadErrors(t *testing.T) {
+	ctx := context.Background()
+	listings := cache.NewTyped[listing](cache.NewInMemoryCache(), "listings")
+
+	// Errors are returned and not cached.
+	failure := errors.New("backend unavailable")
+	if _, err := listings.GetOrLoad(ctx, "1", time.Minute, func() (listing, error) {
+		return listing{}, failure
+	}); !errors.Is(err, failure) {
+		t.Fatalf("GetOrLoad error = %v, want the loader error", err)
+	}
+	if _, found, _ := listings.Get(ctx, "1"); found {
+		t.Fatal("failed load cached")
+	}
+
+	// A caller that gives up does not cancel the load for the others.
+	release := make(chan struct{})
+	canceled, cancel := context.WithCancel(ctx)
+	done := make(chan error)
+	go func() {
+		_, err := listings.GetOrLoad(canceled, "2", time.Minute, func() (listing, error) {
+			<-release
+			return listing{ID: 2}, nil
+		})
+		done <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	cancel()
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Fatalf("GetOrLoad with a canceled context error = %v, want Canceled", err)
+	}
+	close(release)
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		if value, found, _ := listings.Get(ctx, "2"); found && value.ID == 2 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("load abandoned by its caller was not cached")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
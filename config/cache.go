@@ -0,0 +1,316 @@
+package config
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mauriciomartinezc/real-estate-mc-common/cache"
+	"github.com/mauriciomartinezc/real-estate-mc-common/logger"
+	"github.com/mauriciomartinezc/real-estate-mc-common/security"
+)
+
+// CacheFailurePolicy decides what NewCacheClient does when Redis cannot be
+// reached at startup.
+type CacheFailurePolicy string
+
+const (
+	// CacheFailFast returns the connection error to the caller.
+	CacheFailFast CacheFailurePolicy = "fail"
+	// CacheFallbackMemory permanently uses an in-memory cache instead.
+	CacheFallbackMemory CacheFailurePolicy = "memory"
+	// CacheStartDegraded serves from memory while reconnecting to Redis in
+	// the background, then switches over.
+	CacheStartDegraded CacheFailurePolicy = "degraded"
+)
+
+// cacheClientOptions holds the settings applied by CacheClientOption.
+type cacheClientOptions struct {
+	policy          CacheFailurePolicy
+	instrumentation *cache.InstrumentedConfig
+	ctx             context.Context
+}
+
+// CacheClientOption configures NewCacheClient.
+type CacheClientOption func(*cacheClientOptions)
+
+// WithCacheFailurePolicy overrides the CACHE_FAILURE_POLICY environment
+// variable.
+func WithCacheFailurePolicy(policy CacheFailurePolicy) CacheClientOption {
+	return func(o *cacheClientOptions) {
+		o.policy = policy
+	}
+}
+
+// WithCacheContext bounds the background reconnection of
+// CacheStartDegraded: once ctx is done it stops retrying and the cache
+// stays in memory. Cancel it on shutdown.
+func WithCacheContext(ctx context.Context) CacheClientOption {
+	return func(o *cacheClientOptions) {
+		o.ctx = ctx
+	}
+}
+
+// WithCacheInstrumentation wraps the returned cache in cache.Instrumented so
+// hits, misses, errors and latency are logged and exposed as metrics.
+func WithCacheInstrumentation(config cache.InstrumentedConfig) CacheClientOption {
+	return func(o *cacheClientOptions) {
+		o.instrumentation = &config
+	}
+}
+
+// NewCacheClient builds the cache selected by CACHE_TYPE: "memory" (the
+// default), "redis" or "tiered" (local L1 over Redis, see
+// cache.NewTieredCache). Redis connections are retried CACHE_CONNECT_RETRIES
+// times; if Redis is still unreachable the failure policy, taken from the
+// options or CACHE_FAILURE_POLICY ("fail", "memory" or "degraded"), decides
+// the outcome. The default is CacheFailFast.
+func NewCacheClient(opts ...CacheClientOption) (cache.Cache, error) {
+	options := cacheClientOptions{
+		policy: CacheFailurePolicy(getEnvironment("CACHE_FAILURE_POLICY")),
+		ctx:    context.Background(),
+	}
+	for _, opt := range opts {
+		opt(&options)
+	}
+	if options.policy == "" {
+		options.policy = CacheFailFast
+	}
+
+	cacheClient, err := newCacheClient(options)
+	if err != nil || options.instrumentation == nil {
+		return cacheClient, err
+	}
+	return cache.NewInstrumented(cacheClient, *options.instrumentation), nil
+}
+
+// newCacheClient builds the cache selected by CACHE_TYPE, applying the
+// failure policy.
+func newCacheClient(options cacheClientOptions) (cache.Cache, error) {
+	cacheType := getEnvironment("CACHE_TYPE")
+	if cacheType != "redis" && cacheType != "tiered" {
+		return cache.NewInMemoryCache(), nil
+	}
+
+	redisConfig, err := RedisConfigFromEnv()
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(options.ctx, cacheConnectTimeout(redisConfig))
+	defer cancel()
+	redisCache, err := cache.NewRedisCacheWithConfig(ctx, redisConfig)
+	if err == nil {
+		return newRedisBackedCache(ctx, redisCache, cacheType)
+	}
+
+	switch options.policy {
+	case CacheFallbackMemory:
+		logger.Warn().Err(err).Str("policy", string(options.policy)).
+			Msg("Redis unavailable, falling back to in-memory cache")
+		return cache.NewInMemoryCache(), nil
+	case CacheStartDegraded:
+		logger.Warn().Err(err).Str("policy", string(options.policy)).
+			Msg("Redis unavailable, starting with in-memory cache and reconnecting in background")
+		switchable := cache.NewSwitchable(cache.NewInMemoryCache())
+		go reconnectCache(options.ctx, switchable, redisConfig, cacheType)
+		return switchable, nil
+	case CacheFailFast:
+		logger.Error().Err(err).Str("policy", string(options.policy)).
+			Msg("Redis unavailable")
+		return nil, err
+	default:
+		return nil, fmt.Errorf("unknown cache failure policy %q", options.policy)
+	}
+}
+
+// newRedisBackedCache wraps redisCache in a TieredCache when requested.
+func newRedisBackedCache(ctx context.Context, redisCache *cache.RedisCache, cacheType string) (cache.Cache, error) {
+	if cacheType != "tiered" {
+		return redisCache, nil
+	}
+
+	// CACHE_L1_TTL is a Go duration such as "30s"; empty uses the default
+	l1TTL, err := envDuration("CACHE_L1_TTL")
+	if err != nil {
+		return nil, err
+	}
+	tiered, err := cache.NewTieredCache(ctx, redisCache, cache.TieredConfig{
+		L1TTL:   l1TTL,
+		Channel: getEnvironment("CACHE_INVALIDATION_CHANNEL"),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to start tiered cache: %w", err)
+	}
+	return tiered, nil
+}
+
+// reconnectCache retries Redis until it answers and then moves switchable
+// over from the in-memory cache, unless ctx is done first.
+func reconnectCache(ctx context.Context, switchable *cache.Switchable, redisConfig cache.RedisConfig, cacheType string) {
+	redisConfig.ConnectRetries = math.MaxInt
+	redisCache, err := cache.NewRedisCacheWithConfig(ctx, redisConfig)
+	if err != nil {
+		logger.Info().Err(err).Msg("Stopped reconnecting to Redis, staying on in-memory cache")
+		return
+	}
+	backed, err := newRedisBackedCache(ctx, redisCache, cacheType)
+	if err != nil {
+		redisCache.Close()
+		logger.Error().Err(err).Msg("Redis reconnected but the cache could not be started, staying on in-memory cache")
+		return
+	}
+	if ctx.Err() != nil {
+		// A TieredCache leaves its Redis L2 open.
+		if tiered, ok := backed.(*cache.TieredCache); ok {
+			tiered.Close()
+		}
+		redisCache.Close()
+		return
+	}
+
+	previous := switchable.Switch(backed)
+	if memory, ok := previous.(*cache.InMemoryCache); ok {
+		memory.Close()
+	}
+	logger.Info().Str("cache_type", cacheType).Msg("Redis reconnected, switched from in-memory cache")
+}
+
+// cacheConnectTimeout bounds the initial connection attempts: five seconds
+// per attempt plus the configured backoff between them.
+func cacheConnectTimeout(config cache.RedisConfig) time.Duration {
+	backoff := config.ConnectMaxBackoff
+	if backoff <= 0 {
+		backoff = 10 * time.Second
+	}
+	return time.Duration(config.ConnectRetries+1)*5*time.Second + time.Duration(config.ConnectRetries)*backoff
+}
+
+// RedisConfigFromEnv builds the Redis connection settings from CACHE_*
+// environment variables:
+//
+//   - CACHE_ADDRS: comma separated host:port list (Sentinel or Cluster seeds);
+//     falls back to CACHE_HOST:CACHE_PORT
+//   - CACHE_MASTER_NAME, CACHE_SENTINEL_USERNAME, CACHE_SENTINEL_PASSWORD:
+//     Sentinel failover
+//   - CACHE_CLUSTER: "true" to force Redis Cluster
+//   - CACHE_USERNAME, CACHE_PASSWORD, CACHE_DB
+//   - CACHE_TLS_ENABLED, CACHE_TLS_CA_FILE, CACHE_TLS_CERT_FILE,
+//     CACHE_TLS_KEY_FILE, CACHE_TLS_SERVER_NAME, CACHE_TLS_INSECURE_SKIP_VERIFY
+//   - CACHE_POOL_SIZE, CACHE_MIN_IDLE_CONNS
+//   - CACHE_DIAL_TIMEOUT, CACHE_READ_TIMEOUT, CACHE_WRITE_TIMEOUT: Go
+//     durations such as "5s"
+//   - CACHE_CONNECT_RETRIES, CACHE_CONNECT_BACKOFF, CACHE_CONNECT_MAX_BACKOFF:
+//     retries of the initial connection
+func RedisConfigFromEnv() (cache.RedisConfig, error) {
+	config := cache.RedisConfig{
+		MasterName:       getEnvironment("CACHE_MASTER_NAME"),
+		SentinelUsername: getEnvironment("CACHE_SENTINEL_USERNAME"),
+		SentinelPassword: os.Getenv("CACHE_SENTINEL_PASSWORD"),
+		Username:         getEnvironment("CACHE_USERNAME"),
+		Password:         os.Getenv("CACHE_PASSWORD"),
+	}
+
+	if addrs := getEnvironment("CACHE_ADDRS"); addrs != "" {
+		for _, addr := range strings.Split(addrs, ",") {
+			if addr = strings.TrimSpace(addr); addr != "" {
+				config.Addrs = append(config.Addrs, addr)
+			}
+		}
+	} else {
+		config.Addrs = []string{getEnvironment("CACHE_HOST") + ":" + getEnvironment("CACHE_PORT")}
+	}
+
+	var err error
+	if config.Cluster, err = envBool("CACHE_CLUSTER"); err != nil {
+		return config, err
+	}
+	if config.DB, err = envInt("CACHE_DB"); err != nil {
+		return config, err
+	}
+	if config.PoolSize, err = envInt("CACHE_POOL_SIZE"); err != nil {
+		return config, err
+	}
+	if config.MinIdleConns, err = envInt("CACHE_MIN_IDLE_CONNS"); err != nil {
+		return config, err
+	}
+	if config.DialTimeout, err = envDuration("CACHE_DIAL_TIMEOUT"); err != nil {
+		return config, err
+	}
+	if config.ReadTimeout, err = envDuration("CACHE_READ_TIMEOUT"); err != nil {
+		return config, err
+	}
+	if config.WriteTimeout, err = envDuration("CACHE_WRITE_TIMEOUT"); err != nil {
+		return config, err
+	}
+	if config.ConnectRetries, err = envInt("CACHE_CONNECT_RETRIES"); err != nil {
+		return config, err
+	}
+	if config.ConnectBackoff, err = envDuration("CACHE_CONNECT_BACKOFF"); err != nil {
+		return config, err
+	}
+	if config.ConnectMaxBackoff, err = envDuration("CACHE_CONNECT_MAX_BACKOFF"); err != nil {
+		return config, err
+	}
+
+	tlsOptions := security.TLSOptions{
+		CAFile:     getEnvironment("CACHE_TLS_CA_FILE"),
+		CertFile:   getEnvironment("CACHE_TLS_CERT_FILE"),
+		KeyFile:    getEnvironment("CACHE_TLS_KEY_FILE"),
+		ServerName: getEnvironment("CACHE_TLS_SERVER_NAME"),
+	}
+	if tlsOptions.Enabled, err = envBool("CACHE_TLS_ENABLED"); err != nil {
+		return config, err
+	}
+	if tlsOptions.InsecureSkipVerify, err = envBool("CACHE_TLS_INSECURE_SKIP_VERIFY"); err != nil {
+		return config, err
+	}
+	if config.TLS, err = security.NewTLSConfig(tlsOptions); err != nil {
+		return config, fmt.Errorf("error configuring cache TLS: %w", err)
+	}
+
+	return config, nil
+}
+
+// envBool parses an optional boolean environment variable.
+func envBool(name string) (bool, error) {
+	value := getEnvironment(name)
+	if value == "" {
+		return false, nil
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("the environment variable %s must be a boolean: %w", name, err)
+	}
+	return b, nil
+}
+
+// envInt parses an optional integer environment variable.
+func envInt(name string) (int, error) {
+	value := getEnvironment(name)
+	if value == "" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("the environment variable %s must be an integer: %w", name, err)
+	}
+	return n, nil
+}
+
+// envDuration parses an optional Go duration environment variable.
+func envDuration(name string) (time.Duration, error) {
+	value := getEnvironment(name)
+	if value == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("the environment variable %s must be a duration: %w", name, err)
+	}
+	return d, nil
+}
@@ -2,7 +2,6 @@ package config
 
 import (
 	"fmt"
-	"github.com/mauriciomartinezc/real-estate-mc-common/cache"
 	"github.com/mauriciomartinezc/real-estate-mc-common/storage"
 	"os"
 	"path/filepath"
@@ -36,24 +35,6 @@ func GetDSN() (string, error) {
 	return dsn, nil
 }
 
-func NewCacheClient() cache.Cache {
-	var cacheClient cache.Cache
-
-	if os.Getenv("CACHE_TYPE") == "redis" {
-		cacheClient = cache.NewRedisCache(
-			os.Getenv("CACHE_HOST")+":"+os.Getenv("CACHE_PORT"),
-			os.Getenv("CACHE_PASSWORD"),
-			0,
-		)
-	}
-
-	if cacheClient == nil || os.Getenv("CACHE_TYPE") == "memory" {
-		cacheClient = cache.NewInMemoryCache()
-	}
-
-	return cacheClient
-}
-
 func NewStorage() (storage.StorageProvider, error) {
 	storageName := os.Getenv("STORAGE")
 	provider, err := storage.NewStorageProvider(storageName)
@@ -0,0 +1,181 @@
+// Package health keeps the dependency checks of a service, such as its
+// database, cache, storage and message broker, and runs them for readiness
+// probes.
+package health
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+const defaultTimeout = 5 * time.Second
+
+// Status of a check or of a whole report.
+const (
+	StatusUp   = "up"
+	StatusDown = "down"
+)
+
+// Check reports whether a dependency is usable. It should return once ctx
+// is done.
+type Check func(ctx context.Context) error
+
+// Option configures a registered check.
+type Option func(*entry)
+
+// WithTimeout bounds how long the check may run. Defaults to 5s.
+func WithTimeout(timeout time.Duration) Option {
+	return func(e *entry) {
+		if timeout > 0 {
+			e.timeout = timeout
+		}
+	}
+}
+
+// Optional reports the check without letting its failure make the service
+// unready, for dependencies the service can degrade without.
+func Optional() Option {
+	return func(e *entry) {
+		e.optional = true
+	}
+}
+
+type entry struct {
+	check    Check
+	timeout  time.Duration
+	optional bool
+}
+
+// Result is the outcome of one check.
+type Result struct {
+	Status   string `json:"status"`
+	Error    string `json:"error,omitempty"`
+	Optional bool   `json:"optional,omitempty"`
+	// DurationMs is how long the check took, in milliseconds.
+	DurationMs int64 `json:"duration_ms"`
+}
+
+// Report is the outcome of every check of a registry. Its status is down
+// if any required check failed.
+type Report struct {
+	Status string            `json:"status"`
+	Checks map[string]Result `json:"checks"`
+}
+
+// Up reports whether every required check passed.
+func (r Report) Up() bool {
+	return r.Status == StatusUp
+}
+
+// Registry keeps named checks. It is safe for concurrent use.
+type Registry struct {
+	mu      sync.RWMutex
+	entries map[string]entry
+}
+
+// NewRegistry creates an empty registry.
+func NewRegistry() *Registry {
+	return &Registry{entries: make(map[string]entry)}
+}
+
+// Default is the registry exposed by utils.RouteHealth.
+var Default = NewRegistry()
+
+// Register adds check under name to the Default registry.
+func Register(name string, check Check, opts ...Option) {
+	Default.Register(name, check, opts...)
+}
+
+// Register adds check under name, replacing any check with the same name.
+func (r *Registry) Register(name string, check Check, opts ...Option) {
+	e := entry{check: check, timeout: defaultTimeout}
+	for _, opt := range opts {
+		opt(&e)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.entries[name] = e
+}
+
+// Unregister removes the check registered under name.
+func (r *Registry) Unregister(name string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.entries, name)
+}
+
+// Names returns the names of the registered checks, sorted.
+func (r *Registry) Names() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	names := make([]string, 0, len(r.entries))
+	for name := range r.entries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Check runs every check concurrently, each within its timeout.
+func (r *Registry) Check(ctx context.Context) Report {
+	r.mu.RLock()
+	entries := make(map[string]entry, len(r.entries))
+	for name, e := range r.entries {
+		entries[name] = e
+	}
+	r.mu.RUnlock()
+
+	report := Report{Status: StatusUp, Checks: make(map[string]Result, len(entries))}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for name, e := range entries {
+		wg.Add(1)
+		go func(name string, e entry) {
+			defer wg.Done()
+			result := run(ctx, e)
+			mu.Lock()
+			defer mu.Unlock()
+			report.Checks[name] = result
+			if result.Status == StatusDown && !e.optional {
+				report.Status = StatusDown
+			}
+		}(name, e)
+	}
+	wg.Wait()
+	return report
+}
+
+// run calls the check of e and gives up on it once its timeout passes,
+// even if the check ignores its context.
+func run(ctx context.Context, e entry) Result {
+	ctx, cancel := context.WithTimeout(ctx, e.timeout)
+	defer cancel()
+
+	start := time.Now()
+	done := make(chan error, 1)
+	go func() {
+		defer func() {
+			if recovered := recover(); recovered != nil {
+				done <- fmt.Errorf("check panicked: %v", recovered)
+			}
+		}()
+		done <- e.check(ctx)
+	}()
+
+	var err error
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		err = fmt.Errorf("check timed out after %s: %w", e.timeout, ctx.Err())
+	}
+
+	result := Result{Status: StatusUp, Optional: e.optional, DurationMs: time.Since(start).Milliseconds()}
+	if err != nil {
+		result.Status = StatusDown
+		result.Error = err.Error()
+	}
+	return result
+}
@@ -0,0 +1,148 @@
+package health_test
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mauriciomartinezc/real-estate-mc-common/health"
+)
+
+func TestRegistryCheckTimeout(t *testing.T) {
+	registry := health.NewRegistry()
+	release := make(chan struct{})
+	defer close(release)
+	// A check that ignores its context is given up on all the same.
+	registry.Register("stuck", func(ctx context.Context) error {
+		<-release
+		return nil
+	}, health.WithTimeout(20*time.Millisecond))
+	registry.Register("slow", func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}, health.WithTimeout(20*time.Millisecond))
+
+	start := time.Now()
+	report := registry.Check(context.Background())
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("Check took %v, want about the 20ms timeout", elapsed)
+	}
+	if report.Up() {
+		t.Fatalf("report = %+v, want down", report)
+	}
+	stuck := report.Checks["stuck"]
+	if stuck.Status != health.StatusDown || !strings.Contains(stuck.Error, "timed out after 20ms") {
+		t.Fatalf("stuck check = %+v, want down after timing out", stuck)
+	}
+	if slow := report.Checks["slow"]; slow.Status != health.StatusDown || slow.Error == "" {
+		t.Fatalf("slow check = %+v, want down", slow)
+	}
+}
+
+func TestRegistryOptionalChecks(t *testing.T) {
+	registry := health.NewRegistry()
+	registry.Register("db", func(ctx context.Context) error { return nil })
+	registry.Register("cache", func(ctx context.Context) error {
+		return errors.New("connection refused")
+	}, health.Optional())
+
+	report := registry.Check(context.Background())
+	if !report.Up() {
+		t.Fatalf("report with a failing optional check = %+v, want up", report)
+	}
+	want := health.Result{Status: health.StatusDown, Error: "connection refused", Optional: true}
+	if got := report.Checks["cache"]; got.Status != want.Status || got.Error != want.Error || !got.Optional {
+		t.Fatalf("cache check = %+v, want %+v", got, want)
+	}
+	if got := report.Checks["db"]; got.Status != health.StatusUp || got.Error != "" || got.Optional {
+		t.Fatalf("db check = %+v, want up", got)
+	}
+
+	// A failing required check marks the report down.
+	registry.Register("db", func(ctx context.Context) error { return errors.New("timeout") })
+	if report := registry.Check(context.Background()); report.Up() {
+		t.Fatalf("report with a failing required check = %+v, want down", report)
+	}
+}
+
+func TestRegistryRunsChecksConcurrently(t *testing.T) {
+	registry := health.NewRegistry()
+	const checks = 5
+	// Every check waits for all of them to start, so the report is only up
+	// if they run at the same time.
+	var started sync.WaitGroup
+	started.Add(checks)
+	allStarted := make(chan struct{})
+	go func() {
+		started.Wait()
+		close(allStarted)
+	}()
+	for _, name := range []string{"db", "cache", "storage", "kafka", "search"} {
+		registry.Register(name, func(ctx context.Context) error {
+			started.Done()
+			select {
+			case <-allStarted:
+				time.Sleep(50 * time.Millisecond)
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}, health.WithTimeout(time.Second))
+	}
+
+	start := time.Now()
+	report := registry.Check(context.Background())
+	elapsed := time.Since(start)
+	if !report.Up() || len(report.Checks) != checks {
+		t.Fatalf("report = %+v, want %d checks up", report, checks)
+	}
+	if elapsed >= checks*50*time.Millisecond {
+		t.Fatalf("Check took %v, want about the 50ms of the slowest check", elapsed)
+	}
+	for name, result := range report.Checks {
+		if result.DurationMs < 50 {
+			t.Fatalf("%s duration = %dms, want at least 50ms", name, result.DurationMs)
+		}
+	}
+}
+
+func TestRegistryRecoversPanics(t *testing.T) {
+	registry := health.NewRegistry()
+	registry.Register("storage", func(ctx context.Context) error {
+		panic("nil client")
+	})
+
+	report := registry.Check(context.Background())
+	result := report.Checks["storage"]
+	if report.Up() || result.Status != health.StatusDown || result.Error != "check panicked: nil client" {
+		t.Fatalf("report = %+v, want the panic reported as a failure", report)
+	}
+}
+
+func TestRegistryRegister(t *testing.T) {
+	registry := health.NewRegistry()
+	if report := registry.Check(context.Background()); !report.Up() || len(report.Checks) != 0 {
+		t.Fatalf("report of an empty registry = %+v, want up", report)
+	}
+
+	registry.Register("kafka", func(ctx context.Context) error { return errors.New("unreachable") })
+	registry.Register("db", func(ctx context.Context) error { return nil })
+	if names := registry.Names(); !reflect.DeepEqual(names, []string{"db", "kafka"}) {
+		t.Fatalf("Names = %v, want [db kafka]", names)
+	}
+
+	// Registering under the same name replaces the check.
+	registry.Register("kafka", func(ctx context.Context) error { return nil })
+	if report := registry.Check(context.Background()); !report.Up() || len(report.Checks) != 2 {
+		t.Fatalf("report = %+v, want two checks up", report)
+	}
+
+	registry.Unregister("kafka")
+	if names := registry.Names(); !reflect.DeepEqual(names, []string{"db"}) {
+		t.Fatalf("Names after Unregister = %v, want [db]", names)
+	}
+}
@@ -0,0 +1,401 @@
+package messaging
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrBrokerClosed is returned when publishing or subscribing through a
+// closed broker.
+var ErrBrokerClosed = errors.New("message broker is closed")
+
+// AsyncPublisher is implemented by brokers that can publish in the
+// background and in batches. Both KafkaProvider and MemoryBroker implement
+// it.
+type AsyncPublisher interface {
+	// PublishAsync queues msg and returns at once, blocking only while the
+	// buffer is full or until ctx is done. ctx bounds the wait for buffer
+	// space, not the write. Messages with the same key are written in the
+	// order they were queued, though a retried write may reorder them.
+	PublishAsync(ctx context.Context, msg Message) *PublishFuture
+	// PublishBatch publishes msgs synchronously in as few requests as
+	// possible, writing messages with the same key in order, though a
+	// retried write may reorder them. When only some fail the error is a
+	// *BatchError.
+	PublishBatch(ctx context.Context, msgs []Message) error
+	// Flush waits until every message queued before the call is published
+	// or failed.
+	Flush(ctx context.Context) error
+}
+
+// PublishFuture is the pending result of PublishAsync.
+type PublishFuture struct {
+	msg  Message
+	seq  uint64 // order of queueing, for Flush
+	done chan struct{}
+	err  error
+}
+
+func newPublishFuture(msg Message) *PublishFuture {
+	return &PublishFuture{msg: msg, done: make(chan struct{})}
+}
+
+// Message returns the queued message, with its ID and Time set.
+func (f *PublishFuture) Message() Message {
+	return f.msg
+}
+
+// Done is closed once the message is published or failed.
+func (f *PublishFuture) Done() <-chan struct{} {
+	return f.done
+}
+
+// Err returns the outcome once Done is closed, and nil before.
+func (f *PublishFuture) Err() error {
+	select {
+	case <-f.done:
+		return f.err
+	default:
+		return nil
+	}
+}
+
+// Wait blocks until the message is published or failed, or ctx is done.
+func (f *PublishFuture) Wait(ctx context.Context) error {
+	select {
+	case <-f.done:
+		return f.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (f *PublishFuture) complete(err error) {
+	f.err = err
+	close(f.done)
+}
+
+// FailedFuture returns a future already completed with err, for wrappers
+// that reject msg before it is queued. msg gets its ID and Time like a
+// published message would.
+func FailedFuture(msg Message, err error) *PublishFuture {
+	f := newPublishFuture(prepareMessage(msg))
+	f.complete(err)
+	return f
+}
+
+// PublishAsync queues msg on broker if it is an AsyncPublisher. Otherwise
+// it publishes msg with PublishMessage and returns a completed future.
+func PublishAsync(ctx context.Context, broker MessageBroker, msg Message) *PublishFuture {
+	if async, ok := broker.(AsyncPublisher); ok {
+		return async.PublishAsync(ctx, msg)
+	}
+	f := newPublishFuture(prepareMessage(msg))
+	f.complete(broker.PublishMessage(ctx, f.msg))
+	return f
+}
+
+// PublishBatch publishes msgs with the PublishBatch of broker if it is an
+// AsyncPublisher, and one by one otherwise, returning a *BatchError when
+// only some fail.
+func PublishBatch(ctx context.Context, broker MessageBroker, msgs []Message) error {
+	if async, ok := broker.(AsyncPublisher); ok {
+		return async.PublishBatch(ctx, msgs)
+	}
+	errs := make([]error, len(msgs))
+	for i, msg := range msgs {
+		errs[i] = broker.PublishMessage(ctx, msg)
+	}
+	return batchError(errs)
+}
+
+// AsyncConfig configures the background publishing of a broker.
+type AsyncConfig struct {
+	// BufferSize is how many messages may wait to be published; PublishAsync
+	// blocks while it is full. Defaults to 10000.
+	BufferSize int
+	// MaxBatch is the largest number of messages written in one request.
+	// Defaults to 100.
+	MaxBatch int
+	// Lanes is how many batches are written concurrently. Messages are
+	// assigned to lanes by key, so those with the same key are written in
+	// order. Defaults to 4.
+	Lanes int
+	// DrainTimeout bounds how long Close waits for queued messages; those
+	// still queued then fail with ErrBrokerClosed. Defaults to 10s.
+	DrainTimeout time.Duration
+}
+
+const (
+	defaultAsyncBufferSize   = 10000
+	defaultAsyncMaxBatch     = 100
+	defaultAsyncLanes        = 4
+	defaultAsyncDrainTimeout = 10 * time.Second
+)
+
+// asyncPublisher queues messages in a bounded buffer and writes them in
+// batches from one goroutine per lane.
+type asyncPublisher struct {
+	config AsyncConfig
+	// write publishes msgs and returns the error of each.
+	write func(ctx context.Context, msgs []Message) []error
+
+	slots      chan struct{} // one per queued message
+	lanes      []chan *PublishFuture
+	roundRobin atomic.Uint32
+	ctx        context.Context // canceled once draining is over
+	cancel     context.CancelFunc
+	wg         sync.WaitGroup
+
+	// Each queued message takes the next sequence number. Flush waits for
+	// the low watermark, below which every message has completed, to reach
+	// the last number taken before the call.
+	mu        sync.Mutex
+	closed    bool
+	queued    uint64              // last sequence number taken
+	completed uint64              // every message up to it has completed
+	ahead     map[uint64]struct{} // completed above the watermark
+	progress  chan struct{}       // closed when completed advances
+}
+
+func newAsyncPublisher(config AsyncConfig, write func(ctx context.Context, msgs []Message) []error) *asyncPublisher {
+	if config.BufferSize <= 0 {
+		config.BufferSize = defaultAsyncBufferSize
+	}
+	if config.MaxBatch <= 0 {
+		config.MaxBatch = defaultAsyncMaxBatch
+	}
+	if config.Lanes <= 0 {
+		config.Lanes = defaultAsyncLanes
+	}
+	if config.DrainTimeout <= 0 {
+		config.DrainTimeout = defaultAsyncDrainTimeout
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	a := &asyncPublisher{
+		config:   config,
+		write:    write,
+		slots:    make(chan struct{}, config.BufferSize),
+		lanes:    make([]chan *PublishFuture, config.Lanes),
+		ctx:      ctx,
+		cancel:   cancel,
+		ahead:    make(map[uint64]struct{}),
+		progress: make(chan struct{}),
+	}
+	for i := range a.lanes {
+		// Slots bound the total, so sending to a lane never blocks.
+		a.lanes[i] = make(chan *PublishFuture, config.BufferSize)
+		a.wg.Add(1)
+		go a.run(a.lanes[i])
+	}
+	return a
+}
+
+func (a *asyncPublisher) enqueue(ctx context.Context, msg Message) *PublishFuture {
+	f := newPublishFuture(prepareMessage(msg))
+
+	a.mu.Lock()
+	if a.closed {
+		a.mu.Unlock()
+		f.complete(ErrBrokerClosed)
+		return f
+	}
+	a.queued++
+	f.seq = a.queued
+	a.mu.Unlock()
+
+	select {
+	case a.slots <- struct{}{}:
+	case <-ctx.Done():
+		a.finish(f, ctx.Err())
+		return f
+	case <-a.ctx.Done():
+		a.finish(f, ErrBrokerClosed)
+		return f
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.ctx.Err() != nil {
+		<-a.slots
+		a.finishLocked(f, ErrBrokerClosed)
+		return f
+	}
+	a.lanes[a.lane(f.msg.Key)] <- f
+	return f
+}
+
+func (a *asyncPublisher) lane(key string) int {
+	if key == "" {
+		return int(a.roundRobin.Add(1) % uint32(len(a.lanes)))
+	}
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return int(h.Sum32() % uint32(len(a.lanes)))
+}
+
+func (a *asyncPublisher) run(lane chan *PublishFuture) {
+	defer a.wg.Done()
+	for {
+		var batch []*PublishFuture
+		select {
+		case f := <-lane:
+			batch = append(batch, f)
+		case <-a.ctx.Done():
+			return
+		}
+	fill:
+		for len(batch) < a.config.MaxBatch {
+			select {
+			case f := <-lane:
+				batch = append(batch, f)
+			default:
+				break fill
+			}
+		}
+
+		msgs := make([]Message, len(batch))
+		for i, f := range batch {
+			msgs[i] = f.msg
+		}
+		errs := a.write(a.ctx, msgs)
+		for i, f := range batch {
+			<-a.slots
+			a.finish(f, errs[i])
+		}
+	}
+}
+
+func (a *asyncPublisher) finish(f *PublishFuture, err error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.finishLocked(f, err)
+}
+
+func (a *asyncPublisher) finishLocked(f *PublishFuture, err error) {
+	f.complete(err)
+	if f.seq != a.completed+1 {
+		a.ahead[f.seq] = struct{}{}
+		return
+	}
+	a.completed++
+	for {
+		if _, ok := a.ahead[a.completed+1]; !ok {
+			break
+		}
+		delete(a.ahead, a.completed+1)
+		a.completed++
+	}
+	close(a.progress)
+	a.progress = make(chan struct{})
+}
+
+// flush waits until every message queued before the call has completed,
+// ignoring those queued after it.
+func (a *asyncPublisher) flush(ctx context.Context) error {
+	a.mu.Lock()
+	target := a.queued
+	for a.completed < target {
+		progress := a.progress
+		a.mu.Unlock()
+		select {
+		case <-progress:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		a.mu.Lock()
+	}
+	a.mu.Unlock()
+	return nil
+}
+
+// close stops accepting messages and waits up to DrainTimeout for the
+// queued ones. It returns context.DeadlineExceeded if some were dropped.
+func (a *asyncPublisher) close() error {
+	a.mu.Lock()
+	if a.closed {
+		a.mu.Unlock()
+		return nil
+	}
+	a.closed = true
+	a.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), a.config.DrainTimeout)
+	err := a.flush(ctx)
+	cancel()
+
+	a.mu.Lock()
+	a.cancel()
+	a.mu.Unlock()
+	a.wg.Wait()
+
+	for _, lane := range a.lanes {
+		for len(lane) > 0 {
+			<-a.slots
+			a.finish(<-lane, ErrBrokerClosed)
+		}
+	}
+	return err
+}
+
+// BatchError is returned by PublishBatch when some messages were not
+// published.
+type BatchError struct {
+	// Errs holds the error of each message of the batch, in order; nil for
+	// the published ones.
+	Errs []error
+}
+
+func (e *BatchError) Error() string {
+	failed := 0
+	for _, err := range e.Errs {
+		if err != nil {
+			failed++
+		}
+	}
+	return fmt.Sprintf("error publishing %d of %d messages: %v", failed, len(e.Errs), e.Unwrap())
+}
+
+// Unwrap returns the first error of the batch.
+func (e *BatchError) Unwrap() error {
+	for _, err := range e.Errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// BatchErrors returns the error of each of the n messages of a batch that
+// PublishBatch returned err for.
+func BatchErrors(err error, n int) []error {
+	errs := make([]error, n)
+	var batchErr *BatchError
+	switch {
+	case err == nil:
+	case errors.As(err, &batchErr) && len(batchErr.Errs) == n:
+		copy(errs, batchErr.Errs)
+	default:
+		for i := range errs {
+			errs[i] = err
+		}
+	}
+	return errs
+}
+
+// batchError returns a *BatchError for errs, nil if every message was
+// published.
+func batchError(errs []error) error {
+	for _, err := range errs {
+		if err != nil {
+			return &BatchError{Errs: errs}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,123 @@
+package messaging
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// keysOnDifferentLanes returns two keys that a hashes to different lanes,
+// so a write blocked on one does not hold up the other.
+func keysOnDifferentLanes(t *testing.T, a *asyncPublisher) (string, string) {
+	t.Helper()
+	for i := 0; i < 100; i++ {
+		key := "key-" + strconv.Itoa(i)
+		if a.lane(key) != a.lane("key") {
+			return "key", key
+		}
+	}
+	t.Fatal("no keys on different lanes")
+	return "", ""
+}
+
+// blockingWrites returns a write function that blocks on the channel of
+// each key in release, until it is closed.
+func blockingWrites(release map[string]chan struct{}) func(ctx context.Context, msgs []Message) []error {
+	return func(ctx context.Context, msgs []Message) []error {
+		for _, msg := range msgs {
+			if ch, found := release[msg.Key]; found {
+				<-ch
+			}
+		}
+		return make([]error, len(msgs))
+	}
+}
+
+// Flush waits for a message queued before it, however long it takes.
+func TestAsyncFlushTimesOutOnPendingMessage(t *testing.T) {
+	release := map[string]chan struct{}{"slow": make(chan struct{})}
+	a := newAsyncPublisher(AsyncConfig{Lanes: 2}, blockingWrites(release))
+	defer func() {
+		close(release["slow"])
+		a.close()
+	}()
+	ctx := context.Background()
+
+	a.enqueue(ctx, Message{Topic: "orders", Key: "slow"})
+	flushCtx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	if err := a.flush(flushCtx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("flush with a pending message = %v, want DeadlineExceeded", err)
+	}
+}
+
+// Flush must not wait for messages queued after the call, even while they
+// are still pending.
+func TestAsyncFlushIgnoresLaterMessages(t *testing.T) {
+	release := map[string]chan struct{}{}
+	a := newAsyncPublisher(AsyncConfig{Lanes: 4}, blockingWrites(release))
+	early, late := keysOnDifferentLanes(t, a)
+	release[early], release[late] = make(chan struct{}), make(chan struct{})
+	defer func() {
+		close(release[late])
+		a.close()
+	}()
+	ctx := context.Background()
+
+	a.enqueue(ctx, Message{Topic: "orders", Key: early})
+	flushed := make(chan error, 1)
+	go func() { flushed <- a.flush(ctx) }()
+	// Let flush take its watermark before the later message is queued.
+	time.Sleep(20 * time.Millisecond)
+	pending := a.enqueue(ctx, Message{Topic: "orders", Key: late})
+
+	close(release[early])
+	select {
+	case err := <-flushed:
+		if err != nil {
+			t.Fatalf("flush: %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("flush waited for a message queued after it")
+	}
+	select {
+	case <-pending.Done():
+		t.Fatal("the later message completed before its release")
+	default:
+	}
+}
+
+func TestAsyncFlushWaitsForEarlierMessages(t *testing.T) {
+	release := map[string]chan struct{}{}
+	a := newAsyncPublisher(AsyncConfig{Lanes: 4}, blockingWrites(release))
+	slowKey, fastKey := keysOnDifferentLanes(t, a)
+	release[slowKey] = make(chan struct{})
+	defer a.close()
+	ctx := context.Background()
+
+	slow := a.enqueue(ctx, Message{Topic: "orders", Key: slowKey})
+	flushed := make(chan error, 1)
+	go func() { flushed <- a.flush(ctx) }()
+
+	// Messages queued after the call, completing first, do not end it.
+	for i := 0; i < 10; i++ {
+		if err := a.enqueue(ctx, Message{Topic: "orders", Key: fastKey}).Wait(ctx); err != nil {
+			t.Fatalf("Wait: %v", err)
+		}
+	}
+	select {
+	case err := <-flushed:
+		t.Fatalf("flush returned %v before the earlier message completed", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	close(release[slowKey])
+	if err := <-flushed; err != nil {
+		t.Fatalf("flush: %v", err)
+	}
+	if err := slow.Err(); err != nil {
+		t.Fatalf("slow message: %v", err)
+	}
+}
@@ -0,0 +1,116 @@
+package messaging
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// Headers added to messages moved to retry and dead-letter topics. The
+// original headers are kept alongside them.
+const (
+	HeaderOriginalTopic     = "x-original-topic"
+	HeaderOriginalPartition = "x-original-partition"
+	HeaderOriginalOffset    = "x-original-offset"
+	HeaderRetryStage        = "x-retry-stage"
+	HeaderRetryNotBefore    = "x-retry-not-before"
+	HeaderFailureReason     = "x-failure-reason"
+	HeaderFailedAt          = "x-failed-at"
+)
+
+// failureRouter moves messages whose handler keeps failing to the retry
+// topics and dead-letter topic of a subscription.
+type failureRouter struct {
+	topic           string
+	group           string
+	delays          []time.Duration
+	deadLetterTopic string // empty when there is no dead-letter topic
+	publish         func(ctx context.Context, msg Message) error
+}
+
+// newFailureRouter returns nil when the subscription does not route
+// failures.
+func newFailureRouter(topic, group string, options subscribeOptions, publish func(ctx context.Context, msg Message) error) *failureRouter {
+	if !options.routesFailures() {
+		return nil
+	}
+	router := &failureRouter{
+		topic:   topic,
+		group:   group,
+		delays:  options.retryDelays,
+		publish: publish,
+	}
+	if options.deadLetter {
+		router.deadLetterTopic = options.deadLetterTopic
+		if router.deadLetterTopic == "" {
+			router.deadLetterTopic = fmt.Sprintf("%s.%s.dlt", topic, group)
+		}
+	}
+	return router
+}
+
+// topics returns the topics a subscription consumes: the main topic and its
+// retry topics.
+func (r *failureRouter) topics() []string {
+	topics := []string{r.topic}
+	for stage := 1; stage <= len(r.delays); stage++ {
+		topics = append(topics, r.retryTopic(stage))
+	}
+	return topics
+}
+
+func (r *failureRouter) retryTopic(stage int) string {
+	return fmt.Sprintf("%s.%s.retry.%d", r.topic, r.group, stage)
+}
+
+// route builds the message that takes msg to the next retry topic, or to
+// the dead-letter topic once retries are exhausted or cause is permanent.
+// It returns false when there is nowhere left to send it.
+func (r *failureRouter) route(msg Message, cause error) (Message, bool) {
+	stage, _ := strconv.Atoi(msg.Header(HeaderRetryStage))
+	now := time.Now()
+
+	headers := make(map[string]string, len(msg.Headers)+7)
+	for name, value := range msg.Headers {
+		headers[name] = value
+	}
+	if headers[HeaderOriginalTopic] == "" {
+		headers[HeaderOriginalTopic] = msg.Topic
+		headers[HeaderOriginalPartition] = strconv.Itoa(msg.Partition)
+		headers[HeaderOriginalOffset] = strconv.FormatInt(msg.Offset, 10)
+	}
+	headers[HeaderFailureReason] = cause.Error()
+	headers[HeaderFailedAt] = now.UTC().Format(time.RFC3339Nano)
+
+	routed := Message{
+		Key:         msg.Key,
+		Value:       msg.Value,
+		ID:          msg.ID,
+		ContentType: msg.ContentType,
+		Headers:     headers,
+		Time:        msg.Time,
+	}
+	if !IsPermanent(cause) && stage < len(r.delays) {
+		routed.Topic = r.retryTopic(stage + 1)
+		headers[HeaderRetryStage] = strconv.Itoa(stage + 1)
+		headers[HeaderRetryNotBefore] = now.Add(r.delays[stage]).UTC().Format(time.RFC3339Nano)
+		return routed, true
+	}
+	if r.deadLetterTopic == "" {
+		return Message{}, false
+	}
+	routed.Topic = r.deadLetterTopic
+	delete(headers, HeaderRetryNotBefore)
+	return routed, true
+}
+
+// retryDelay returns how long msg must wait before it is handled, per its
+// HeaderRetryNotBefore header.
+func retryDelay(msg Message) time.Duration {
+	notBefore, err := time.Parse(time.RFC3339Nano, msg.Header(HeaderRetryNotBefore))
+	if err != nil {
+		return 0
+	}
+	return time.Until(notBefore)
+}
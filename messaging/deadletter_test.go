@@ -0,0 +1,200 @@
+package messaging
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newTestRouter(opts ...SubscribeOption) *failureRouter {
+	return newFailureRouter("orders", "svc", newSubscribeOptions(opts), func(ctx context.Context, msg Message) error {
+		return nil
+	})
+}
+
+func TestFailureRouterTopics(t *testing.T) {
+	if router := newTestRouter(WithHandlerRetry(RetryPolicy{MaxAttempts: 5})); router != nil {
+		t.Fatal("router built without retry or dead-letter topics")
+	}
+
+	router := newTestRouter(WithRetryTopics(time.Second, time.Minute), WithDeadLetterTopic(""))
+	want := []string{"orders", "orders.svc.retry.1", "orders.svc.retry.2"}
+	if topics := router.topics(); !reflect.DeepEqual(topics, want) {
+		t.Fatalf("topics = %v, want %v", topics, want)
+	}
+	if router.deadLetterTopic != "orders.svc.dlt" {
+		t.Fatalf("dead-letter topic = %q, want orders.svc.dlt", router.deadLetterTopic)
+	}
+	if router := newTestRouter(WithDeadLetterTopic("poison")); router.deadLetterTopic != "poison" {
+		t.Fatalf("dead-letter topic = %q, want poison", router.deadLetterTopic)
+	}
+}
+
+func TestFailureRouterRoute(t *testing.T) {
+	router := newTestRouter(WithRetryTopics(time.Second, time.Minute), WithDeadLetterTopic(""))
+	msg := Message{
+		Topic:       "orders",
+		Partition:   2,
+		Offset:      41,
+		Key:         "o1",
+		Value:       []byte(`{"id":1}`),
+		ID:          "m1",
+		ContentType: ContentTypeJSON,
+		Headers:     map[string]string{HeaderType: "order.created"},
+		Time:        time.Now().Add(-time.Hour),
+	}
+
+	start := time.Now()
+	first, ok := router.route(msg, errors.New("first failure"))
+	if !ok || first.Topic != "orders.svc.retry.1" {
+		t.Fatalf("route = %s, %v, want orders.svc.retry.1", first.Topic, ok)
+	}
+	if first.Key != msg.Key || first.ID != msg.ID || first.ContentType != msg.ContentType || !first.Time.Equal(msg.Time) || string(first.Value) != string(msg.Value) {
+		t.Fatalf("routed message = %+v, want the content of %+v", first, msg)
+	}
+	for name, want := range map[string]string{
+		HeaderType:              "order.created",
+		HeaderOriginalTopic:     "orders",
+		HeaderOriginalPartition: "2",
+		HeaderOriginalOffset:    "41",
+		HeaderRetryStage:        "1",
+		HeaderFailureReason:     "first failure",
+	} {
+		if got := first.Header(name); got != want {
+			t.Fatalf("header %s = %q, want %q", name, got, want)
+		}
+	}
+	notBefore, err := time.Parse(time.RFC3339Nano, first.Header(HeaderRetryNotBefore))
+	if err != nil || notBefore.Before(start.Add(time.Second)) || notBefore.After(time.Now().Add(time.Second)) {
+		t.Fatalf("not-before header = %q, want a second from now", first.Header(HeaderRetryNotBefore))
+	}
+	if _, err := time.Parse(time.RFC3339Nano, first.Header(HeaderFailedAt)); err != nil {
+		t.Fatalf("failed-at header = %q: %v", first.Header(HeaderFailedAt), err)
+	}
+	if msg.Header(HeaderRetryStage) != "" {
+		t.Fatal("route changed the headers of the failed message")
+	}
+
+	// Read back from the retry topic, it moves on to the next stage and
+	// keeps the original position.
+	first.Partition, first.Offset = 0, 7
+	second, ok := router.route(first, errors.New("second failure"))
+	if !ok || second.Topic != "orders.svc.retry.2" {
+		t.Fatalf("route = %s, %v, want orders.svc.retry.2", second.Topic, ok)
+	}
+	if second.Header(HeaderRetryStage) != "2" || second.Header(HeaderOriginalTopic) != "orders" || second.Header(HeaderOriginalOffset) != "41" || second.Header(HeaderFailureReason) != "second failure" {
+		t.Fatalf("headers = %v", second.Headers)
+	}
+	if delay := retryDelay(second); delay < 59*time.Second || delay > time.Minute {
+		t.Fatalf("retry delay = %v, want a minute", delay)
+	}
+
+	dead, ok := router.route(second, errors.New("last failure"))
+	if !ok || dead.Topic != "orders.svc.dlt" {
+		t.Fatalf("route = %s, %v, want orders.svc.dlt", dead.Topic, ok)
+	}
+	if dead.Header(HeaderRetryStage) != "2" || dead.Header(HeaderOriginalPartition) != "2" || dead.Header(HeaderFailureReason) != "last failure" {
+		t.Fatalf("headers = %v", dead.Headers)
+	}
+	if _, found := dead.Headers[HeaderRetryNotBefore]; found {
+		t.Fatal("dead-lettered message keeps its not-before header")
+	}
+	if delay := retryDelay(dead); delay != 0 {
+		t.Fatalf("retry delay of a dead-lettered message = %v, want 0", delay)
+	}
+}
+
+func TestFailureRouterPermanentErrorsSkipRetryTopics(t *testing.T) {
+	router := newTestRouter(WithRetryTopics(time.Second), WithDeadLetterTopic(""))
+	msg := Message{Topic: "orders", Key: "o1", Offset: 3}
+
+	cause := Permanent(errors.New("invalid order"))
+	dead, ok := router.route(msg, cause)
+	if !ok || dead.Topic != "orders.svc.dlt" {
+		t.Fatalf("route = %s, %v, want orders.svc.dlt", dead.Topic, ok)
+	}
+	if dead.Header(HeaderRetryStage) != "" || dead.Header(HeaderFailureReason) != cause.Error() {
+		t.Fatalf("headers = %v", dead.Headers)
+	}
+
+	// Without a dead-letter topic there is nowhere to send it.
+	router = newTestRouter(WithRetryTopics(time.Second))
+	if _, ok := router.route(msg, cause); ok {
+		t.Fatal("routed a permanent failure without a dead-letter topic")
+	}
+	retried, _ := router.route(msg, errors.New("unavailable"))
+	if _, ok := router.route(retried, errors.New("unavailable")); ok {
+		t.Fatal("routed past the last retry topic without a dead-letter topic")
+	}
+}
+
+// routedLog records the messages a router publishes.
+type routedLog struct {
+	mu   sync.Mutex
+	msgs []Message
+}
+
+func (r *routedLog) publish(_ context.Context, msg Message) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.msgs = append(r.msgs, msg)
+	return nil
+}
+
+func (r *routedLog) routed() []Message {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]Message(nil), r.msgs...)
+}
+
+func TestDispatcherRoutesAfterMaxAttempts(t *testing.T) {
+	for _, tt := range []struct {
+		name     string
+		err      error
+		policy   RetryPolicy
+		attempts int
+	}{
+		{"transient default", errors.New("unavailable"), RetryPolicy{InitialBackoff: time.Millisecond}, defaultRoutedAttempts},
+		{"transient", errors.New("unavailable"), RetryPolicy{MaxAttempts: 5, InitialBackoff: time.Millisecond}, 5},
+		{"permanent", Permanent(errors.New("invalid order")), RetryPolicy{MaxAttempts: 5, InitialBackoff: time.Millisecond}, 1},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			options := newSubscribeOptions([]SubscribeOption{WithHandlerRetry(tt.policy), WithDeadLetterTopic("")})
+			var routed routedLog
+			router := newFailureRouter("orders", "svc", options, routed.publish)
+
+			var mu sync.Mutex
+			var calls []time.Time
+			commits := &commitLog{}
+			d := newDispatcher(context.Background(), func(ctx context.Context, msg Message) error {
+				mu.Lock()
+				defer mu.Unlock()
+				calls = append(calls, time.Now())
+				return tt.err
+			}, options, router, commits.commit)
+			defer d.close()
+
+			dispatchAll(t, d, Message{Topic: "orders", Key: "o1", Offset: 0})
+			waitFor(t, "the commit", func() bool { return len(commits.committed()) == 1 })
+
+			mu.Lock()
+			defer mu.Unlock()
+			if len(calls) != tt.attempts {
+				t.Fatalf("handler called %d times, want %d", len(calls), tt.attempts)
+			}
+			// Attempts back off exponentially from the initial backoff.
+			for i := 1; i < len(calls); i++ {
+				if wait := calls[i].Sub(calls[i-1]); wait < tt.policy.Backoff(i) {
+					t.Fatalf("attempt %d waited %v, want at least %v", i+1, wait, tt.policy.Backoff(i))
+				}
+			}
+			msgs := routed.routed()
+			if len(msgs) != 1 || msgs[0].Topic != "orders.svc.dlt" || msgs[0].Header(HeaderFailureReason) != tt.err.Error() {
+				t.Fatalf("routed %+v, want the message on orders.svc.dlt", msgs)
+			}
+		})
+	}
+}
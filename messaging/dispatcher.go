@@ -0,0 +1,278 @@
+package messaging
+
+import (
+	"context"
+	"hash/fnv"
+	"sync"
+	"time"
+
+	"github.com/mauriciomartinezc/real-estate-mc-common/logger"
+)
+
+// dispatcher runs a Handler over the messages of one subscription with a
+// fixed number of workers per partition, and commits offsets only once
+// every earlier message of the partition has been handled or routed to a
+// retry or dead-letter topic.
+type dispatcher struct {
+	ctx         context.Context // passed to the handler
+	handler     Handler
+	concurrency int
+	retry       RetryPolicy
+	router      *failureRouter // nil when failures are not routed
+	commit      func(ctx context.Context, msg Message) error
+
+	mu         sync.Mutex
+	partitions map[int]*partitionWorkers
+	stopping   chan struct{}
+	wg         sync.WaitGroup
+}
+
+type partitionWorkers struct {
+	queues []chan *trackedMessage
+
+	mu       sync.Mutex
+	inflight []*trackedMessage // in dispatch order
+
+	commitMu  sync.Mutex
+	committed int64
+}
+
+type trackedMessage struct {
+	msg  Message
+	done bool
+}
+
+func newDispatcher(ctx context.Context, handler Handler, options subscribeOptions, router *failureRouter, commit func(ctx context.Context, msg Message) error) *dispatcher {
+	return &dispatcher{
+		ctx:         ctx,
+		handler:     handler,
+		concurrency: options.concurrency,
+		retry:       options.retry,
+		router:      router,
+		commit:      commit,
+		partitions:  make(map[int]*partitionWorkers),
+		stopping:    make(chan struct{}),
+	}
+}
+
+// dispatch queues msg for its worker, blocking while the worker is busy. It
+// must not be called after close.
+func (d *dispatcher) dispatch(ctx context.Context, msg Message) error {
+	p := d.partition(msg.Partition)
+	tracked := &trackedMessage{msg: msg}
+	p.mu.Lock()
+	p.inflight = append(p.inflight, tracked)
+	p.mu.Unlock()
+
+	h := fnv.New32a()
+	h.Write([]byte(msg.Key))
+	select {
+	case p.queues[h.Sum32()%uint32(len(p.queues))] <- tracked:
+		return nil
+	case <-ctx.Done():
+		// Not queued, so it must not hold back the commits of later
+		// messages if the partition is fetched again.
+		p.mu.Lock()
+		for i, t := range p.inflight {
+			if t == tracked {
+				p.inflight = append(p.inflight[:i], p.inflight[i+1:]...)
+				break
+			}
+		}
+		p.mu.Unlock()
+		return ctx.Err()
+	}
+}
+
+// close stops the workers once their current message is handled. Queued
+// messages are left uncommitted.
+func (d *dispatcher) close() {
+	close(d.stopping)
+	d.mu.Lock()
+	for _, p := range d.partitions {
+		for _, queue := range p.queues {
+			close(queue)
+		}
+	}
+	d.mu.Unlock()
+	d.wg.Wait()
+}
+
+func (d *dispatcher) partition(partition int) *partitionWorkers {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if p, found := d.partitions[partition]; found {
+		return p
+	}
+
+	p := &partitionWorkers{
+		queues:    make([]chan *trackedMessage, d.concurrency),
+		committed: -1,
+	}
+	for i := range p.queues {
+		p.queues[i] = make(chan *trackedMessage)
+		d.wg.Add(1)
+		go d.work(p, p.queues[i])
+	}
+	d.partitions[partition] = p
+	return p
+}
+
+func (d *dispatcher) work(p *partitionWorkers, queue chan *trackedMessage) {
+	defer d.wg.Done()
+	for tracked := range queue {
+		if d.stopped() || !d.wait(retryDelay(tracked.msg)) || !d.handle(tracked.msg) {
+			continue
+		}
+		d.complete(p, tracked)
+	}
+}
+
+// handle calls the handler until it succeeds, retrying in place per the
+// retry policy. Messages that fail permanently or run out of attempts are
+// routed to the next retry or dead-letter topic; without one, permanent
+// failures are skipped and others retried indefinitely so nothing is lost.
+// Errors wrapped with RetryLater are retried after their delay without
+// counting an attempt. It returns false if it gave up because the
+// dispatcher stopped or the context is done.
+func (d *dispatcher) handle(msg Message) bool {
+	for attempt := 1; ; attempt++ {
+		err := d.handler(d.ctx, msg)
+		if err == nil {
+			return true
+		}
+
+		if delay, later := retryLaterDelay(err); later {
+			if delay <= 0 {
+				delay = d.retry.Backoff(1)
+			}
+			logger.Debug().Err(err).
+				Str("topic", msg.Topic).
+				Int("partition", msg.Partition).
+				Int64("offset", msg.Offset).
+				Dur("retry_in", delay).
+				Msg("Message handler deferred")
+			if !d.wait(delay) {
+				return false
+			}
+			attempt--
+			continue
+		}
+
+		permanent := IsPermanent(err)
+		if d.router != nil && (permanent || d.retry.exhausted(attempt)) {
+			if routed, ok := d.router.route(msg, err); ok {
+				return d.deliver(msg, routed)
+			}
+		}
+		if permanent {
+			logger.Error().Err(err).
+				Str("topic", msg.Topic).
+				Int("partition", msg.Partition).
+				Int64("offset", msg.Offset).
+				Str("message_id", msg.ID).
+				Msg("Message handler failed permanently, skipping message")
+			return true
+		}
+
+		backoff := d.retry.Backoff(attempt)
+		logger.Error().Err(err).
+			Str("topic", msg.Topic).
+			Int("partition", msg.Partition).
+			Int64("offset", msg.Offset).
+			Int("attempt", attempt).
+			Dur("retry_in", backoff).
+			Msg("Message handler failed")
+		if !d.wait(backoff) {
+			return false
+		}
+	}
+}
+
+// deliver publishes routed, the failed msg bound for a retry or dead-letter
+// topic, until it succeeds or the dispatcher stops.
+func (d *dispatcher) deliver(msg, routed Message) bool {
+	for attempt := 1; ; attempt++ {
+		err := d.router.publish(d.ctx, routed)
+		if err == nil {
+			logger.Warn().
+				Str("topic", msg.Topic).
+				Int("partition", msg.Partition).
+				Int64("offset", msg.Offset).
+				Str("message_id", msg.ID).
+				Str("routed_to", routed.Topic).
+				Str("reason", routed.Header(HeaderFailureReason)).
+				Msg("Message handler failed, message moved")
+			return true
+		}
+
+		backoff := d.retry.Backoff(attempt)
+		logger.Error().Err(err).
+			Str("topic", msg.Topic).
+			Str("routed_to", routed.Topic).
+			Dur("retry_in", backoff).
+			Msg("Failed to move failed message")
+		if !d.wait(backoff) {
+			return false
+		}
+	}
+}
+
+// wait sleeps for delay, returning false if the dispatcher stopped or the
+// context is done first.
+func (d *dispatcher) wait(delay time.Duration) bool {
+	if delay <= 0 {
+		return true
+	}
+	select {
+	case <-time.After(delay):
+		return true
+	case <-d.stopping:
+		return false
+	case <-d.ctx.Done():
+		return false
+	}
+}
+
+// complete marks tracked as handled and commits the highest offset below
+// which every message of the partition has been handled.
+func (d *dispatcher) complete(p *partitionWorkers, tracked *trackedMessage) {
+	p.mu.Lock()
+	tracked.done = true
+	var last *trackedMessage
+	for len(p.inflight) > 0 && p.inflight[0].done {
+		last = p.inflight[0]
+		p.inflight = p.inflight[1:]
+	}
+	p.mu.Unlock()
+	if last == nil {
+		return
+	}
+
+	// Commits of one partition must not overtake each other.
+	p.commitMu.Lock()
+	defer p.commitMu.Unlock()
+	if last.msg.Offset <= p.committed {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(d.ctx), 10*time.Second)
+	defer cancel()
+	if err := d.commit(ctx, last.msg); err != nil {
+		logger.Error().Err(err).
+			Str("topic", last.msg.Topic).
+			Int("partition", last.msg.Partition).
+			Int64("offset", last.msg.Offset).
+			Msg("Failed to commit message offset")
+		return
+	}
+	p.committed = last.msg.Offset
+}
+
+func (d *dispatcher) stopped() bool {
+	select {
+	case <-d.stopping:
+		return true
+	default:
+		return false
+	}
+}
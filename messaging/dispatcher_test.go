@@ -0,0 +1,246 @@
+package messaging
+
+import (
+	"context"
+	"errors"
+	"hash/fnv"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// commitLog records the offsets a dispatcher commits.
+type commitLog struct {
+	mu      sync.Mutex
+	offsets []int64
+}
+
+func (c *commitLog) commit(_ context.Context, msg Message) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.offsets = append(c.offsets, msg.Offset)
+	return nil
+}
+
+func (c *commitLog) committed() []int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]int64(nil), c.offsets...)
+}
+
+func newTestDispatcher(t *testing.T, handler Handler, opts ...SubscribeOption) (*dispatcher, *commitLog) {
+	t.Helper()
+	commits := &commitLog{}
+	return newDispatcher(context.Background(), handler, newSubscribeOptions(opts), nil, commits.commit), commits
+}
+
+// keysOnDifferentQueues returns two keys that go to different workers of
+// a partition with n workers.
+func keysOnDifferentQueues(t *testing.T, n int) (string, string) {
+	t.Helper()
+	queue := func(key string) uint32 {
+		h := fnv.New32a()
+		h.Write([]byte(key))
+		return h.Sum32() % uint32(n)
+	}
+	for i := 0; i < 100; i++ {
+		key := "key-" + strconv.Itoa(i)
+		if queue(key) != queue("key") {
+			return "key", key
+		}
+	}
+	t.Fatal("no keys on different queues")
+	return "", ""
+}
+
+func dispatchAll(t *testing.T, d *dispatcher, msgs ...Message) {
+	t.Helper()
+	for _, msg := range msgs {
+		if err := d.dispatch(context.Background(), msg); err != nil {
+			t.Fatalf("dispatch: %v", err)
+		}
+	}
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestDispatcherKeepsKeyOrderAcrossWorkers(t *testing.T) {
+	var mu sync.Mutex
+	handled := map[string][]int64{}
+	d, commits := newTestDispatcher(t, func(ctx context.Context, msg Message) error {
+		// Later messages finish sooner, so only the queues keep the order.
+		time.Sleep(time.Duration(10-msg.Offset%10) * 100 * time.Microsecond)
+		mu.Lock()
+		handled[msg.Key] = append(handled[msg.Key], msg.Offset)
+		mu.Unlock()
+		return nil
+	}, WithConcurrency(4))
+
+	const total = 200
+	for i := 0; i < total; i++ {
+		dispatchAll(t, d, Message{Topic: "orders", Key: "key-" + strconv.Itoa(i%8), Offset: int64(i)})
+	}
+	waitFor(t, "the last commit", func() bool {
+		offsets := commits.committed()
+		return len(offsets) > 0 && offsets[len(offsets)-1] == total-1
+	})
+	d.close()
+
+	mu.Lock()
+	defer mu.Unlock()
+	count := 0
+	for key, offsets := range handled {
+		for i := 1; i < len(offsets); i++ {
+			if offsets[i] <= offsets[i-1] {
+				t.Fatalf("key %s handled out of order: %v", key, offsets)
+			}
+		}
+		count += len(offsets)
+	}
+	if count != total {
+		t.Fatalf("handled %d messages, want %d", count, total)
+	}
+	// Commits only move forward.
+	offsets := commits.committed()
+	for i := 1; i < len(offsets); i++ {
+		if offsets[i] <= offsets[i-1] {
+			t.Fatalf("commits went back: %v", offsets)
+		}
+	}
+}
+
+func TestDispatcherCommitsAfterEarlierMessages(t *testing.T) {
+	slow, fast := keysOnDifferentQueues(t, 2)
+	release := make(chan struct{})
+	var fastHandled sync.WaitGroup
+	fastHandled.Add(1)
+	d, commits := newTestDispatcher(t, func(ctx context.Context, msg Message) error {
+		if msg.Key == slow {
+			<-release
+		} else {
+			fastHandled.Done()
+		}
+		return nil
+	}, WithConcurrency(2))
+	defer d.close()
+
+	dispatchAll(t, d,
+		Message{Topic: "orders", Key: slow, Offset: 0},
+		Message{Topic: "orders", Key: fast, Offset: 1},
+	)
+	// The later message is handled while the earlier one is in progress,
+	// but its offset is held back.
+	fastHandled.Wait()
+	time.Sleep(20 * time.Millisecond)
+	if offsets := commits.committed(); len(offsets) != 0 {
+		t.Fatalf("committed %v before the earlier message was handled", offsets)
+	}
+
+	close(release)
+	waitFor(t, "the commit", func() bool { return len(commits.committed()) > 0 })
+	if offsets := commits.committed(); len(offsets) != 1 || offsets[0] != 1 {
+		t.Fatalf("committed %v, want only offset 1", offsets)
+	}
+
+	// Partitions are committed independently.
+	dispatchAll(t, d, Message{Topic: "orders", Key: slow, Partition: 1, Offset: 0})
+	waitFor(t, "the other partition's commit", func() bool { return len(commits.committed()) == 2 })
+}
+
+func TestDispatcherCloseFinishesCurrentMessage(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	d, commits := newTestDispatcher(t, func(ctx context.Context, msg Message) error {
+		close(started)
+		<-release
+		return nil
+	})
+	dispatchAll(t, d, Message{Topic: "orders", Key: "a", Offset: 0})
+	<-started
+
+	closed := make(chan struct{})
+	go func() {
+		d.close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+		t.Fatal("close returned while a message was being handled")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	close(release)
+	select {
+	case <-closed:
+	case <-time.After(2 * time.Second):
+		t.Fatal("close did not return after the message was handled")
+	}
+	if offsets := commits.committed(); len(offsets) != 1 || offsets[0] != 0 {
+		t.Fatalf("committed %v, want the handled message", offsets)
+	}
+}
+
+func TestDispatcherCloseStopsRetries(t *testing.T) {
+	var attempts sync.WaitGroup
+	attempts.Add(1)
+	var once sync.Once
+	d, commits := newTestDispatcher(t, func(ctx context.Context, msg Message) error {
+		once.Do(attempts.Done)
+		return errors.New("unavailable")
+	}, WithHandlerRetry(RetryPolicy{InitialBackoff: time.Hour}))
+	dispatchAll(t, d, Message{Topic: "orders", Key: "a", Offset: 0})
+	attempts.Wait()
+
+	// Close does not wait out the backoff, and the failed message is left
+	// uncommitted for the next consumer.
+	closed := make(chan struct{})
+	go func() {
+		d.close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(2 * time.Second):
+		t.Fatal("close waited for the retry backoff")
+	}
+	if offsets := commits.committed(); len(offsets) != 0 {
+		t.Fatalf("committed %v, want nothing", offsets)
+	}
+}
+
+func TestDispatcherDispatchCanceled(t *testing.T) {
+	release := make(chan struct{})
+	d, commits := newTestDispatcher(t, func(ctx context.Context, msg Message) error {
+		if msg.Offset == 0 {
+			<-release
+		}
+		return nil
+	})
+	defer d.close()
+	dispatchAll(t, d, Message{Topic: "orders", Key: "a", Offset: 0})
+
+	// The worker is busy, so the next message is not queued.
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := d.dispatch(ctx, Message{Topic: "orders", Key: "a", Offset: 1}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("dispatch error = %v, want DeadlineExceeded", err)
+	}
+
+	// The message that was not queued does not hold back later commits.
+	close(release)
+	dispatchAll(t, d, Message{Topic: "orders", Key: "a", Offset: 2})
+	waitFor(t, "the commit of offset 2", func() bool {
+		offsets := commits.committed()
+		return len(offsets) > 0 && offsets[len(offsets)-1] == 2
+	})
+}
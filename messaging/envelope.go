@@ -0,0 +1,292 @@
+package messaging
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Header names follow the CloudEvents Kafka binary content mode, so a
+// message with a type and a source is also a valid CloudEvent: attributes
+// travel as "ce_" headers and the payload as the message value.
+const (
+	HeaderID            = "ce_id"
+	HeaderSpecVersion   = "ce_specversion"
+	HeaderType          = "ce_type"
+	HeaderSource        = "ce_source"
+	HeaderSubject       = "ce_subject"
+	HeaderTime          = "ce_time"
+	HeaderDataSchema    = "ce_dataschema"
+	HeaderContentType   = "content-type"
+	HeaderCorrelationID = "ce_correlationid"
+	HeaderCompanyID     = "ce_companyid"
+	HeaderLocale        = "ce_locale"
+	HeaderSchemaVersion = "ce_schemaversion"
+)
+
+const (
+	// CloudEventsSpecVersion is the CloudEvents version produced.
+	CloudEventsSpecVersion = "1.0"
+	// ContentTypeJSON is the content type of JSON payloads.
+	ContentTypeJSON = "application/json"
+	// ContentTypeCloudEventsJSON marks a structured CloudEvent, whose value
+	// holds the whole event as JSON.
+	ContentTypeCloudEventsJSON = "application/cloudevents+json"
+)
+
+// MessageOption sets metadata on a message built by NewMessage or
+// PublishJSON.
+type MessageOption func(*Message)
+
+// WithHeader sets a custom header.
+func WithHeader(name, value string) MessageOption {
+	return func(m *Message) {
+		if m.Headers == nil {
+			m.Headers = make(map[string]string)
+		}
+		m.Headers[name] = value
+	}
+}
+
+// WithMessageID overrides the generated message ID.
+func WithMessageID(id string) MessageOption {
+	return func(m *Message) {
+		m.ID = id
+	}
+}
+
+// WithEventType sets the CloudEvents type, e.g. "property.created".
+func WithEventType(eventType string) MessageOption {
+	return WithHeader(HeaderType, eventType)
+}
+
+// WithSource sets the CloudEvents source, usually the producing service.
+func WithSource(source string) MessageOption {
+	return WithHeader(HeaderSource, source)
+}
+
+// WithCorrelationID propagates the ID correlating a chain of requests and
+// messages.
+func WithCorrelationID(id string) MessageOption {
+	return WithHeader(HeaderCorrelationID, id)
+}
+
+// WithCompanyID propagates the tenant, the X-Company-Id of HTTP requests.
+func WithCompanyID(companyID string) MessageOption {
+	return WithHeader(HeaderCompanyID, companyID)
+}
+
+// WithLocale propagates the negotiated language, e.g. "es".
+func WithLocale(locale string) MessageOption {
+	return WithHeader(HeaderLocale, locale)
+}
+
+// WithSchemaVersion records the version of the payload schema.
+func WithSchemaVersion(version string) MessageOption {
+	return WithHeader(HeaderSchemaVersion, version)
+}
+
+// NewMessage builds a message with a fresh ID and the current time.
+func NewMessage(topic, key string, value []byte, opts ...MessageOption) Message {
+	msg := Message{
+		Topic: topic,
+		Key:   key,
+		Value: value,
+		ID:    uuid.NewString(),
+		Time:  time.Now(),
+	}
+	for _, opt := range opts {
+		opt(&msg)
+	}
+	return msg
+}
+
+// prepareMessage fills the ID and time of a message about to be published,
+// and the spec version of messages carrying an event type.
+func prepareMessage(msg Message) Message {
+	if msg.ID == "" {
+		msg.ID = uuid.NewString()
+	}
+	if msg.Time.IsZero() {
+		msg.Time = time.Now()
+	}
+	if msg.Header(HeaderType) != "" && msg.Header(HeaderSpecVersion) == "" {
+		headers := make(map[string]string, len(msg.Headers)+1)
+		for name, value := range msg.Headers {
+			headers[name] = value
+		}
+		headers[HeaderSpecVersion] = CloudEventsSpecVersion
+		msg.Headers = headers
+	}
+	return msg
+}
+
+// CloudEvent is a CloudEvents 1.0 event. Extension attributes, such as the
+// correlation and company IDs, are kept in Extensions and flattened into the
+// JSON object. In JSON, Data is written as "data" when DataContentType is
+// empty or JSON, and base64 encoded as "data_base64" otherwise.
+type CloudEvent struct {
+	SpecVersion     string            `json:"specversion"`
+	ID              string            `json:"id"`
+	Source          string            `json:"source"`
+	Type            string            `json:"type"`
+	Subject         string            `json:"subject,omitempty"`
+	Time            time.Time         `json:"time,omitempty"`
+	DataContentType string            `json:"datacontenttype,omitempty"`
+	DataSchema      string            `json:"dataschema,omitempty"`
+	Data            json.RawMessage   `json:"data,omitempty"`
+	Extensions      map[string]string `json:"-"`
+}
+
+// cloudEventAttributes are the attributes that are not extensions.
+var cloudEventAttributes = map[string]bool{
+	"specversion": true, "id": true, "source": true, "type": true, "subject": true,
+	"time": true, "datacontenttype": true, "dataschema": true, "data": true, "data_base64": true,
+}
+
+type cloudEventJSON CloudEvent
+
+func (e CloudEvent) MarshalJSON() ([]byte, error) {
+	binary := len(e.Data) > 0 && !e.hasJSONData()
+	attributes := cloudEventJSON(e)
+	if binary {
+		attributes.Data = nil
+	}
+	data, err := json.Marshal(attributes)
+	if err != nil {
+		return nil, err
+	}
+	object := make(map[string]interface{}, len(e.Extensions)+9)
+	for name, value := range e.Extensions {
+		object[name] = value
+	}
+	if err := json.Unmarshal(data, &object); err != nil {
+		return nil, err
+	}
+	if e.Time.IsZero() {
+		delete(object, "time")
+	}
+	if binary {
+		object["data_base64"] = base64.StdEncoding.EncodeToString(e.Data)
+	}
+	return json.Marshal(object)
+}
+
+func (e *CloudEvent) UnmarshalJSON(data []byte) error {
+	if err := json.Unmarshal(data, (*cloudEventJSON)(e)); err != nil {
+		return err
+	}
+	var object map[string]json.RawMessage
+	if err := json.Unmarshal(data, &object); err != nil {
+		return err
+	}
+	if raw, found := object["data_base64"]; found {
+		var encoded string
+		if err := json.Unmarshal(raw, &encoded); err != nil {
+			return fmt.Errorf("error decoding CloudEvent data_base64: %w", err)
+		}
+		decoded, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return fmt.Errorf("error decoding CloudEvent data_base64: %w", err)
+		}
+		e.Data = decoded
+	} else if len(e.Data) > 0 && !e.hasJSONData() {
+		// Non-JSON data may also be written as a JSON string.
+		var text string
+		if err := json.Unmarshal(e.Data, &text); err == nil {
+			e.Data = []byte(text)
+		}
+	}
+
+	e.Extensions = nil
+	for name, raw := range object {
+		if cloudEventAttributes[name] {
+			continue
+		}
+		var value string
+		if err := json.Unmarshal(raw, &value); err != nil {
+			value = string(raw)
+		}
+		if e.Extensions == nil {
+			e.Extensions = make(map[string]string)
+		}
+		e.Extensions[name] = value
+	}
+	return nil
+}
+
+// hasJSONData reports whether Data is JSON, as its content type is JSON or
+// unset.
+func (e CloudEvent) hasJSONData() bool {
+	return e.DataContentType == "" || isJSONContentType(e.DataContentType)
+}
+
+// ToMessage converts the event to a message in binary content mode.
+func (e CloudEvent) ToMessage(topic, key string) Message {
+	msg := Message{
+		Topic:       topic,
+		Key:         key,
+		Value:       e.Data,
+		ID:          e.ID,
+		ContentType: e.DataContentType,
+		Time:        e.Time,
+		Headers:     make(map[string]string, len(e.Extensions)+5),
+	}
+	for name, value := range e.Extensions {
+		msg.Headers["ce_"+name] = value
+	}
+	msg.Headers[HeaderSpecVersion] = CloudEventsSpecVersion
+	setIfNotEmpty(msg.Headers, HeaderType, e.Type)
+	setIfNotEmpty(msg.Headers, HeaderSource, e.Source)
+	setIfNotEmpty(msg.Headers, HeaderSubject, e.Subject)
+	setIfNotEmpty(msg.Headers, HeaderDataSchema, e.DataSchema)
+	return msg
+}
+
+// CloudEventFromMessage reads the event carried by msg, either as a
+// structured JSON event or in binary content mode.
+func CloudEventFromMessage(msg Message) (CloudEvent, error) {
+	if strings.HasPrefix(msg.ContentType, ContentTypeCloudEventsJSON) {
+		var event CloudEvent
+		if err := json.Unmarshal(msg.Value, &event); err != nil {
+			return CloudEvent{}, fmt.Errorf("error decoding structured CloudEvent: %w", err)
+		}
+		return event, nil
+	}
+
+	event := CloudEvent{
+		SpecVersion:     msg.Header(HeaderSpecVersion),
+		ID:              msg.ID,
+		Source:          msg.Header(HeaderSource),
+		Type:            msg.Header(HeaderType),
+		Subject:         msg.Header(HeaderSubject),
+		Time:            msg.Time,
+		DataContentType: msg.ContentType,
+		DataSchema:      msg.Header(HeaderDataSchema),
+		Data:            msg.Value,
+	}
+	if event.SpecVersion == "" {
+		event.SpecVersion = CloudEventsSpecVersion
+	}
+	for name, value := range msg.Headers {
+		attribute, ok := strings.CutPrefix(name, "ce_")
+		if !ok || cloudEventAttributes[attribute] {
+			continue
+		}
+		if event.Extensions == nil {
+			event.Extensions = make(map[string]string)
+		}
+		event.Extensions[attribute] = value
+	}
+	return event, nil
+}
+
+func setIfNotEmpty(headers map[string]string, name, value string) {
+	if value != "" {
+		headers[name] = value
+	}
+}
@@ -0,0 +1,180 @@
+package messaging_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/mauriciomartinezc/real-estate-mc-common/messaging"
+)
+
+func assertSameEvent(t *testing.T, got, want messaging.CloudEvent) {
+	t.Helper()
+	if !got.Time.Equal(want.Time) {
+		t.Fatalf("Time = %v, want %v", got.Time, want.Time)
+	}
+	if !bytes.Equal(got.Data, want.Data) {
+		t.Fatalf("Data = %q, want %q", got.Data, want.Data)
+	}
+	got.Time, want.Time = time.Time{}, time.Time{}
+	got.Data, want.Data = nil, nil
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("event = %+v, want %+v", got, want)
+	}
+}
+
+// decodeObject returns the top-level members of an encoded event.
+func decodeObject(t *testing.T, data []byte) map[string]json.RawMessage {
+	t.Helper()
+	var object map[string]json.RawMessage
+	if err := json.Unmarshal(data, &object); err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+	return object
+}
+
+func TestCloudEventBinaryToStructuredAndBack(t *testing.T) {
+	msg := messaging.NewMessage("orders", "o1", []byte(`{"id":1}`),
+		messaging.WithEventType("order.created"),
+		messaging.WithSource("orders-service"),
+		messaging.WithHeader(messaging.HeaderSubject, "o1"),
+		messaging.WithCorrelationID("c1"),
+		messaging.WithCompanyID("42"),
+	)
+	msg.ContentType = messaging.ContentTypeJSON
+
+	event, err := messaging.CloudEventFromMessage(msg)
+	if err != nil {
+		t.Fatalf("CloudEventFromMessage: %v", err)
+	}
+	want := messaging.CloudEvent{
+		SpecVersion:     messaging.CloudEventsSpecVersion,
+		ID:              msg.ID,
+		Source:          "orders-service",
+		Type:            "order.created",
+		Subject:         "o1",
+		Time:            msg.Time,
+		DataContentType: messaging.ContentTypeJSON,
+		Data:            json.RawMessage(`{"id":1}`),
+		Extensions:      map[string]string{"correlationid": "c1", "companyid": "42"},
+	}
+	assertSameEvent(t, event, want)
+
+	structured, err := json.Marshal(event)
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+	object := decodeObject(t, structured)
+	// Extensions are flattened, and JSON data is embedded as is.
+	if string(object["correlationid"]) != `"c1"` || string(object["data"]) != `{"id":1}` {
+		t.Fatalf("structured event = %s", structured)
+	}
+	if _, found := object["data_base64"]; found {
+		t.Fatalf("JSON data base64 encoded: %s", structured)
+	}
+
+	back, err := messaging.CloudEventFromMessage(messaging.Message{
+		Topic:       "orders",
+		Key:         "o1",
+		Value:       structured,
+		ContentType: messaging.ContentTypeCloudEventsJSON + "; charset=utf-8",
+	})
+	if err != nil {
+		t.Fatalf("CloudEventFromMessage of the structured event: %v", err)
+	}
+	assertSameEvent(t, back, want)
+
+	binary := back.ToMessage("orders", "o1")
+	if binary.ID != msg.ID || binary.ContentType != msg.ContentType || !binary.Time.Equal(msg.Time) || !bytes.Equal(binary.Value, msg.Value) {
+		t.Fatalf("ToMessage = %+v, want %+v", binary, msg)
+	}
+	wantHeaders := map[string]string{messaging.HeaderSpecVersion: messaging.CloudEventsSpecVersion}
+	for name, value := range msg.Headers {
+		wantHeaders[name] = value
+	}
+	if !reflect.DeepEqual(binary.Headers, wantHeaders) {
+		t.Fatalf("ToMessage headers = %v, want %v", binary.Headers, wantHeaders)
+	}
+}
+
+func TestCloudEventNonJSONData(t *testing.T) {
+	event := messaging.CloudEvent{
+		SpecVersion:     messaging.CloudEventsSpecVersion,
+		ID:              "1",
+		Source:          "files-service",
+		Type:            "file.uploaded",
+		DataContentType: "application/octet-stream",
+		Data:            []byte{0, 1, 2, 0xff},
+	}
+	encoded, err := json.Marshal(event)
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+	object := decodeObject(t, encoded)
+	if string(object["data_base64"]) != `"AAEC/w=="` {
+		t.Fatalf("data_base64 = %s, want AAEC/w==", object["data_base64"])
+	}
+	if _, found := object["data"]; found {
+		t.Fatalf("binary data written as data: %s", encoded)
+	}
+
+	var decoded messaging.CloudEvent
+	if err := json.Unmarshal(encoded, &decoded); err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+	assertSameEvent(t, decoded, event)
+
+	// Other producers may send non-JSON data as a string.
+	var text messaging.CloudEvent
+	err = json.Unmarshal([]byte(`{"specversion":"1.0","id":"2","source":"s","type":"t","datacontenttype":"text/plain","data":"hello"}`), &text)
+	if err != nil || string(text.Data) != "hello" {
+		t.Fatalf("Unmarshal of text data = %q, %v, want hello", text.Data, err)
+	}
+
+	var invalid messaging.CloudEvent
+	if err := json.Unmarshal([]byte(`{"specversion":"1.0","id":"3","data_base64":"not base64!"}`), &invalid); err == nil {
+		t.Fatal("Unmarshal accepted invalid data_base64")
+	}
+
+	// JSON subtypes keep their data as JSON.
+	vendor := event
+	vendor.DataContentType = "application/vnd.orders+json"
+	vendor.Data = json.RawMessage(`[1,2]`)
+	encoded, err = json.Marshal(vendor)
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+	if data := decodeObject(t, encoded)["data"]; string(data) != `[1,2]` {
+		t.Fatalf("data = %s, want [1,2]", data)
+	}
+}
+
+func TestCloudEventZeroTime(t *testing.T) {
+	event := messaging.CloudEvent{
+		SpecVersion: messaging.CloudEventsSpecVersion,
+		ID:          "1",
+		Source:      "orders-service",
+		Type:        "order.created",
+	}
+	encoded, err := json.Marshal(event)
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+	object := decodeObject(t, encoded)
+	for _, name := range []string{"time", "data", "data_base64", "subject"} {
+		if _, found := object[name]; found {
+			t.Fatalf("unset attribute %s written: %s", name, encoded)
+		}
+	}
+
+	var decoded messaging.CloudEvent
+	if err := json.Unmarshal(encoded, &decoded); err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+	if !decoded.Time.IsZero() || decoded.Extensions != nil {
+		t.Fatalf("Unmarshal = %+v, want a zero time and no extensions", decoded)
+	}
+	assertSameEvent(t, decoded, event)
+}
@@ -3,8 +3,11 @@ package messaging
 import "errors"
 
 func NewMessageBroker(nameBroker string) (MessageBroker, error) {
-	if nameBroker == "kafka" {
+	switch nameBroker {
+	case "kafka":
 		return NewKafkaProvider()
+	case "memory":
+		return NewMemoryBroker(), nil
 	}
 	return nil, errors.New("message provider not supported")
 }
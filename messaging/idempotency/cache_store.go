@@ -0,0 +1,242 @@
+package idempotency
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/mauriciomartinezc/real-estate-mc-common/cache"
+)
+
+const defaultCachePrefix = "idempotency:"
+
+// CacheStore keeps processed keys in a cache. A claim is a key holding the
+// owner token, so completing and releasing it is a compare-and-delete:
+// Lua scripts on Redis, atomic across replicas, and a mutex on an
+// InMemoryCache, which lives in this process.
+type CacheStore struct {
+	backend claimBackend
+	prefix  string
+}
+
+// claimBackend keeps the claims and results of a CacheStore. Every method
+// is atomic with respect to the others.
+type claimBackend interface {
+	// claim returns the stored result if resultKey exists, or else sets
+	// claimKey to owner for lease unless it is held.
+	claim(ctx context.Context, claimKey, resultKey, owner string, lease time.Duration) (Claim, error)
+	// complete stores result under resultKey for ttl and deletes claimKey
+	// if owner still holds it, and reports whether it did.
+	complete(ctx context.Context, claimKey, resultKey, owner string, result []byte, ttl time.Duration) (bool, error)
+	// release deletes claimKey if owner still holds it.
+	release(ctx context.Context, claimKey, owner string) (bool, error)
+}
+
+// NewCacheStore creates a store in c under prefix, "idempotency:" if
+// empty. c must be a RedisCache, a TieredCache, whose claims and results
+// go to Redis only, or an InMemoryCache; Instrumented caches are
+// unwrapped. Anything else, including Switchable whose backend may
+// change, returns cache.ErrNotSupported.
+func NewCacheStore(c cache.Cache, prefix string) (*CacheStore, error) {
+	backend, err := newClaimBackend(c)
+	if err != nil {
+		return nil, err
+	}
+	if prefix == "" {
+		prefix = defaultCachePrefix
+	}
+	return &CacheStore{backend: backend, prefix: prefix}, nil
+}
+
+func newClaimBackend(c cache.Cache) (claimBackend, error) {
+	switch c := c.(type) {
+	case *cache.RedisCache:
+		return &redisClaimBackend{client: c.Client()}, nil
+	case *cache.TieredCache:
+		return &redisClaimBackend{client: c.L2().Client()}, nil
+	case *cache.InMemoryCache:
+		return &memoryClaimBackend{cache: c}, nil
+	case *cache.Instrumented:
+		return newClaimBackend(c.Unwrap())
+	}
+	return nil, fmt.Errorf("idempotency store needs a Redis or in-memory cache: %w", cache.ErrNotSupported)
+}
+
+// Claim sets the claim key of key to a new owner token unless key is
+// processed or claimed, see Store.
+func (s *CacheStore) Claim(ctx context.Context, key string, lease time.Duration) (Claim, error) {
+	claimKey, resultKey := s.keys(key)
+	return s.backend.claim(ctx, claimKey, resultKey, newOwner(), lease)
+}
+
+// Complete stores result for ttl and drops the claim, see Store.
+func (s *CacheStore) Complete(ctx context.Context, key, owner string, result []byte, ttl time.Duration) error {
+	claimKey, resultKey := s.keys(key)
+	held, err := s.backend.complete(ctx, claimKey, resultKey, owner, result, ttl)
+	if err != nil {
+		return err
+	}
+	if !held {
+		return fmt.Errorf("%w: %s", ErrClaimLost, key)
+	}
+	return nil
+}
+
+// Release drops the claim on key, see Store.
+func (s *CacheStore) Release(ctx context.Context, key, owner string) error {
+	claimKey, _ := s.keys(key)
+	held, err := s.backend.release(ctx, claimKey, owner)
+	if err != nil {
+		return err
+	}
+	if !held {
+		return fmt.Errorf("%w: %s", ErrClaimLost, key)
+	}
+	return nil
+}
+
+// keys returns the claim and result keys of key, hash tagged to the same
+// Redis Cluster slot so the scripts can use both.
+func (s *CacheStore) keys(key string) (string, string) {
+	tagged := "{" + s.prefix + key + "}"
+	return tagged + ":claim", tagged + ":done"
+}
+
+// claimScript returns {2, result} for a processed key, {1} if it set the
+// claim and {0} if the key is claimed.
+var claimScript = redis.NewScript(`
+local result = redis.call("GET", KEYS[2])
+if result then
+	return {2, result}
+end
+if redis.call("SET", KEYS[1], ARGV[1], "NX", "PX", ARGV[2]) then
+	return {1}
+end
+return {0}
+`)
+
+// completeScript stores the result, without expiration for a zero TTL,
+// and deletes the claim, only if the caller still owns it.
+var completeScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) ~= ARGV[1] then
+	return 0
+end
+if tonumber(ARGV[3]) > 0 then
+	redis.call("SET", KEYS[2], ARGV[2], "PX", ARGV[3])
+else
+	redis.call("SET", KEYS[2], ARGV[2])
+end
+return redis.call("DEL", KEYS[1])
+`)
+
+// releaseScript deletes the claim only if the caller still owns it.
+var releaseScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0
+`)
+
+type redisClaimBackend struct {
+	client redis.UniversalClient
+}
+
+func (b *redisClaimBackend) claim(ctx context.Context, claimKey, resultKey, owner string, lease time.Duration) (Claim, error) {
+	reply, err := claimScript.Run(ctx, b.client, []string{claimKey, resultKey}, owner, leaseMillis(lease)).Slice()
+	if err != nil {
+		return Claim{State: InProgress}, err
+	}
+	switch state, _ := reply[0].(int64); state {
+	case 2:
+		result, _ := reply[1].(string)
+		return Claim{State: Processed, Result: []byte(result)}, nil
+	case 1:
+		return Claim{State: Claimed, Owner: owner}, nil
+	}
+	return Claim{State: InProgress}, nil
+}
+
+func (b *redisClaimBackend) complete(ctx context.Context, claimKey, resultKey, owner string, result []byte, ttl time.Duration) (bool, error) {
+	n, err := completeScript.Run(ctx, b.client, []string{claimKey, resultKey}, owner, result, leaseMillis(ttl)).Int64()
+	return n == 1, err
+}
+
+func (b *redisClaimBackend) release(ctx context.Context, claimKey, owner string) (bool, error) {
+	n, err := releaseScript.Run(ctx, b.client, []string{claimKey}, owner).Int64()
+	return n == 1, err
+}
+
+// leaseMillis rounds d up to whole milliseconds for PX, as the Redis
+// locker does.
+func leaseMillis(d time.Duration) int64 {
+	return int64((d + time.Millisecond - 1) / time.Millisecond)
+}
+
+// memoryClaimsMu serializes the claims on every InMemoryCache, making each
+// check and write atomic; the cache is only shared within this process.
+var memoryClaimsMu sync.Mutex
+
+type memoryClaimBackend struct {
+	cache *cache.InMemoryCache
+}
+
+type cachedResult struct {
+	Result []byte `json:"result"`
+}
+
+func (b *memoryClaimBackend) claim(ctx context.Context, claimKey, resultKey, owner string, lease time.Duration) (Claim, error) {
+	memoryClaimsMu.Lock()
+	defer memoryClaimsMu.Unlock()
+
+	var stored cachedResult
+	err := b.cache.GetContext(ctx, resultKey, &stored)
+	if err == nil {
+		return Claim{State: Processed, Result: stored.Result}, nil
+	}
+	if !errors.Is(err, cache.ErrNotFound) {
+		return Claim{State: InProgress}, err
+	}
+	if _, held, err := b.owner(ctx, claimKey); err != nil || held {
+		return Claim{State: InProgress}, err
+	}
+	if err := b.cache.SetContext(ctx, claimKey, owner, lease); err != nil {
+		return Claim{State: InProgress}, err
+	}
+	return Claim{State: Claimed, Owner: owner}, nil
+}
+
+func (b *memoryClaimBackend) complete(ctx context.Context, claimKey, resultKey, owner string, result []byte, ttl time.Duration) (bool, error) {
+	memoryClaimsMu.Lock()
+	defer memoryClaimsMu.Unlock()
+
+	if current, held, err := b.owner(ctx, claimKey); err != nil || !held || current != owner {
+		return false, err
+	}
+	if err := b.cache.SetContext(ctx, resultKey, cachedResult{Result: result}, ttl); err != nil {
+		return false, err
+	}
+	return true, b.cache.DeleteContext(ctx, claimKey)
+}
+
+func (b *memoryClaimBackend) release(ctx context.Context, claimKey, owner string) (bool, error) {
+	memoryClaimsMu.Lock()
+	defer memoryClaimsMu.Unlock()
+
+	if current, held, err := b.owner(ctx, claimKey); err != nil || !held || current != owner {
+		return false, err
+	}
+	return true, b.cache.DeleteContext(ctx, claimKey)
+}
+
+// owner returns the holder of claimKey and whether it is held.
+func (b *memoryClaimBackend) owner(ctx context.Context, claimKey string) (string, bool, error) {
+	var owner string
+	err := b.cache.GetContext(ctx, claimKey, &owner)
+	if errors.Is(err, cache.ErrNotFound) {
+		return "", false, nil
+	}
+	return owner, err == nil, err
+}
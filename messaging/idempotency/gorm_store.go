@@ -0,0 +1,163 @@
+package idempotency
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const processedTableName = "processed_messages"
+
+const (
+	statusProcessing = "processing"
+	statusDone       = "done"
+)
+
+// ProcessedMessage is a row of the processed messages table.
+type ProcessedMessage struct {
+	Key    string `gorm:"column:message_key;primaryKey;size:255"`
+	Status string `gorm:"size:16;not null"`
+	// Owner is the token of the current claim.
+	Owner string `gorm:"size:64;not null;default:''"`
+	// LeaseUntil is when the claim of a row being processed expires.
+	LeaseUntil time.Time `gorm:"not null"`
+	Result     []byte
+	// ExpiresAt is when a processed row may be deleted; nil keeps it.
+	ExpiresAt *time.Time `gorm:"index"`
+	CreatedAt time.Time  `gorm:"not null"`
+	UpdatedAt time.Time  `gorm:"not null"`
+}
+
+func (ProcessedMessage) TableName() string {
+	return processedTableName
+}
+
+// AutoMigrate creates or updates the processed messages table.
+func AutoMigrate(db *gorm.DB) error {
+	return db.AutoMigrate(&ProcessedMessage{})
+}
+
+// GormStore keeps processed keys in a database table, so they survive
+// cache evictions and restarts. Claims rely on the primary key: of two
+// concurrent inserts only one succeeds.
+type GormStore struct {
+	db *gorm.DB
+}
+
+// NewGormStore creates a store on the processed messages table; see
+// AutoMigrate.
+func NewGormStore(db *gorm.DB) *GormStore {
+	return &GormStore{db: db}
+}
+
+// Claim inserts a row for key, or takes over one whose lease or
+// retention expired, under a new owner token, see Store.
+func (s *GormStore) Claim(ctx context.Context, key string, lease time.Duration) (Claim, error) {
+	db := s.db.WithContext(ctx)
+	now := time.Now().UTC()
+	owner := newOwner()
+
+	row := ProcessedMessage{Key: key, Status: statusProcessing, Owner: owner, LeaseUntil: now.Add(lease)}
+	result := db.Clauses(clause.OnConflict{DoNothing: true}).Create(&row)
+	if result.Error != nil {
+		return Claim{State: InProgress}, fmt.Errorf("error claiming %s: %w", key, result.Error)
+	}
+	if result.RowsAffected == 1 {
+		return Claim{State: Claimed, Owner: owner}, nil
+	}
+
+	var existing ProcessedMessage
+	err := db.Where("message_key = ?", key).Take(&existing).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		// Released since the insert; the retry will claim it.
+		return Claim{State: InProgress}, nil
+	}
+	if err != nil {
+		return Claim{State: InProgress}, fmt.Errorf("error reading claim of %s: %w", key, err)
+	}
+
+	expired := existing.Status == statusProcessing && existing.LeaseUntil.Before(now)
+	if existing.Status == statusDone {
+		if existing.ExpiresAt == nil || existing.ExpiresAt.After(now) {
+			return Claim{State: Processed, Result: existing.Result}, nil
+		}
+		expired = true
+	}
+	if !expired {
+		return Claim{State: InProgress}, nil
+	}
+
+	// Only one of the callers that saw the expired row wins the update.
+	result = db.Model(&ProcessedMessage{}).
+		Where("message_key = ? AND status = ? AND owner = ? AND updated_at = ?", key, existing.Status, existing.Owner, existing.UpdatedAt).
+		Updates(map[string]interface{}{
+			"status":      statusProcessing,
+			"owner":       owner,
+			"lease_until": now.Add(lease),
+			"result":      nil,
+			"expires_at":  nil,
+			"updated_at":  now,
+		})
+	if result.Error != nil {
+		return Claim{State: InProgress}, fmt.Errorf("error claiming %s: %w", key, result.Error)
+	}
+	if result.RowsAffected == 1 {
+		return Claim{State: Claimed, Owner: owner}, nil
+	}
+	return Claim{State: InProgress}, nil
+}
+
+// Complete marks key as processed if owner still holds its claim, see
+// Store.
+func (s *GormStore) Complete(ctx context.Context, key, owner string, result []byte, ttl time.Duration) error {
+	now := time.Now().UTC()
+	updates := map[string]interface{}{
+		"status":     statusDone,
+		"result":     result,
+		"expires_at": nil,
+		"updated_at": now,
+	}
+	if ttl > 0 {
+		updates["expires_at"] = now.Add(ttl)
+	}
+	completed := s.db.WithContext(ctx).Model(&ProcessedMessage{}).
+		Where("message_key = ? AND status = ? AND owner = ?", key, statusProcessing, owner).
+		Updates(updates)
+	if completed.Error != nil {
+		return fmt.Errorf("error completing %s: %w", key, completed.Error)
+	}
+	if completed.RowsAffected == 0 {
+		return fmt.Errorf("%w: %s", ErrClaimLost, key)
+	}
+	return nil
+}
+
+// Release deletes the claim on key if owner still holds it, see Store.
+func (s *GormStore) Release(ctx context.Context, key, owner string) error {
+	released := s.db.WithContext(ctx).
+		Where("message_key = ? AND status = ? AND owner = ?", key, statusProcessing, owner).
+		Delete(&ProcessedMessage{})
+	if released.Error != nil {
+		return fmt.Errorf("error releasing %s: %w", key, released.Error)
+	}
+	if released.RowsAffected == 0 {
+		return fmt.Errorf("%w: %s", ErrClaimLost, key)
+	}
+	return nil
+}
+
+// Cleanup deletes processed rows past their retention and returns how many
+// were deleted.
+func (s *GormStore) Cleanup(ctx context.Context) (int64, error) {
+	result := s.db.WithContext(ctx).
+		Where("status = ? AND expires_at < ?", statusDone, time.Now().UTC()).
+		Delete(&ProcessedMessage{})
+	if result.Error != nil {
+		return 0, fmt.Errorf("error cleaning up processed messages: %w", result.Error)
+	}
+	return result.RowsAffected, nil
+}
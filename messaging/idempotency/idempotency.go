@@ -0,0 +1,202 @@
+// Package idempotency deduplicates message handling. Delivery is at least
+// once, so a handler may see the same message twice, e.g. after a
+// rebalance or a failed commit; a Guard runs it only once per message ID.
+package idempotency
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/mauriciomartinezc/real-estate-mc-common/logger"
+	"github.com/mauriciomartinezc/real-estate-mc-common/messaging"
+)
+
+// ErrInProgress is returned while another consumer holds the claim of the
+// same key. It is wrapped with messaging.RetryLater, so the message is
+// retried without using up the attempts that move it to a retry or
+// dead-letter topic.
+var ErrInProgress = errors.New("message is being processed by another consumer")
+
+// ErrClaimLost is returned by Complete and Release when the claim expired
+// and was taken over by another consumer, or was already dropped.
+var ErrClaimLost = errors.New("idempotency claim lost")
+
+// ClaimState is the outcome of Store.Claim.
+type ClaimState int
+
+const (
+	// Claimed means the caller must process the key and then call Complete
+	// or Release.
+	Claimed ClaimState = iota
+	// InProgress means another caller holds an unexpired claim.
+	InProgress
+	// Processed means the key was already processed.
+	Processed
+)
+
+// Claim is the outcome of Store.Claim.
+type Claim struct {
+	State ClaimState
+	// Owner is the token of a Claimed key, to pass to Complete or Release.
+	Owner string
+	// Result is the stored result of a Processed key.
+	Result []byte
+}
+
+// Store records which keys were processed. Claim must be atomic: of any
+// number of concurrent calls for a key, at most one returns Claimed.
+// Complete and Release only act while owner holds the claim, so a consumer
+// whose lease expired cannot complete or drop the claim of the consumer
+// that took over; they return ErrClaimLost instead.
+type Store interface {
+	// Claim marks key as being processed for up to lease under a new
+	// owner token, unless it is already processed, in which case the
+	// stored result is returned.
+	Claim(ctx context.Context, key string, lease time.Duration) (Claim, error)
+	// Complete marks key as processed for ttl, storing result.
+	Complete(ctx context.Context, key, owner string, result []byte, ttl time.Duration) error
+	// Release drops the claim on key so it can be processed again.
+	Release(ctx context.Context, key, owner string) error
+}
+
+func newOwner() string {
+	return uuid.NewString()
+}
+
+const (
+	defaultTTL             = 24 * time.Hour
+	defaultLease           = 5 * time.Minute
+	defaultInProgressDelay = time.Second
+)
+
+// Config configures a Guard.
+type Config struct {
+	Store Store
+	// Scope namespaces the keys, typically with the consumer group, so
+	// that every group processes a message once.
+	Scope string
+	// Key returns the deduplication key of a message. Defaults to the
+	// message ID; messages with an empty key are handled without
+	// deduplication.
+	Key func(msg messaging.Message) string
+	// TTL is how long processed keys are remembered; redeliveries after it
+	// are processed again. Defaults to 24h.
+	TTL time.Duration
+	// Lease is how long a claim holds off duplicates while the handler
+	// runs; a handler that outlives it may run twice. Defaults to 5m.
+	Lease time.Duration
+	// InProgressDelay is how long a duplicate of a message in progress
+	// waits before its claim is checked again, until the other consumer
+	// finishes or its lease ends. Defaults to 1s.
+	InProgressDelay time.Duration
+}
+
+// Guard runs handlers at most once per key, as far as its Store remembers.
+type Guard struct {
+	config Config
+}
+
+// New creates a Guard.
+func New(config Config) (*Guard, error) {
+	if config.Store == nil {
+		return nil, fmt.Errorf("an idempotency store is required")
+	}
+	if config.Key == nil {
+		config.Key = func(msg messaging.Message) string {
+			return msg.ID
+		}
+	}
+	if config.TTL <= 0 {
+		config.TTL = defaultTTL
+	}
+	if config.Lease <= 0 {
+		config.Lease = defaultLease
+	}
+	if config.InProgressDelay <= 0 {
+		config.InProgressDelay = defaultInProgressDelay
+	}
+	return &Guard{config: config}, nil
+}
+
+// Handler wraps handler so duplicates of a processed message are
+// acknowledged without calling it. A failed handler releases its claim, so
+// the retry is processed.
+func (g *Guard) Handler(handler messaging.Handler) messaging.Handler {
+	return func(ctx context.Context, msg messaging.Message) error {
+		key := g.config.Key(msg)
+		if key == "" {
+			return handler(ctx, msg)
+		}
+		_, _, err := g.run(ctx, key, func(ctx context.Context) ([]byte, error) {
+			return nil, handler(ctx, msg)
+		})
+		return err
+	}
+}
+
+// Do calls fn once per key and memoizes its result as JSON: later calls
+// with the same key return the stored result without calling fn. Use it
+// inside handlers whose outcome is needed again on redelivery, such as the
+// ID of a created entity.
+func Do[T any](ctx context.Context, g *Guard, key string, fn func(ctx context.Context) (T, error)) (T, error) {
+	var result T
+	stored, processed, err := g.run(ctx, key, func(ctx context.Context) ([]byte, error) {
+		var err error
+		if result, err = fn(ctx); err != nil {
+			return nil, err
+		}
+		encoded, err := json.Marshal(result)
+		if err != nil {
+			return nil, fmt.Errorf("error encoding result of %s: %w", key, err)
+		}
+		return encoded, nil
+	})
+	if err != nil || !processed {
+		return result, err
+	}
+	if len(stored) > 0 {
+		if err := json.Unmarshal(stored, &result); err != nil {
+			return result, fmt.Errorf("error decoding stored result of %s: %w", key, err)
+		}
+	}
+	return result, nil
+}
+
+// run claims key and calls fn unless it was processed. It returns the
+// stored result and true for processed keys.
+func (g *Guard) run(ctx context.Context, key string, fn func(ctx context.Context) ([]byte, error)) ([]byte, bool, error) {
+	if g.config.Scope != "" {
+		key = g.config.Scope + ":" + key
+	}
+	claim, err := g.config.Store.Claim(ctx, key, g.config.Lease)
+	if err != nil {
+		return nil, false, fmt.Errorf("error claiming %s: %w", key, err)
+	}
+	switch claim.State {
+	case Processed:
+		return claim.Result, true, nil
+	case InProgress:
+		return nil, false, messaging.RetryLater(fmt.Errorf("%w: %s", ErrInProgress, key), g.config.InProgressDelay)
+	}
+
+	// The outcome must be recorded even if the handler's context is done.
+	storeCtx := context.WithoutCancel(ctx)
+	result, err := fn(ctx)
+	if err != nil {
+		if releaseErr := g.config.Store.Release(storeCtx, key, claim.Owner); releaseErr != nil {
+			logger.Warn().Err(releaseErr).Str("key", key).Msg("Failed to release idempotency claim")
+		}
+		return nil, false, err
+	}
+	// Failing here would process the message again, the duplicate this
+	// package exists to avoid, so the error is only logged; the claim
+	// still holds off duplicates until the lease ends.
+	if err := g.config.Store.Complete(storeCtx, key, claim.Owner, result, g.config.TTL); err != nil {
+		logger.Error().Err(err).Str("key", key).Msg("Failed to mark message as processed")
+	}
+	return nil, false, nil
+}
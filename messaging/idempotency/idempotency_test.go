@@ -0,0 +1,334 @@
+package idempotency_test
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/glebarez/sqlite"
+	"github.com/go-redis/redis/v8"
+	"github.com/mauriciomartinezc/real-estate-mc-common/cache"
+	"github.com/mauriciomartinezc/real-estate-mc-common/messaging"
+	"github.com/mauriciomartinezc/real-estate-mc-common/messaging/idempotency"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func newGormStore(t *testing.T) (*idempotency.GormStore, *gorm.DB) {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "idempotency.db")), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		t.Fatalf("gorm.Open: %v", err)
+	}
+	if err := idempotency.AutoMigrate(db); err != nil {
+		t.Fatalf("AutoMigrate: %v", err)
+	}
+	return idempotency.NewGormStore(db), db
+}
+
+func newRedisStore(t *testing.T) idempotency.Store {
+	t.Helper()
+	server := miniredis.RunT(t)
+	// Expire keys in real time, as the lease tests sleep past them.
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(5 * time.Millisecond)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				server.FastForward(5 * time.Millisecond)
+			case <-done:
+				return
+			}
+		}
+	}()
+	t.Cleanup(func() { close(done) })
+
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { client.Close() })
+	store, err := idempotency.NewCacheStore(cache.NewRedisCacheFromClient(client), "")
+	if err != nil {
+		t.Fatalf("NewCacheStore: %v", err)
+	}
+	return store
+}
+
+func newMemoryStore(t *testing.T) idempotency.Store {
+	t.Helper()
+	store, err := idempotency.NewCacheStore(cache.NewInMemoryCache(), "")
+	if err != nil {
+		t.Fatalf("NewCacheStore: %v", err)
+	}
+	return store
+}
+
+// stores runs fn against every Store implementation.
+func stores(t *testing.T, fn func(t *testing.T, store idempotency.Store)) {
+	t.Run("Gorm", func(t *testing.T) {
+		store, _ := newGormStore(t)
+		fn(t, store)
+	})
+	t.Run("Redis", func(t *testing.T) { fn(t, newRedisStore(t)) })
+	t.Run("InMemory", func(t *testing.T) { fn(t, newMemoryStore(t)) })
+}
+
+func claim(t *testing.T, store idempotency.Store, key string, lease time.Duration) idempotency.Claim {
+	t.Helper()
+	c, err := store.Claim(context.Background(), key, lease)
+	if err != nil {
+		t.Fatalf("Claim: %v", err)
+	}
+	return c
+}
+
+func TestStoreClaimCompleteRelease(t *testing.T) {
+	stores(t, func(t *testing.T, store idempotency.Store) {
+		ctx := context.Background()
+
+		first := claim(t, store, "m1", time.Minute)
+		if first.State != idempotency.Claimed || first.Owner == "" {
+			t.Fatalf("first Claim = %+v, want Claimed with an owner", first)
+		}
+		if c := claim(t, store, "m1", time.Minute); c.State != idempotency.InProgress {
+			t.Fatalf("second Claim = %+v, want InProgress", c)
+		}
+		if err := store.Complete(ctx, "m1", first.Owner, []byte(`"done"`), time.Hour); err != nil {
+			t.Fatalf("Complete: %v", err)
+		}
+		if c := claim(t, store, "m1", time.Minute); c.State != idempotency.Processed || string(c.Result) != `"done"` {
+			t.Fatalf("Claim after Complete = %+v, want Processed with the result", c)
+		}
+
+		released := claim(t, store, "m2", time.Minute)
+		if err := store.Release(ctx, "m2", released.Owner); err != nil {
+			t.Fatalf("Release: %v", err)
+		}
+		again := claim(t, store, "m2", time.Minute)
+		if again.State != idempotency.Claimed || again.Owner == released.Owner {
+			t.Fatalf("Claim after Release = %+v, want Claimed by a new owner", again)
+		}
+	})
+}
+
+func TestStoreClaimIsExclusive(t *testing.T) {
+	stores(t, func(t *testing.T, store idempotency.Store) {
+		var claimed atomic.Int32
+		var wg sync.WaitGroup
+		for i := 0; i < 8; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				c, err := store.Claim(context.Background(), "m1", time.Minute)
+				if err != nil {
+					t.Errorf("Claim: %v", err)
+				}
+				if c.State == idempotency.Claimed {
+					claimed.Add(1)
+				}
+			}()
+		}
+		wg.Wait()
+		if n := claimed.Load(); n != 1 {
+			t.Fatalf("%d concurrent claims succeeded, want 1", n)
+		}
+	})
+}
+
+// A consumer whose lease expired must not complete or release the claim of
+// the consumer that took over.
+func TestStoreStaleOwnerAfterTakeover(t *testing.T) {
+	stores(t, func(t *testing.T, store idempotency.Store) {
+		ctx := context.Background()
+
+		stale := claim(t, store, "m1", 20*time.Millisecond)
+		time.Sleep(50 * time.Millisecond)
+		current := claim(t, store, "m1", time.Minute)
+		if current.State != idempotency.Claimed || current.Owner == stale.Owner {
+			t.Fatalf("Claim after the lease = %+v, want Claimed by a new owner", current)
+		}
+
+		if err := store.Release(ctx, "m1", stale.Owner); !errors.Is(err, idempotency.ErrClaimLost) {
+			t.Fatalf("stale Release error = %v, want ErrClaimLost", err)
+		}
+		if err := store.Complete(ctx, "m1", stale.Owner, []byte(`"stale"`), time.Hour); !errors.Is(err, idempotency.ErrClaimLost) {
+			t.Fatalf("stale Complete error = %v, want ErrClaimLost", err)
+		}
+		if c := claim(t, store, "m1", time.Minute); c.State != idempotency.InProgress {
+			t.Fatalf("Claim after the stale calls = %+v, want InProgress", c)
+		}
+
+		if err := store.Complete(ctx, "m1", current.Owner, []byte(`"current"`), time.Hour); err != nil {
+			t.Fatalf("Complete: %v", err)
+		}
+		if c := claim(t, store, "m1", time.Minute); c.State != idempotency.Processed || string(c.Result) != `"current"` {
+			t.Fatalf("Claim after Complete = %+v, want the current owner's result", c)
+		}
+	})
+}
+
+func TestGormStoreRetention(t *testing.T) {
+	store, db := newGormStore(t)
+	ctx := context.Background()
+
+	c := claim(t, store, "m1", time.Minute)
+	if err := store.Complete(ctx, "m1", c.Owner, nil, time.Hour); err != nil {
+		t.Fatalf("Complete: %v", err)
+	}
+	past := time.Now().UTC().Add(-time.Minute)
+	db.Model(&idempotency.ProcessedMessage{}).Where("message_key = ?", "m1").Update("expires_at", past)
+
+	// An expired row is claimed again, and deleted by Cleanup.
+	if c := claim(t, store, "m1", time.Minute); c.State != idempotency.Claimed {
+		t.Fatalf("Claim past retention = %+v, want Claimed", c)
+	}
+	c = claim(t, store, "m2", time.Minute)
+	if err := store.Complete(ctx, "m2", c.Owner, nil, time.Hour); err != nil {
+		t.Fatalf("Complete: %v", err)
+	}
+	db.Model(&idempotency.ProcessedMessage{}).Where("message_key = ?", "m2").Update("expires_at", past)
+	if deleted, err := store.Cleanup(ctx); err != nil || deleted != 1 {
+		t.Fatalf("Cleanup = %d, %v, want 1 deleted", deleted, err)
+	}
+}
+
+func TestNewCacheStore(t *testing.T) {
+	instrumented := cache.NewInstrumented(cache.NewInMemoryCache(), cache.InstrumentedConfig{})
+	if _, err := idempotency.NewCacheStore(instrumented, ""); err != nil {
+		t.Fatalf("NewCacheStore of an instrumented cache: %v", err)
+	}
+	switchable := cache.NewSwitchable(cache.NewInMemoryCache())
+	if _, err := idempotency.NewCacheStore(switchable, ""); !errors.Is(err, cache.ErrNotSupported) {
+		t.Fatalf("NewCacheStore of a switchable cache error = %v, want ErrNotSupported", err)
+	}
+}
+
+func TestGuardHandler(t *testing.T) {
+	stores(t, func(t *testing.T, store idempotency.Store) {
+		guard, err := idempotency.New(idempotency.Config{Store: store, Scope: "svc"})
+		if err != nil {
+			t.Fatalf("New: %v", err)
+		}
+		ctx := context.Background()
+		calls := 0
+		fail := true
+		handler := guard.Handler(func(ctx context.Context, msg messaging.Message) error {
+			calls++
+			if fail {
+				fail = false
+				return errors.New("handler failed")
+			}
+			return nil
+		})
+
+		msg := messaging.NewMessage("orders", "o1", nil)
+		if err := handler(ctx, msg); err == nil {
+			t.Fatal("handler error not returned")
+		}
+		// The failure released the claim, so the retry runs the handler.
+		for i := 0; i < 3; i++ {
+			if err := handler(ctx, msg); err != nil {
+				t.Fatalf("handler: %v", err)
+			}
+		}
+		if calls != 2 {
+			t.Fatalf("handler called %d times, want 2", calls)
+		}
+	})
+}
+
+func TestDo(t *testing.T) {
+	stores(t, func(t *testing.T, store idempotency.Store) {
+		guard, err := idempotency.New(idempotency.Config{Store: store})
+		if err != nil {
+			t.Fatalf("New: %v", err)
+		}
+		ctx := context.Background()
+		next := 0
+		create := func(ctx context.Context) (int, error) {
+			next++
+			return next, nil
+		}
+		for i := 0; i < 2; i++ {
+			id, err := idempotency.Do(ctx, guard, "create-o1", create)
+			if err != nil || id != 1 {
+				t.Fatalf("Do = %d, %v, want the stored 1", id, err)
+			}
+		}
+	})
+}
+
+// A duplicate of a message another consumer is processing waits for it
+// instead of using up its attempts and reaching the dead-letter topic.
+func TestGuardHandlerWaitsForInProgressDuplicate(t *testing.T) {
+	store := newMemoryStore(t)
+	guard, err := idempotency.New(idempotency.Config{Store: store, Scope: "svc", InProgressDelay: 10 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	broker := messaging.NewMemoryBroker(messaging.WithMemoryPartitions(1))
+	defer broker.Close()
+	ctx := context.Background()
+
+	msg := messaging.NewMessage("orders", "o1", nil)
+	other := claim(t, store, "svc:"+msg.ID, time.Minute)
+
+	var calls atomic.Int32
+	err = broker.Subscribe(ctx, "orders", "svc", guard.Handler(func(ctx context.Context, msg messaging.Message) error {
+		calls.Add(1)
+		return nil
+	}),
+		messaging.WithHandlerRetry(messaging.RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond}),
+		messaging.WithRetryTopics(time.Millisecond),
+		messaging.WithDeadLetterTopic(""),
+	)
+	if err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+	if err := broker.PublishMessage(ctx, msg); err != nil {
+		t.Fatalf("PublishMessage: %v", err)
+	}
+
+	time.Sleep(200 * time.Millisecond)
+	if n := len(broker.Messages("orders.svc.retry.1")) + len(broker.Messages("orders.svc.dlt")); n != 0 {
+		t.Fatalf("%d messages routed while the duplicate was in progress, want none", n)
+	}
+	if n := calls.Load(); n != 0 {
+		t.Fatalf("handler called %d times while the duplicate was in progress", n)
+	}
+
+	// The other consumer gives up, so this one processes the message.
+	if err := store.Release(ctx, "svc:"+msg.ID, other.Owner); err != nil {
+		t.Fatalf("Release: %v", err)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for calls.Load() == 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if n := calls.Load(); n != 1 {
+		t.Fatalf("handler called %d times after the release, want 1", n)
+	}
+}
+
+func TestGuardInProgressError(t *testing.T) {
+	store := newMemoryStore(t)
+	guard, err := idempotency.New(idempotency.Config{Store: store})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	claim(t, store, "create-o1", time.Minute)
+	_, err = idempotency.Do(context.Background(), guard, "create-o1", func(ctx context.Context) (int, error) {
+		return 1, nil
+	})
+	var retryLater *messaging.RetryLaterError
+	if !errors.Is(err, idempotency.ErrInProgress) || !errors.As(err, &retryLater) || retryLater.After != time.Second {
+		t.Fatalf("Do of a claimed key error = %v, want ErrInProgress retried after 1s", err)
+	}
+}
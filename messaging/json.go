@@ -0,0 +1,53 @@
+package messaging
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// PublishJSON encodes payload as JSON and publishes it with the
+// application/json content type.
+func PublishJSON[T any](ctx context.Context, broker MessageBroker, topic, key string, payload T, opts ...MessageOption) error {
+	value, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("error encoding message for topic %s: %w", topic, err)
+	}
+	msg := NewMessage(topic, key, value, opts...)
+	msg.ContentType = ContentTypeJSON
+	return broker.PublishMessage(ctx, msg)
+}
+
+// JSONHandler adapts a handler of T payloads to a Handler, decoding the
+// message value, or the data of a structured CloudEvent, as JSON. Payloads
+// that cannot be decoded fail permanently instead of being retried.
+func JSONHandler[T any](handler func(ctx context.Context, msg Message, payload T) error) Handler {
+	return func(ctx context.Context, msg Message) error {
+		data := msg.Value
+		switch {
+		case strings.HasPrefix(msg.ContentType, ContentTypeCloudEventsJSON):
+			event, err := CloudEventFromMessage(msg)
+			if err != nil {
+				return Permanent(err)
+			}
+			data = event.Data
+		case msg.ContentType != "" && !isJSONContentType(msg.ContentType):
+			return Permanent(fmt.Errorf("unsupported content type %q, expected JSON", msg.ContentType))
+		}
+
+		var payload T
+		if err := json.Unmarshal(data, &payload); err != nil {
+			return Permanent(fmt.Errorf("error decoding message %s from topic %s: %w", msg.ID, msg.Topic, err))
+		}
+		return handler(ctx, msg, payload)
+	}
+}
+
+// isJSONContentType accepts application/json and application/*+json, with
+// optional parameters such as charset.
+func isJSONContentType(contentType string) bool {
+	mediaType, _, _ := strings.Cut(contentType, ";")
+	mediaType = strings.TrimSpace(strings.ToLower(mediaType))
+	return mediaType == ContentTypeJSON || (strings.HasPrefix(mediaType, "application/") && strings.HasSuffix(mediaType, "+json"))
+}
@@ -0,0 +1,189 @@
+package messaging
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// defaultAdminTimeout bounds admin requests whose context has no deadline.
+const defaultAdminTimeout = 30 * time.Second
+
+// EnsureTopics creates the missing topics of specs through the controller
+// and reports the drift of the existing ones, see TopicAdmin.
+func (k *KafkaProvider) EnsureTopics(ctx context.Context, specs ...TopicSpec) ([]TopicDrift, error) {
+	if err := validateTopicSpecs(specs); err != nil {
+		return nil, err
+	}
+	names := make([]string, len(specs))
+	for i, spec := range specs {
+		names[i] = spec.Name
+	}
+	existing, err := k.describeTopics(ctx, names)
+	if err != nil {
+		return nil, err
+	}
+
+	var missing []kafka.TopicConfig
+	var drift []TopicDrift
+	for _, spec := range specs {
+		if info, found := existing[spec.Name]; found {
+			drift = append(drift, topicDrift(spec, info)...)
+			continue
+		}
+		config := kafka.TopicConfig{
+			Topic:             spec.Name,
+			NumPartitions:     spec.Partitions,
+			ReplicationFactor: spec.ReplicationFactor,
+		}
+		for name, value := range spec.configs() {
+			config.ConfigEntries = append(config.ConfigEntries, kafka.ConfigEntry{ConfigName: name, ConfigValue: value})
+		}
+		missing = append(missing, config)
+	}
+	if len(missing) > 0 {
+		if err := k.createTopics(ctx, missing); err != nil {
+			return drift, err
+		}
+	}
+	logTopicDrift(drift)
+	return drift, nil
+}
+
+// DescribeTopics reads the partitions and configs of the named topics, see
+// TopicAdmin.
+func (k *KafkaProvider) DescribeTopics(ctx context.Context, names ...string) ([]TopicInfo, error) {
+	found, err := k.describeTopics(ctx, names)
+	if err != nil {
+		return nil, err
+	}
+	infos := make([]TopicInfo, len(names))
+	for i, name := range names {
+		info, ok := found[name]
+		if !ok {
+			return nil, fmt.Errorf("%w: %s", ErrTopicNotFound, name)
+		}
+		infos[i] = info
+	}
+	return infos, nil
+}
+
+// describeTopics returns the named topics that exist. The metadata of
+// every topic is read, because asking for a missing topic by name creates
+// it on brokers with auto.create.topics.enable.
+func (k *KafkaProvider) describeTopics(ctx context.Context, names []string) (map[string]TopicInfo, error) {
+	conn, err := k.adminConn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	partitions, err := conn.ReadPartitions()
+	conn.Close()
+	if err != nil {
+		return nil, fmt.Errorf("error reading topic metadata: %w", err)
+	}
+
+	wanted := make(map[string]bool, len(names))
+	for _, name := range names {
+		wanted[name] = true
+	}
+	found := make(map[string]TopicInfo)
+	for _, partition := range partitions {
+		if !wanted[partition.Topic] {
+			continue
+		}
+		info := found[partition.Topic]
+		info.Name = partition.Topic
+		info.Partitions++
+		if len(partition.Replicas) > info.ReplicationFactor {
+			info.ReplicationFactor = len(partition.Replicas)
+		}
+		found[partition.Topic] = info
+	}
+	if len(found) == 0 {
+		return found, nil
+	}
+
+	request := &kafka.DescribeConfigsRequest{}
+	for name := range found {
+		request.Resources = append(request.Resources, kafka.DescribeConfigRequestResource{
+			ResourceType: kafka.ResourceTypeTopic,
+			ResourceName: name,
+		})
+	}
+	ctx, cancel := context.WithDeadline(ctx, adminDeadline(ctx))
+	defer cancel()
+	response, err := k.admin.DescribeConfigs(ctx, request)
+	if err != nil {
+		return nil, fmt.Errorf("error describing topic configs: %w", err)
+	}
+	for _, resource := range response.Resources {
+		if resource.Error != nil {
+			return nil, fmt.Errorf("error describing configs of topic %s: %w", resource.ResourceName, resource.Error)
+		}
+		info := found[resource.ResourceName]
+		info.Configs = make(map[string]string, len(resource.ConfigEntries))
+		for _, entry := range resource.ConfigEntries {
+			info.Configs[entry.ConfigName] = entry.ConfigValue
+		}
+		found[resource.ResourceName] = info
+	}
+	return found, nil
+}
+
+// createTopics sends topics to the controller, which is the only broker
+// that accepts them. Topics created meanwhile by someone else are not an
+// error.
+func (k *KafkaProvider) createTopics(ctx context.Context, topics []kafka.TopicConfig) error {
+	conn, err := k.adminConn(ctx)
+	if err != nil {
+		return err
+	}
+	controller, err := conn.Controller()
+	conn.Close()
+	if err != nil {
+		return fmt.Errorf("error finding the Kafka controller: %w", err)
+	}
+
+	address := net.JoinHostPort(controller.Host, strconv.Itoa(controller.Port))
+	conn, err = k.dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return fmt.Errorf("error dialing the Kafka controller %s: %w", address, err)
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(adminDeadline(ctx))
+	if err := conn.CreateTopics(topics...); err != nil {
+		return fmt.Errorf("error creating topics: %w", err)
+	}
+	return nil
+}
+
+// adminConn dials the first broker that answers, with a deadline from ctx.
+func (k *KafkaProvider) adminConn(ctx context.Context) (*kafka.Conn, error) {
+	k.mu.Lock()
+	closed := k.closed
+	k.mu.Unlock()
+	if closed {
+		return nil, ErrBrokerClosed
+	}
+
+	var err error
+	for _, broker := range k.brokers {
+		var conn *kafka.Conn
+		if conn, err = k.dialer.DialContext(ctx, "tcp", broker); err == nil {
+			_ = conn.SetDeadline(adminDeadline(ctx))
+			return conn, nil
+		}
+	}
+	return nil, fmt.Errorf("error dialing Kafka: %w", err)
+}
+
+func adminDeadline(ctx context.Context) time.Time {
+	if deadline, ok := ctx.Deadline(); ok {
+		return deadline
+	}
+	return time.Now().Add(defaultAdminTimeout)
+}
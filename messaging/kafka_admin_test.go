@@ -0,0 +1,365 @@
+package messaging
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net"
+	"sort"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/protocol"
+	"github.com/segmentio/kafka-go/protocol/apiversions"
+	"github.com/segmentio/kafka-go/protocol/createtopics"
+	"github.com/segmentio/kafka-go/protocol/describeconfigs"
+	"github.com/segmentio/kafka-go/protocol/metadata"
+)
+
+// fakeKafka is a single broker answering the admin requests of
+// KafkaProvider from an in-memory set of topics.
+type fakeKafka struct {
+	listener net.Listener
+	host     string
+	port     int32
+
+	mu      sync.Mutex
+	topics  map[string]TopicInfo
+	created []string
+	// createErrors answers a CreateTopics of the topic with this error
+	// code, leaving the topic as it is.
+	createErrors map[string]kafka.Error
+}
+
+func newFakeKafka(t *testing.T) *fakeKafka {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	addr := listener.Addr().(*net.TCPAddr)
+	f := &fakeKafka{
+		listener:     listener,
+		host:         addr.IP.String(),
+		port:         int32(addr.Port),
+		topics:       make(map[string]TopicInfo),
+		createErrors: make(map[string]kafka.Error),
+	}
+	t.Cleanup(func() { listener.Close() })
+	go f.serve()
+	return f
+}
+
+func (f *fakeKafka) addr() string {
+	return net.JoinHostPort(f.host, strconv.Itoa(int(f.port)))
+}
+
+func (f *fakeKafka) serve() {
+	for {
+		conn, err := f.listener.Accept()
+		if err != nil {
+			return
+		}
+		go f.handle(conn)
+	}
+}
+
+func (f *fakeKafka) handle(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	for {
+		version, correlationID, _, request, err := protocol.ReadRequest(r)
+		if err != nil {
+			return
+		}
+		if err := protocol.WriteResponse(conn, version, correlationID, f.respond(request)); err != nil {
+			return
+		}
+	}
+}
+
+func (f *fakeKafka) respond(request protocol.Message) protocol.Message {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	switch request := request.(type) {
+	case *apiversions.Request:
+		// The lowest versions that kafka-go's Conn and Client both speak.
+		return &apiversions.Response{ApiKeys: []apiversions.ApiKeyResponse{
+			{ApiKey: int16(protocol.ApiVersions), MinVersion: 0, MaxVersion: 0},
+			{ApiKey: int16(protocol.Metadata), MinVersion: 0, MaxVersion: 1},
+			{ApiKey: int16(protocol.CreateTopics), MinVersion: 0, MaxVersion: 0},
+			{ApiKey: int16(protocol.DescribeConfigs), MinVersion: 0, MaxVersion: 0},
+		}}
+
+	case *metadata.Request:
+		response := &metadata.Response{
+			Brokers:      []metadata.ResponseBroker{{NodeID: 1, Host: f.host, Port: f.port}},
+			ControllerID: 1,
+		}
+		names := make([]string, 0, len(f.topics))
+		for name := range f.topics {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			info := f.topics[name]
+			replicas := make([]int32, info.ReplicationFactor)
+			for i := range replicas {
+				replicas[i] = int32(i + 1)
+			}
+			topic := metadata.ResponseTopic{Name: name}
+			for p := 0; p < info.Partitions; p++ {
+				topic.Partitions = append(topic.Partitions, metadata.ResponsePartition{
+					PartitionIndex: int32(p),
+					LeaderID:       1,
+					ReplicaNodes:   replicas,
+					IsrNodes:       replicas,
+				})
+			}
+			response.Topics = append(response.Topics, topic)
+		}
+		return response
+
+	case *describeconfigs.Request:
+		response := &describeconfigs.Response{}
+		for _, resource := range request.Resources {
+			result := describeconfigs.ResponseResource{ResourceType: resource.ResourceType, ResourceName: resource.ResourceName}
+			info, found := f.topics[resource.ResourceName]
+			if !found {
+				result.ErrorCode = int16(kafka.UnknownTopicOrPartition)
+			}
+			for name, value := range info.Configs {
+				result.ConfigEntries = append(result.ConfigEntries, describeconfigs.ResponseConfigEntry{ConfigName: name, ConfigValue: value})
+			}
+			response.Resources = append(response.Resources, result)
+		}
+		return response
+
+	case *createtopics.Request:
+		response := &createtopics.Response{}
+		for _, topic := range request.Topics {
+			result := createtopics.ResponseTopic{Name: topic.Name}
+			if code, found := f.createErrors[topic.Name]; found {
+				result.ErrorCode = int16(code)
+			} else if _, exists := f.topics[topic.Name]; exists {
+				result.ErrorCode = int16(kafka.TopicAlreadyExists)
+			} else {
+				info := TopicInfo{
+					Name:              topic.Name,
+					Partitions:        int(topic.NumPartitions),
+					ReplicationFactor: int(topic.ReplicationFactor),
+					Configs:           make(map[string]string),
+				}
+				for _, config := range topic.Configs {
+					info.Configs[config.Name] = config.Value
+				}
+				f.topics[topic.Name] = info
+				f.created = append(f.created, topic.Name)
+			}
+			response.Topics = append(response.Topics, result)
+		}
+		return response
+	}
+	panic("unexpected request " + request.ApiKey().String())
+}
+
+func (f *fakeKafka) setTopic(info TopicInfo) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.topics[info.Name] = info
+}
+
+func (f *fakeKafka) createdTopics() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.created...)
+}
+
+func newFakeKafkaProvider(t *testing.T, f *fakeKafka) *KafkaProvider {
+	t.Helper()
+	provider, err := NewKafkaProviderWithConfig(KafkaConfig{Brokers: []string{f.addr()}})
+	if err != nil {
+		t.Fatalf("NewKafkaProviderWithConfig: %v", err)
+	}
+	t.Cleanup(func() { provider.Close() })
+	return provider
+}
+
+func TestTopicSpecConfigs(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		spec TopicSpec
+		want map[string]string
+	}{
+		{"defaults", TopicSpec{}, map[string]string{}},
+		{"retention", TopicSpec{Retention: 36 * time.Hour}, map[string]string{TopicConfigRetentionMs: "129600000"}},
+		{"infinite retention", TopicSpec{Retention: -1}, map[string]string{TopicConfigRetentionMs: "-1"}},
+		{"compacted", TopicSpec{Compacted: true}, map[string]string{TopicConfigCleanupPolicy: "compact"}},
+		{"configs take precedence", TopicSpec{
+			Retention: time.Second,
+			Compacted: true,
+			Configs:   map[string]string{TopicConfigRetentionMs: "5", TopicConfigCleanupPolicy: "compact,delete", "min.insync.replicas": "2"},
+		}, map[string]string{TopicConfigRetentionMs: "5", TopicConfigCleanupPolicy: "compact,delete", "min.insync.replicas": "2"}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.spec.configs()
+			if len(got) != len(tt.want) {
+				t.Fatalf("configs = %v, want %v", got, tt.want)
+			}
+			for name, value := range tt.want {
+				if got[name] != value {
+					t.Fatalf("configs = %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestValidateTopicSpecs(t *testing.T) {
+	valid := TopicSpec{Name: "orders", Partitions: 3, ReplicationFactor: 1}
+	if err := validateTopicSpecs([]TopicSpec{valid, {Name: "payments", Partitions: 1, ReplicationFactor: 3}}); err != nil {
+		t.Fatalf("validateTopicSpecs: %v", err)
+	}
+	if err := validateTopicSpecs(nil); err != nil {
+		t.Fatalf("validateTopicSpecs of no specs: %v", err)
+	}
+	for name, specs := range map[string][]TopicSpec{
+		"no name":               {{Partitions: 1, ReplicationFactor: 1}},
+		"no partitions":         {{Name: "orders", ReplicationFactor: 1}},
+		"negative partitions":   {{Name: "orders", Partitions: -1, ReplicationFactor: 1}},
+		"no replication factor": {{Name: "orders", Partitions: 1}},
+		"declared twice":        {valid, valid},
+		"invalid after a valid": {valid, {Name: "payments"}},
+	} {
+		if err := validateTopicSpecs(specs); err == nil {
+			t.Fatalf("validateTopicSpecs accepted %s", name)
+		}
+	}
+}
+
+func TestTopicDrift(t *testing.T) {
+	spec := TopicSpec{
+		Name:              "orders",
+		Partitions:        6,
+		ReplicationFactor: 3,
+		Retention:         -1,
+		Configs:           map[string]string{"min.insync.replicas": "2"},
+	}
+	matching := TopicInfo{
+		Name:              "orders",
+		Partitions:        6,
+		ReplicationFactor: 3,
+		// Configs that are not declared are not drift.
+		Configs: map[string]string{TopicConfigRetentionMs: "-1", "min.insync.replicas": "2", "segment.ms": "1000"},
+	}
+	if drift := topicDrift(spec, matching); len(drift) != 0 {
+		t.Fatalf("topicDrift = %v, want none", drift)
+	}
+
+	drift := topicDrift(spec, TopicInfo{
+		Name:              "orders",
+		Partitions:        3,
+		ReplicationFactor: 1,
+		Configs:           map[string]string{TopicConfigRetentionMs: "604800000"},
+	})
+	want := []TopicDrift{
+		{Topic: "orders", Setting: TopicSettingPartitions, Declared: "6", Actual: "3"},
+		{Topic: "orders", Setting: TopicSettingReplicationFactor, Declared: "3", Actual: "1"},
+		{Topic: "orders", Setting: "min.insync.replicas", Declared: "2", Actual: ""},
+		{Topic: "orders", Setting: TopicConfigRetentionMs, Declared: "-1", Actual: "604800000"},
+	}
+	if len(drift) != len(want) {
+		t.Fatalf("topicDrift = %v, want %v", drift, want)
+	}
+	for i := range want {
+		if drift[i] != want[i] {
+			t.Fatalf("topicDrift = %v, want %v", drift, want)
+		}
+	}
+	if s := drift[0].String(); s != "topic orders: partitions is 3, declared 6" {
+		t.Fatalf("String = %q", s)
+	}
+}
+
+func TestKafkaEnsureTopics(t *testing.T) {
+	f := newFakeKafka(t)
+	f.setTopic(TopicInfo{Name: "orders", Partitions: 3, ReplicationFactor: 1, Configs: map[string]string{TopicConfigCleanupPolicy: "delete"}})
+	provider := newFakeKafkaProvider(t, f)
+	ctx := context.Background()
+
+	drift, err := provider.EnsureTopics(ctx,
+		TopicSpec{Name: "orders", Partitions: 3, ReplicationFactor: 1, Compacted: true},
+		TopicSpec{Name: "payments", Partitions: 2, ReplicationFactor: 1, Retention: -1},
+	)
+	if err != nil {
+		t.Fatalf("EnsureTopics: %v", err)
+	}
+	want := TopicDrift{Topic: "orders", Setting: TopicConfigCleanupPolicy, Declared: "compact", Actual: "delete"}
+	if len(drift) != 1 || drift[0] != want {
+		t.Fatalf("drift = %v, want %v", drift, want)
+	}
+	if created := f.createdTopics(); len(created) != 1 || created[0] != "payments" {
+		t.Fatalf("created %v, want only payments", created)
+	}
+
+	infos, err := provider.DescribeTopics(ctx, "payments")
+	if err != nil {
+		t.Fatalf("DescribeTopics: %v", err)
+	}
+	if info := infos[0]; info.Partitions != 2 || info.ReplicationFactor != 1 || info.Configs[TopicConfigRetentionMs] != "-1" {
+		t.Fatalf("DescribeTopics = %+v, want the declared topic", info)
+	}
+	if _, err := provider.DescribeTopics(ctx, "payments", "missing"); !errors.Is(err, ErrTopicNotFound) {
+		t.Fatalf("DescribeTopics of a missing topic error = %v, want ErrTopicNotFound", err)
+	}
+
+	if _, err := provider.EnsureTopics(ctx, TopicSpec{Name: "invalid"}); err == nil {
+		t.Fatal("EnsureTopics accepted an invalid spec")
+	}
+	if created := f.createdTopics(); len(created) != 1 {
+		t.Fatalf("created %v after an invalid spec", created)
+	}
+}
+
+func TestKafkaEnsureTopicsCreatedConcurrently(t *testing.T) {
+	f := newFakeKafka(t)
+	provider := newFakeKafkaProvider(t, f)
+	ctx := context.Background()
+
+	// Another replica creates the topic between the describe and the create.
+	f.createErrors["orders"] = kafka.TopicAlreadyExists
+	drift, err := provider.EnsureTopics(ctx, TopicSpec{Name: "orders", Partitions: 3, ReplicationFactor: 1})
+	if err != nil || len(drift) != 0 {
+		t.Fatalf("EnsureTopics = %v, %v, want success", drift, err)
+	}
+
+	f.createErrors["payments"] = kafka.InvalidReplicationFactor
+	if _, err := provider.EnsureTopics(ctx, TopicSpec{Name: "payments", Partitions: 3, ReplicationFactor: 5}); !errors.Is(err, kafka.InvalidReplicationFactor) {
+		t.Fatalf("EnsureTopics error = %v, want InvalidReplicationFactor", err)
+	}
+}
+
+func TestMemoryBrokerEnsureTopics(t *testing.T) {
+	broker := NewMemoryBroker(WithMemoryPartitions(2))
+	defer broker.Close()
+	ctx := context.Background()
+
+	if _, err := broker.EnsureTopics(ctx, TopicSpec{Name: "orders"}); err == nil {
+		t.Fatal("EnsureTopics accepted an invalid spec")
+	}
+	if _, err := broker.DescribeTopics(ctx, "orders"); !errors.Is(err, ErrTopicNotFound) {
+		t.Fatalf("DescribeTopics error = %v, want ErrTopicNotFound", err)
+	}
+	drift, err := broker.EnsureTopics(ctx, TopicSpec{Name: "orders", Partitions: 6, ReplicationFactor: 3})
+	if err != nil || len(drift) != 0 {
+		t.Fatalf("EnsureTopics = %v, %v, want no drift", drift, err)
+	}
+	infos, err := broker.DescribeTopics(ctx, "orders")
+	if err != nil || infos[0].Partitions != 2 {
+		t.Fatalf("DescribeTopics = %+v, %v, want the broker's 2 partitions", infos, err)
+	}
+}
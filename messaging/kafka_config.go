@@ -0,0 +1,382 @@
+package messaging
+
+import (
+	"crypto/tls"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mauriciomartinezc/real-estate-mc-common/security"
+	"github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/sasl"
+	"github.com/segmentio/kafka-go/sasl/plain"
+	"github.com/segmentio/kafka-go/sasl/scram"
+)
+
+// SASL mechanisms supported by KafkaConfig.
+const (
+	SASLPlain       = "plain"
+	SASLScramSHA256 = "scram-sha-256"
+	SASLScramSHA512 = "scram-sha-512"
+)
+
+// Partition balancers supported by KafkaConfig. All but BalancerRoundRobin
+// and BalancerLeastBytes send messages with the same key to the same
+// partition; they differ in the hash, which matters when other producers
+// write the same topic: murmur2 matches the Java client and crc32
+// librdkafka.
+const (
+	BalancerHash       = "hash"
+	BalancerMurmur2    = "murmur2"
+	BalancerCRC32      = "crc32"
+	BalancerRoundRobin = "round_robin"
+	BalancerLeastBytes = "least_bytes"
+)
+
+const (
+	defaultKafkaBatchSize    = 100
+	defaultKafkaBatchBytes   = 1 << 20
+	defaultKafkaBatchTimeout = 10 * time.Millisecond
+	defaultKafkaTimeout      = 10 * time.Second
+	defaultKafkaMaxAttempts  = 10
+)
+
+// KafkaConfig describes how KafkaProvider connects to and writes to Kafka.
+// The zero value of every field but Brokers selects a safe default.
+//
+// Not supported: an idempotent producer. kafka-go writes every record
+// batch without a producer ID or sequence numbers, so the broker cannot
+// drop a batch written again by a retry after a timed out write it had
+// stored, and the topic holds a duplicate. KafkaConfigFromEnv rejects
+// KAFKA_ENABLE_IDEMPOTENCE=true rather than ignore it. Duplicates are
+// handled on the consumer side instead:
+//
+//   - every message published through KafkaProvider carries a unique
+//     Message.ID, set when empty and sent in the HeaderID header, which
+//     retries of the same message keep;
+//   - handlers wrapped with idempotency.Guard process each ID only once.
+type KafkaConfig struct {
+	Brokers  []string
+	ClientID string
+
+	// SASLMechanism is SASLPlain, SASLScramSHA256 or SASLScramSHA512; empty
+	// disables SASL.
+	SASLMechanism string
+	SASLUsername  string
+	SASLPassword  string
+	// TLS enables TLS when non-nil, see security.NewTLSConfig.
+	TLS *tls.Config
+
+	// Acks is "all", "one" or "none". Defaults to "all".
+	Acks string
+	// Compression is "none", "gzip", "snappy", "lz4" or "zstd". Defaults to
+	// "none".
+	Compression string
+	// Balancer picks the partition of each message, see the Balancer
+	// constants. Defaults to BalancerHash.
+	Balancer string
+
+	// BatchSize and BatchBytes limit a batch per partition. Default to 100
+	// messages and 1MB.
+	BatchSize  int
+	BatchBytes int64
+	// BatchTimeout is how long a partial batch waits for more messages
+	// (linger). Defaults to 10ms.
+	BatchTimeout time.Duration
+
+	// DialTimeout, ReadTimeout and WriteTimeout default to 10s.
+	DialTimeout  time.Duration
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+	// MaxAttempts is how many times the writer tries a batch before
+	// PublishMessage sees the error. Defaults to 10. It only applies with
+	// a publish retry policy of a single attempt, see WithPublishRetry;
+	// otherwise the writer tries once and the policy retries.
+	MaxAttempts int
+}
+
+// KafkaConfigFromEnv reads a KafkaConfig from the environment:
+//
+//   - KAFKA_BROKERS: comma separated host:port list, required
+//   - KAFKA_CLIENT_ID
+//   - KAFKA_SASL_MECHANISM, KAFKA_SASL_USERNAME, KAFKA_SASL_PASSWORD
+//   - KAFKA_TLS_ENABLED, KAFKA_TLS_CA_FILE, KAFKA_TLS_CERT_FILE,
+//     KAFKA_TLS_KEY_FILE, KAFKA_TLS_SERVER_NAME, KAFKA_TLS_INSECURE_SKIP_VERIFY
+//   - KAFKA_ACKS, KAFKA_COMPRESSION, KAFKA_BALANCER
+//   - KAFKA_BATCH_SIZE, KAFKA_BATCH_BYTES, KAFKA_BATCH_TIMEOUT
+//   - KAFKA_DIAL_TIMEOUT, KAFKA_READ_TIMEOUT, KAFKA_WRITE_TIMEOUT: Go
+//     durations such as "10s"
+//   - KAFKA_MAX_ATTEMPTS
+//
+// KAFKA_ENABLE_IDEMPOTENCE may only be false, as there is no idempotent
+// producer, see KafkaConfig.
+func KafkaConfigFromEnv() (KafkaConfig, error) {
+	config := KafkaConfig{
+		ClientID:      kafkaEnv("KAFKA_CLIENT_ID"),
+		SASLMechanism: kafkaEnv("KAFKA_SASL_MECHANISM"),
+		SASLUsername:  kafkaEnv("KAFKA_SASL_USERNAME"),
+		SASLPassword:  os.Getenv("KAFKA_SASL_PASSWORD"),
+		Acks:          kafkaEnv("KAFKA_ACKS"),
+		Compression:   kafkaEnv("KAFKA_COMPRESSION"),
+		Balancer:      kafkaEnv("KAFKA_BALANCER"),
+	}
+	for _, broker := range strings.Split(kafkaEnv("KAFKA_BROKERS"), ",") {
+		if broker = strings.TrimSpace(broker); broker != "" {
+			config.Brokers = append(config.Brokers, broker)
+		}
+	}
+	if len(config.Brokers) == 0 {
+		return config, fmt.Errorf("KAFKA_BROKERS not set")
+	}
+
+	var err error
+	if config.BatchSize, err = kafkaEnvInt("KAFKA_BATCH_SIZE"); err != nil {
+		return config, err
+	}
+	batchBytes, err := kafkaEnvInt("KAFKA_BATCH_BYTES")
+	if err != nil {
+		return config, err
+	}
+	config.BatchBytes = int64(batchBytes)
+	if config.BatchTimeout, err = kafkaEnvDuration("KAFKA_BATCH_TIMEOUT"); err != nil {
+		return config, err
+	}
+	if config.DialTimeout, err = kafkaEnvDuration("KAFKA_DIAL_TIMEOUT"); err != nil {
+		return config, err
+	}
+	if config.ReadTimeout, err = kafkaEnvDuration("KAFKA_READ_TIMEOUT"); err != nil {
+		return config, err
+	}
+	if config.WriteTimeout, err = kafkaEnvDuration("KAFKA_WRITE_TIMEOUT"); err != nil {
+		return config, err
+	}
+	if config.MaxAttempts, err = kafkaEnvInt("KAFKA_MAX_ATTEMPTS"); err != nil {
+		return config, err
+	}
+	idempotent, err := kafkaEnvBool("KAFKA_ENABLE_IDEMPOTENCE")
+	if err != nil {
+		return config, err
+	}
+	if idempotent {
+		return config, fmt.Errorf("KAFKA_ENABLE_IDEMPOTENCE is not supported by kafka-go, deduplicate by message ID with idempotency.Guard instead")
+	}
+
+	tlsOptions := security.TLSOptions{
+		CAFile:     kafkaEnv("KAFKA_TLS_CA_FILE"),
+		CertFile:   kafkaEnv("KAFKA_TLS_CERT_FILE"),
+		KeyFile:    kafkaEnv("KAFKA_TLS_KEY_FILE"),
+		ServerName: kafkaEnv("KAFKA_TLS_SERVER_NAME"),
+	}
+	if tlsOptions.Enabled, err = kafkaEnvBool("KAFKA_TLS_ENABLED"); err != nil {
+		return config, err
+	}
+	if tlsOptions.InsecureSkipVerify, err = kafkaEnvBool("KAFKA_TLS_INSECURE_SKIP_VERIFY"); err != nil {
+		return config, err
+	}
+	if config.TLS, err = security.NewTLSConfig(tlsOptions); err != nil {
+		return config, fmt.Errorf("error configuring Kafka TLS: %w", err)
+	}
+
+	return config, nil
+}
+
+// saslMechanism builds the configured SASL mechanism, nil when SASL is
+// disabled.
+func (c KafkaConfig) saslMechanism() (sasl.Mechanism, error) {
+	switch strings.ToLower(c.SASLMechanism) {
+	case "":
+		return nil, nil
+	case SASLPlain:
+		return plain.Mechanism{Username: c.SASLUsername, Password: c.SASLPassword}, nil
+	case SASLScramSHA256:
+		return scram.Mechanism(scram.SHA256, c.SASLUsername, c.SASLPassword)
+	case SASLScramSHA512:
+		return scram.Mechanism(scram.SHA512, c.SASLUsername, c.SASLPassword)
+	default:
+		return nil, fmt.Errorf("unsupported Kafka SASL mechanism %q", c.SASLMechanism)
+	}
+}
+
+func (c KafkaConfig) requiredAcks() (kafka.RequiredAcks, error) {
+	switch strings.ToLower(c.Acks) {
+	case "", "all", "-1":
+		return kafka.RequireAll, nil
+	case "one", "1":
+		return kafka.RequireOne, nil
+	case "none", "0":
+		return kafka.RequireNone, nil
+	default:
+		return 0, fmt.Errorf("unsupported Kafka acks %q", c.Acks)
+	}
+}
+
+func (c KafkaConfig) compression() (kafka.Compression, error) {
+	switch strings.ToLower(c.Compression) {
+	case "", "none":
+		return 0, nil
+	case "gzip":
+		return kafka.Gzip, nil
+	case "snappy":
+		return kafka.Snappy, nil
+	case "lz4":
+		return kafka.Lz4, nil
+	case "zstd":
+		return kafka.Zstd, nil
+	default:
+		return 0, fmt.Errorf("unsupported Kafka compression %q", c.Compression)
+	}
+}
+
+func (c KafkaConfig) balancer() (kafka.Balancer, error) {
+	switch strings.ToLower(c.Balancer) {
+	case "", BalancerHash:
+		return &kafka.Hash{}, nil
+	case BalancerMurmur2:
+		return kafka.Murmur2Balancer{}, nil
+	case BalancerCRC32:
+		return kafka.CRC32Balancer{}, nil
+	case BalancerRoundRobin:
+		return &kafka.RoundRobin{}, nil
+	case BalancerLeastBytes:
+		return &kafka.LeastBytes{}, nil
+	default:
+		return nil, fmt.Errorf("unsupported Kafka balancer %q", c.Balancer)
+	}
+}
+
+// withDefaults fills the zero fields with their defaults.
+func (c KafkaConfig) withDefaults() KafkaConfig {
+	if c.BatchSize <= 0 {
+		c.BatchSize = defaultKafkaBatchSize
+	}
+	if c.BatchBytes <= 0 {
+		c.BatchBytes = defaultKafkaBatchBytes
+	}
+	if c.BatchTimeout <= 0 {
+		c.BatchTimeout = defaultKafkaBatchTimeout
+	}
+	if c.DialTimeout <= 0 {
+		c.DialTimeout = defaultKafkaTimeout
+	}
+	if c.ReadTimeout <= 0 {
+		c.ReadTimeout = defaultKafkaTimeout
+	}
+	if c.WriteTimeout <= 0 {
+		c.WriteTimeout = defaultKafkaTimeout
+	}
+	if c.MaxAttempts <= 0 {
+		c.MaxAttempts = defaultKafkaMaxAttempts
+	}
+	return c
+}
+
+// kafkaClients holds what KafkaProvider builds from a KafkaConfig.
+type kafkaClients struct {
+	writer *kafka.Writer
+	dialer *kafka.Dialer
+	admin  *kafka.Client
+}
+
+// newKafkaClients validates config and builds the writer, the dialer of
+// the readers and the admin client, which shares the writer's transport.
+func newKafkaClients(config KafkaConfig) (kafkaClients, error) {
+	if len(config.Brokers) == 0 {
+		return kafkaClients{}, fmt.Errorf("at least one Kafka broker is required")
+	}
+	config = config.withDefaults()
+
+	mechanism, err := config.saslMechanism()
+	if err != nil {
+		return kafkaClients{}, err
+	}
+	acks, err := config.requiredAcks()
+	if err != nil {
+		return kafkaClients{}, err
+	}
+	compression, err := config.compression()
+	if err != nil {
+		return kafkaClients{}, err
+	}
+	balancer, err := config.balancer()
+	if err != nil {
+		return kafkaClients{}, err
+	}
+
+	writer := &kafka.Writer{
+		Addr:         kafka.TCP(config.Brokers...),
+		Balancer:     balancer,
+		MaxAttempts:  config.MaxAttempts,
+		BatchSize:    config.BatchSize,
+		BatchBytes:   config.BatchBytes,
+		BatchTimeout: config.BatchTimeout,
+		ReadTimeout:  config.ReadTimeout,
+		WriteTimeout: config.WriteTimeout,
+		RequiredAcks: acks,
+		Compression:  compression,
+		Transport: &kafka.Transport{
+			DialTimeout: config.DialTimeout,
+			ClientID:    config.ClientID,
+			TLS:         config.TLS,
+			SASL:        mechanism,
+		},
+	}
+	dialer := &kafka.Dialer{
+		Timeout:       config.DialTimeout,
+		DualStack:     true,
+		ClientID:      config.ClientID,
+		TLS:           config.TLS,
+		SASLMechanism: mechanism,
+	}
+	admin := &kafka.Client{
+		Addr:      writer.Addr,
+		Timeout:   config.ReadTimeout,
+		Transport: writer.Transport,
+	}
+	return kafkaClients{writer: writer, dialer: dialer, admin: admin}, nil
+}
+
+// kafkaEnv reads a trimmed environment variable.
+func kafkaEnv(name string) string {
+	return strings.TrimSpace(os.Getenv(name))
+}
+
+// kafkaEnvBool parses an optional boolean environment variable.
+func kafkaEnvBool(name string) (bool, error) {
+	value := kafkaEnv(name)
+	if value == "" {
+		return false, nil
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("the environment variable %s must be a boolean: %w", name, err)
+	}
+	return b, nil
+}
+
+// kafkaEnvInt parses an optional integer environment variable.
+func kafkaEnvInt(name string) (int, error) {
+	value := kafkaEnv(name)
+	if value == "" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("the environment variable %s must be an integer: %w", name, err)
+	}
+	return n, nil
+}
+
+// kafkaEnvDuration parses an optional Go duration environment variable.
+func kafkaEnvDuration(name string) (time.Duration, error) {
+	value := kafkaEnv(name)
+	if value == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("the environment variable %s must be a duration: %w", name, err)
+	}
+	return d, nil
+}
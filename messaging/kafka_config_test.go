@@ -0,0 +1,64 @@
+package messaging
+
+import (
+	"strings"
+	"testing"
+)
+
+// Consumers deduplicate by Message.ID, since kafka-go has no idempotent
+// producer, so every written message must carry one.
+func TestKafkaMessagesCarryUniqueIDs(t *testing.T) {
+	seen := make(map[string]bool)
+	for i := 0; i < 100; i++ {
+		id := fromKafkaMessage(toKafkaMessage(prepareMessage(Message{Topic: "orders", Key: "o1"}))).ID
+		if id == "" {
+			t.Fatal("written message has no ID header")
+		}
+		if seen[id] {
+			t.Fatalf("ID %s assigned twice", id)
+		}
+		seen[id] = true
+	}
+
+	read := fromKafkaMessage(toKafkaMessage(prepareMessage(Message{Topic: "orders", ID: "order-1"})))
+	if id := read.ID; id != "order-1" {
+		t.Fatalf("ID header = %q, want the message's own ID order-1", id)
+	}
+}
+
+// Retries of the publish policy and of the writer must not multiply.
+func TestKafkaWriterAttemptsOnceUnderPublishRetry(t *testing.T) {
+	config := KafkaConfig{Brokers: []string{"localhost:9092"}, MaxAttempts: 5}
+	provider, err := NewKafkaProviderWithConfig(config)
+	if err != nil {
+		t.Fatalf("NewKafkaProviderWithConfig: %v", err)
+	}
+	defer provider.Close()
+	if provider.writer.MaxAttempts != 1 {
+		t.Fatalf("writer MaxAttempts = %d with the default policy, want 1", provider.writer.MaxAttempts)
+	}
+
+	provider, err = NewKafkaProviderWithConfig(config, WithPublishRetry(RetryPolicy{MaxAttempts: 1}))
+	if err != nil {
+		t.Fatalf("NewKafkaProviderWithConfig: %v", err)
+	}
+	defer provider.Close()
+	if provider.writer.MaxAttempts != 5 {
+		t.Fatalf("writer MaxAttempts = %d with a single attempt policy, want 5", provider.writer.MaxAttempts)
+	}
+}
+
+// Asking for an idempotent producer must fail rather than be ignored.
+func TestKafkaConfigFromEnvRejectsIdempotence(t *testing.T) {
+	t.Setenv("KAFKA_BROKERS", "localhost:9092")
+	for value, supported := range map[string]bool{"": true, "false": true, "true": false, "1": false} {
+		t.Setenv("KAFKA_ENABLE_IDEMPOTENCE", value)
+		_, err := KafkaConfigFromEnv()
+		if supported && err != nil {
+			t.Fatalf("KafkaConfigFromEnv with KAFKA_ENABLE_IDEMPOTENCE=%q: %v", value, err)
+		}
+		if !supported && (err == nil || !strings.Contains(err.Error(), "KAFKA_ENABLE_IDEMPOTENCE")) {
+			t.Fatalf("KafkaConfigFromEnv with KAFKA_ENABLE_IDEMPOTENCE=%q error = %v, want it rejected", value, err)
+		}
+	}
+}
@@ -2,30 +2,91 @@ package messaging
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"github.com/mauriciomartinezc/real-estate-mc-common/logger"
 	"github.com/segmentio/kafka-go"
-	"os"
-	"strings"
+	"io"
+	"sync"
 	"time"
 )
 
 type KafkaProvider struct {
-	writer *kafka.Writer
+	brokers      []string
+	writer       *kafka.Writer
+	dialer       *kafka.Dialer
+	admin        *kafka.Client
+	publishRetry RetryPolicy
+	asyncConfig  AsyncConfig
+	async        *asyncPublisher
+
+	mu        sync.Mutex
+	closed    bool
+	consumers []*kafkaConsumer
+}
+
+// KafkaOption configures a KafkaProvider.
+type KafkaOption func(*KafkaProvider)
+
+// WithPublishRetry sets how failed writes are retried. Defaults to
+// DefaultPublishRetryPolicy. Unless policy makes a single attempt, the
+// kafka-go writer is set to try each batch once, so the two do not
+// multiply and KafkaConfig.MaxAttempts is ignored.
+func WithPublishRetry(policy RetryPolicy) KafkaOption {
+	return func(k *KafkaProvider) {
+		k.publishRetry = policy
+	}
+}
+
+// WithAsyncConfig configures PublishAsync.
+func WithAsyncConfig(config AsyncConfig) KafkaOption {
+	return func(k *KafkaProvider) {
+		k.asyncConfig = config
+	}
+}
+
+// NewKafkaProvider creates a provider configured from the KAFKA_*
+// environment variables, see KafkaConfigFromEnv.
+func NewKafkaProvider(opts ...KafkaOption) (MessageBroker, error) {
+	config, err := KafkaConfigFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	provider, err := NewKafkaProviderWithConfig(config, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return provider, nil
 }
 
-func NewKafkaProvider() (MessageBroker, error) {
-	brokersStr := os.Getenv("KAFKA_BROKERS")
-	if len(brokersStr) == 0 {
-		return nil, fmt.Errorf("KAFKA_BROKERS not set")
+// NewKafkaProviderWithConfig creates a provider for the cluster described
+// by config. No connection is made until the first publish or subscribe.
+func NewKafkaProviderWithConfig(config KafkaConfig, opts ...KafkaOption) (*KafkaProvider, error) {
+	clients, err := newKafkaClients(config)
+	if err != nil {
+		return nil, fmt.Errorf("error configuring Kafka: %w", err)
 	}
-	brokers := strings.Split(brokersStr, ",")
-	writer := kafka.NewWriter(kafka.WriterConfig{
-		Brokers:      brokers,
-		Balancer:     &kafka.LeastBytes{},
-		WriteTimeout: 10 * time.Second,
-		ReadTimeout:  10 * time.Second,
+	provider := &KafkaProvider{
+		brokers:      config.Brokers,
+		writer:       clients.writer,
+		dialer:       clients.dialer,
+		admin:        clients.admin,
+		publishRetry: DefaultPublishRetryPolicy,
+	}
+	for _, opt := range opts {
+		opt(provider)
+	}
+	if provider.publishRetry.MaxAttempts != 1 {
+		provider.writer.MaxAttempts = 1
+	}
+	provider.async = newAsyncPublisher(provider.asyncConfig, func(ctx context.Context, msgs []Message) []error {
+		batch := make([]kafka.Message, len(msgs))
+		for i, msg := range msgs {
+			batch[i] = toKafkaMessage(msg)
+		}
+		return provider.write(ctx, batch)
 	})
-	return &KafkaProvider{writer: writer}, nil
+	return provider, nil
 }
 
 func (k *KafkaProvider) Init() error {
@@ -36,15 +97,334 @@ func (k *KafkaProvider) Init() error {
 }
 
 func (k *KafkaProvider) Publish(ctx context.Context, topic string, key string, message []byte) error {
-	msg := kafka.Message{
-		Topic: topic,
-		Key:   []byte(key),
-		Value: message,
-		Time:  time.Now(),
+	return k.PublishMessage(ctx, Message{Topic: topic, Key: key, Value: message})
+}
+
+// PublishMessage writes msg with its ID, content type and time as
+// CloudEvents headers, retrying per the publish retry policy, see
+// MessageBroker.
+func (k *KafkaProvider) PublishMessage(ctx context.Context, msg Message) error {
+	msg = prepareMessage(msg)
+	if err := k.write(ctx, []kafka.Message{toKafkaMessage(msg)})[0]; err != nil {
+		return fmt.Errorf("error publishing message %s to topic %s: %w", msg.ID, msg.Topic, err)
+	}
+	return nil
+}
+
+// PublishAsync queues msg for a background batch write, see
+// AsyncPublisher. Ordering against PublishMessage calls is not guaranteed.
+func (k *KafkaProvider) PublishAsync(ctx context.Context, msg Message) *PublishFuture {
+	return k.async.enqueue(ctx, msg)
+}
+
+// PublishBatch writes msgs in one call to the writer, retrying only the
+// messages that failed, see AsyncPublisher.
+func (k *KafkaProvider) PublishBatch(ctx context.Context, msgs []Message) error {
+	if len(msgs) == 0 {
+		return nil
+	}
+	batch := make([]kafka.Message, len(msgs))
+	for i, msg := range msgs {
+		batch[i] = toKafkaMessage(prepareMessage(msg))
+	}
+	return batchError(k.write(ctx, batch))
+}
+
+// Flush waits for the messages queued by PublishAsync, see AsyncPublisher.
+func (k *KafkaProvider) Flush(ctx context.Context) error {
+	return k.async.flush(ctx)
+}
+
+// write publishes msgs per the publish retry policy and returns the error
+// of each. Only the messages that failed are retried, so a retry can
+// reorder messages with the same key: the writer may split a partition's
+// messages over several requests (BatchSize, BatchBytes) of which only some
+// fail, and a concurrent write may land before the retried messages.
+func (k *KafkaProvider) write(ctx context.Context, msgs []kafka.Message) []error {
+	errs := make([]error, len(msgs))
+	pending := make([]int, len(msgs))
+	for i := range pending {
+		pending[i] = i
+	}
+	_ = k.publishRetry.Do(ctx, func(ctx context.Context) error {
+		batch := make([]kafka.Message, len(pending))
+		for j, i := range pending {
+			batch[j] = msgs[i]
+		}
+		err := k.writer.WriteMessages(ctx, batch...)
+
+		var writeErrors kafka.WriteErrors
+		switch {
+		case err == nil:
+			for _, i := range pending {
+				errs[i] = nil
+			}
+			pending = nil
+		case errors.As(err, &writeErrors) && len(writeErrors) == len(pending):
+			failed := pending[:0]
+			for j, i := range pending {
+				errs[i] = writeErrors[j]
+				if writeErrors[j] != nil {
+					failed = append(failed, i)
+				}
+			}
+			pending = failed
+		default:
+			for _, i := range pending {
+				errs[i] = err
+			}
+		}
+		return err
+	})
+	return errs
+}
+
+// Subscribe consumes topic with a kafka.Reader joined to the consumer group
+// group, see MessageBroker. Retry topics get a reader of their own in the
+// same group. Retry and dead-letter topics must exist unless the cluster
+// creates topics automatically.
+func (k *KafkaProvider) Subscribe(ctx context.Context, topic, group string, handler Handler, opts ...SubscribeOption) error {
+	if topic == "" || group == "" {
+		return fmt.Errorf("topic and consumer group are required")
+	}
+	options := newSubscribeOptions(opts)
+	router := newFailureRouter(topic, group, options, k.PublishMessage)
+	topics := []string{topic}
+	if router != nil {
+		topics = router.topics()
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if k.closed {
+		return ErrBrokerClosed
+	}
+	for _, topic := range topics {
+		k.subscribe(ctx, topic, group, handler, options, router)
+	}
+	return nil
+}
+
+// subscribe starts a consumer of a single topic. The caller must hold k.mu.
+func (k *KafkaProvider) subscribe(ctx context.Context, topic, group string, handler Handler, options subscribeOptions, router *failureRouter) {
+	reader := kafka.NewReader(kafka.ReaderConfig{
+		Brokers: k.brokers,
+		Dialer:  k.dialer,
+		GroupID: group,
+		Topic:   topic,
+		// Commit synchronously so an offset is only stored once handled.
+		CommitInterval: 0,
+	})
+	fetchCtx, cancel := context.WithCancel(ctx)
+	consumer := &kafkaConsumer{
+		reader: reader,
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+	consumer.dispatcher = newDispatcher(ctx, handler, options, router, consumer.commit)
+	k.consumers = append(k.consumers, consumer)
+
+	go consumer.run(fetchCtx)
+}
+
+// HealthCheck dials the brokers until one answers with the cluster
+// metadata, then checks that every subscribed topic exists and each of its
+// partitions has a leader.
+func (k *KafkaProvider) HealthCheck(ctx context.Context) error {
+	k.mu.Lock()
+	if k.closed {
+		k.mu.Unlock()
+		return ErrBrokerClosed
+	}
+	var topics []string
+	seen := make(map[string]bool)
+	for _, consumer := range k.consumers {
+		if topic := consumer.reader.Config().Topic; !seen[topic] {
+			seen[topic] = true
+			topics = append(topics, topic)
+		}
 	}
-	return k.writer.WriteMessages(ctx, msg)
+	k.mu.Unlock()
+
+	var err error
+	for _, broker := range k.brokers {
+		if err = k.checkBroker(ctx, broker, topics); err == nil {
+			return nil
+		}
+	}
+	return fmt.Errorf("no healthy Kafka broker: %w", err)
+}
+
+func (k *KafkaProvider) checkBroker(ctx context.Context, broker string, topics []string) error {
+	conn, err := k.dialer.DialContext(ctx, "tcp", broker)
+	if err != nil {
+		return fmt.Errorf("error dialing %s: %w", broker, err)
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+
+	if _, err := conn.Brokers(); err != nil {
+		return fmt.Errorf("error reading metadata from %s: %w", broker, err)
+	}
+	if len(topics) == 0 {
+		return nil
+	}
+	// Every topic is read and filtered here, since asking for a missing
+	// topic by name creates it on brokers with auto.create.topics.enable,
+	// see describeTopics.
+	partitions, err := conn.ReadPartitions()
+	if err != nil {
+		return fmt.Errorf("error reading partitions from %s: %w", broker, err)
+	}
+	wanted := make(map[string]bool, len(topics))
+	for _, topic := range topics {
+		wanted[topic] = true
+	}
+	found := make(map[string]bool, len(topics))
+	for _, partition := range partitions {
+		if !wanted[partition.Topic] {
+			continue
+		}
+		found[partition.Topic] = true
+		if partition.Leader.Host == "" {
+			return fmt.Errorf("partition %d of topic %s has no leader", partition.ID, partition.Topic)
+		}
+	}
+	for _, topic := range topics {
+		if !found[topic] {
+			return fmt.Errorf("topic %s does not exist", topic)
+		}
+	}
+	return nil
 }
 
 func (k *KafkaProvider) Close() error {
-	return k.writer.Close()
+	k.mu.Lock()
+	k.closed = true
+	consumers := k.consumers
+	k.consumers = nil
+	k.mu.Unlock()
+
+	for _, consumer := range consumers {
+		consumer.cancel()
+	}
+	for _, consumer := range consumers {
+		<-consumer.done
+	}
+	// Drain after the consumers, whose handlers may still publish.
+	drainErr := k.async.close()
+	if err := k.writer.Close(); err != nil {
+		return err
+	}
+	if drainErr != nil {
+		return fmt.Errorf("error draining queued messages: %w", drainErr)
+	}
+	return nil
+}
+
+// kafkaConsumer feeds the messages of one subscription to its dispatcher.
+type kafkaConsumer struct {
+	reader     *kafka.Reader
+	dispatcher *dispatcher
+	cancel     context.CancelFunc
+	done       chan struct{}
+}
+
+func (c *kafkaConsumer) run(ctx context.Context) {
+	defer close(c.done)
+
+	var fetchRetry RetryPolicy
+	attempt := 0
+	for {
+		m, err := c.reader.FetchMessage(ctx)
+		if err != nil {
+			if ctx.Err() != nil || errors.Is(err, io.EOF) {
+				break
+			}
+			attempt++
+			logger.Error().Err(err).Str("topic", c.reader.Config().Topic).Msg("Failed to fetch Kafka message")
+			select {
+			case <-time.After(fetchRetry.Backoff(attempt)):
+			case <-ctx.Done():
+			}
+			continue
+		}
+		attempt = 0
+
+		if err := c.dispatcher.dispatch(ctx, fromKafkaMessage(m)); err != nil {
+			break
+		}
+	}
+
+	c.dispatcher.close()
+	if err := c.reader.Close(); err != nil {
+		logger.Error().Err(err).Str("topic", c.reader.Config().Topic).Msg("Failed to close Kafka reader")
+	}
+}
+
+func (c *kafkaConsumer) commit(ctx context.Context, msg Message) error {
+	return c.reader.CommitMessages(ctx, kafka.Message{
+		Topic:     msg.Topic,
+		Partition: msg.Partition,
+		Offset:    msg.Offset,
+	})
+}
+
+func toKafkaMessage(msg Message) kafka.Message {
+	headers := make([]kafka.Header, 0, len(msg.Headers)+3)
+	for name, value := range msg.Headers {
+		switch name {
+		case HeaderID, HeaderTime, HeaderContentType:
+			// Taken from the message fields below.
+			continue
+		}
+		headers = append(headers, kafka.Header{Key: name, Value: []byte(value)})
+	}
+	headers = append(headers,
+		kafka.Header{Key: HeaderID, Value: []byte(msg.ID)},
+		kafka.Header{Key: HeaderTime, Value: []byte(msg.Time.UTC().Format(time.RFC3339Nano))},
+	)
+	if msg.ContentType != "" {
+		headers = append(headers, kafka.Header{Key: HeaderContentType, Value: []byte(msg.ContentType)})
+	}
+
+	return kafka.Message{
+		Topic:   msg.Topic,
+		Key:     []byte(msg.Key),
+		Value:   msg.Value,
+		Headers: headers,
+		Time:    msg.Time,
+	}
+}
+
+func fromKafkaMessage(m kafka.Message) Message {
+	msg := Message{
+		Topic:     m.Topic,
+		Partition: m.Partition,
+		Offset:    m.Offset,
+		Key:       string(m.Key),
+		Value:     m.Value,
+		Time:      m.Time,
+	}
+	for _, header := range m.Headers {
+		value := string(header.Value)
+		switch header.Key {
+		case HeaderID:
+			msg.ID = value
+		case HeaderContentType:
+			msg.ContentType = value
+		case HeaderTime:
+			if t, err := time.Parse(time.RFC3339Nano, value); err == nil {
+				msg.Time = t
+			}
+		default:
+			if msg.Headers == nil {
+				msg.Headers = make(map[string]string, len(m.Headers))
+			}
+			msg.Headers[header.Key] = value
+		}
+	}
+	return msg
 }
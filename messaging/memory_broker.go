@@ -0,0 +1,430 @@
+package messaging
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"sync"
+)
+
+const defaultMemoryPartitions = 4
+
+// MemoryBroker is an in-process MessageBroker for tests and local
+// development. Topics are created on first use with a fixed number of
+// partitions and keep every message for the lifetime of the broker.
+// Messages are assigned to partitions by key hash, so messages sharing a
+// key keep their order. Each consumer group reads a topic from the
+// beginning and tracks its own committed offsets; the partitions of a topic
+// are split among the subscriptions of a group like Kafka does.
+type MemoryBroker struct {
+	partitions int
+
+	mu         sync.Mutex
+	closed     bool
+	topics     map[string]*memoryTopic
+	groups     map[memoryGroupKey]*memoryGroup
+	roundRobin int
+}
+
+// MemoryOption configures a MemoryBroker.
+type MemoryOption func(*MemoryBroker)
+
+// WithMemoryPartitions sets the number of partitions of every topic.
+// Defaults to 4.
+func WithMemoryPartitions(n int) MemoryOption {
+	return func(b *MemoryBroker) {
+		b.partitions = n
+	}
+}
+
+type memoryTopic struct {
+	partitions [][]Message
+	// changed is closed and replaced whenever a message is appended.
+	changed chan struct{}
+}
+
+type memoryGroupKey struct {
+	topic string
+	group string
+}
+
+type memoryGroup struct {
+	topic     *memoryTopic
+	committed []int64 // next offset to consume per partition, guarded by the broker mutex
+
+	// mu serializes rebalances and guards members and gen. It is taken
+	// before the broker mutex.
+	mu      sync.Mutex
+	members []*memoryMember
+	gen     *memoryGeneration
+}
+
+type memoryMember struct {
+	ctx        context.Context
+	dispatcher *dispatcher
+}
+
+// memoryGeneration is one assignment of partitions to members; a
+// rebalance stops it and starts the next one.
+type memoryGeneration struct {
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// NewMemoryBroker creates an empty in-memory broker.
+func NewMemoryBroker(opts ...MemoryOption) *MemoryBroker {
+	b := &MemoryBroker{
+		partitions: defaultMemoryPartitions,
+		topics:     make(map[string]*memoryTopic),
+		groups:     make(map[memoryGroupKey]*memoryGroup),
+	}
+	for _, opt := range opts {
+		opt(b)
+	}
+	if b.partitions < 1 {
+		b.partitions = 1
+	}
+	return b
+}
+
+func (b *MemoryBroker) Init() error {
+	return nil
+}
+
+func (b *MemoryBroker) Publish(ctx context.Context, topic string, key string, message []byte) error {
+	return b.PublishMessage(ctx, Message{Topic: topic, Key: key, Value: message})
+}
+
+// PublishMessage appends a copy of msg to its topic, see MessageBroker.
+func (b *MemoryBroker) PublishMessage(ctx context.Context, msg Message) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if msg.Topic == "" {
+		return fmt.Errorf("topic is required")
+	}
+	msg = prepareMessage(msg)
+	msg.Value = append([]byte(nil), msg.Value...)
+	if msg.Headers != nil {
+		headers := make(map[string]string, len(msg.Headers))
+		for name, value := range msg.Headers {
+			headers[name] = value
+		}
+		msg.Headers = headers
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return ErrBrokerClosed
+	}
+	t := b.topic(msg.Topic)
+	msg.Partition = b.partitionFor(msg.Key)
+	msg.Offset = int64(len(t.partitions[msg.Partition]))
+	t.partitions[msg.Partition] = append(t.partitions[msg.Partition], msg)
+	close(t.changed)
+	t.changed = make(chan struct{})
+	return nil
+}
+
+// PublishAsync publishes msg at once and returns its completed future, see
+// AsyncPublisher.
+func (b *MemoryBroker) PublishAsync(ctx context.Context, msg Message) *PublishFuture {
+	f := newPublishFuture(prepareMessage(msg))
+	f.complete(b.PublishMessage(ctx, f.msg))
+	return f
+}
+
+// PublishBatch publishes msgs in order, see AsyncPublisher.
+func (b *MemoryBroker) PublishBatch(ctx context.Context, msgs []Message) error {
+	errs := make([]error, len(msgs))
+	for i, msg := range msgs {
+		errs[i] = b.PublishMessage(ctx, msg)
+	}
+	return batchError(errs)
+}
+
+// Flush returns at once, since MemoryBroker publishes synchronously.
+func (b *MemoryBroker) Flush(ctx context.Context) error {
+	return nil
+}
+
+// Subscribe joins group on topic, see MessageBroker. Retry topics are
+// consumed like on KafkaProvider.
+func (b *MemoryBroker) Subscribe(ctx context.Context, topic, group string, handler Handler, opts ...SubscribeOption) error {
+	if topic == "" || group == "" {
+		return fmt.Errorf("topic and consumer group are required")
+	}
+	options := newSubscribeOptions(opts)
+	router := newFailureRouter(topic, group, options, b.PublishMessage)
+	topics := []string{topic}
+	if router != nil {
+		topics = router.topics()
+	}
+
+	groups := make([]*memoryGroup, len(topics))
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		return ErrBrokerClosed
+	}
+	for i, topic := range topics {
+		key := memoryGroupKey{topic: topic, group: group}
+		g, found := b.groups[key]
+		if !found {
+			t := b.topic(topic)
+			g = &memoryGroup{topic: t, committed: make([]int64, len(t.partitions))}
+			b.groups[key] = g
+		}
+		groups[i] = g
+	}
+	b.mu.Unlock()
+
+	for _, g := range groups {
+		member := &memoryMember{ctx: ctx}
+		member.dispatcher = newDispatcher(ctx, handler, options, router, func(_ context.Context, msg Message) error {
+			b.commit(g, msg)
+			return nil
+		})
+		if err := b.join(g, member); err != nil {
+			return err
+		}
+		go b.leaveWhenDone(g, member)
+	}
+	return nil
+}
+
+// Messages returns a copy of every message published to topic, in
+// partition order.
+func (b *MemoryBroker) Messages(topic string) []Message {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	t, found := b.topics[topic]
+	if !found {
+		return nil
+	}
+	var msgs []Message
+	for _, partition := range t.partitions {
+		msgs = append(msgs, partition...)
+	}
+	return msgs
+}
+
+// EnsureTopics validates specs and creates the missing topics, see
+// TopicAdmin. Every topic has the partitions of the broker and specs are
+// otherwise ignored, so no drift is reported.
+func (b *MemoryBroker) EnsureTopics(ctx context.Context, specs ...TopicSpec) ([]TopicDrift, error) {
+	if err := validateTopicSpecs(specs); err != nil {
+		return nil, err
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return nil, ErrBrokerClosed
+	}
+	for _, spec := range specs {
+		b.topic(spec.Name)
+	}
+	return nil, nil
+}
+
+// DescribeTopics describes topics that were published to, subscribed to or
+// ensured, see TopicAdmin. They have no configs.
+func (b *MemoryBroker) DescribeTopics(ctx context.Context, names ...string) ([]TopicInfo, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return nil, ErrBrokerClosed
+	}
+	infos := make([]TopicInfo, len(names))
+	for i, name := range names {
+		if _, found := b.topics[name]; !found {
+			return nil, fmt.Errorf("%w: %s", ErrTopicNotFound, name)
+		}
+		infos[i] = TopicInfo{Name: name, Partitions: b.partitions, ReplicationFactor: 1, Configs: map[string]string{}}
+	}
+	return infos, nil
+}
+
+// HealthCheck fails only once the broker is closed.
+func (b *MemoryBroker) HealthCheck(ctx context.Context) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return ErrBrokerClosed
+	}
+	return nil
+}
+
+// Close stops every subscription, waiting for in-flight handlers.
+func (b *MemoryBroker) Close() error {
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		return nil
+	}
+	b.closed = true
+	groups := make([]*memoryGroup, 0, len(b.groups))
+	for _, g := range b.groups {
+		groups = append(groups, g)
+	}
+	b.mu.Unlock()
+
+	for _, g := range groups {
+		g.mu.Lock()
+		stopGeneration(g.gen)
+		for _, member := range g.members {
+			member.dispatcher.close()
+		}
+		g.gen, g.members = nil, nil
+		g.mu.Unlock()
+	}
+	return nil
+}
+
+// topic returns the topic named name, creating it. The caller must hold
+// b.mu.
+func (b *MemoryBroker) topic(name string) *memoryTopic {
+	t, found := b.topics[name]
+	if !found {
+		t = &memoryTopic{
+			partitions: make([][]Message, b.partitions),
+			changed:    make(chan struct{}),
+		}
+		b.topics[name] = t
+	}
+	return t
+}
+
+// partitionFor hashes key, spreading keyless messages round robin. The
+// caller must hold b.mu.
+func (b *MemoryBroker) partitionFor(key string) int {
+	if key == "" {
+		b.roundRobin++
+		return b.roundRobin % b.partitions
+	}
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return int(h.Sum32() % uint32(b.partitions))
+}
+
+func (b *MemoryBroker) commit(g *memoryGroup, msg Message) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if msg.Offset+1 > g.committed[msg.Partition] {
+		g.committed[msg.Partition] = msg.Offset + 1
+	}
+}
+
+// join adds member to g and rebalances.
+func (b *MemoryBroker) join(g *memoryGroup, member *memoryMember) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	stopGeneration(g.gen)
+	g.gen = nil
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		member.dispatcher.close()
+		return ErrBrokerClosed
+	}
+	g.members = append(g.members, member)
+	b.rebalance(g)
+	return nil
+}
+
+// leaveWhenDone removes member from g once its context is done and
+// rebalances.
+func (b *MemoryBroker) leaveWhenDone(g *memoryGroup, member *memoryMember) {
+	<-member.ctx.Done()
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	index := -1
+	for i, m := range g.members {
+		if m == member {
+			index = i
+		}
+	}
+	if index < 0 {
+		// Already removed by Close.
+		return
+	}
+	// The fetch loops must be gone before the dispatcher is closed.
+	stopGeneration(g.gen)
+	g.gen = nil
+	member.dispatcher.close()
+	g.members = append(g.members[:index], g.members[index+1:]...)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.closed {
+		b.rebalance(g)
+	}
+}
+
+// rebalance starts the fetch loops of g with partitions assigned round
+// robin to its members, from the committed offsets on. Messages that were
+// in flight when the previous generation stopped may be delivered twice,
+// as with Kafka. The caller must hold g.mu and b.mu and have stopped the
+// previous generation.
+func (b *MemoryBroker) rebalance(g *memoryGroup) {
+	if len(g.members) == 0 {
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	gen := &memoryGeneration{cancel: cancel}
+	for partition := range g.topic.partitions {
+		member := g.members[partition%len(g.members)]
+		gen.wg.Add(1)
+		go b.fetch(ctx, gen, g, member, partition, g.committed[partition])
+	}
+	g.gen = gen
+}
+
+// fetch feeds partition of g to member from offset on until ctx is done.
+func (b *MemoryBroker) fetch(ctx context.Context, gen *memoryGeneration, g *memoryGroup, member *memoryMember, partition int, offset int64) {
+	defer gen.wg.Done()
+	for {
+		b.mu.Lock()
+		log := g.topic.partitions[partition]
+		changed := g.topic.changed
+		b.mu.Unlock()
+
+		if offset >= int64(len(log)) {
+			select {
+			case <-changed:
+				continue
+			case <-ctx.Done():
+				return
+			}
+		}
+
+		dispatchCtx, cancel := mergeDone(ctx, member.ctx)
+		err := member.dispatcher.dispatch(dispatchCtx, log[offset])
+		cancel()
+		if err != nil {
+			return
+		}
+		offset++
+	}
+}
+
+func stopGeneration(gen *memoryGeneration) {
+	if gen == nil {
+		return
+	}
+	gen.cancel()
+	gen.wg.Wait()
+}
+
+// mergeDone returns a context that is done when either a or b is.
+func mergeDone(a, b context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(a)
+	stop := context.AfterFunc(b, cancel)
+	return ctx, func() {
+		stop()
+		cancel()
+	}
+}
@@ -0,0 +1,221 @@
+package messaging_test
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mauriciomartinezc/real-estate-mc-common/messaging"
+	"github.com/mauriciomartinezc/real-estate-mc-common/messaging/messagingtest"
+)
+
+// collector records the values it handles per key.
+type collector struct {
+	mu    sync.Mutex
+	byKey map[string][]int
+	total int
+}
+
+func newCollector() *collector {
+	return &collector{byKey: make(map[string][]int)}
+}
+
+func (c *collector) handle(ctx context.Context, msg messaging.Message) error {
+	n, err := strconv.Atoi(string(msg.Value))
+	if err != nil {
+		return messaging.Permanent(err)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.byKey[msg.Key] = append(c.byKey[msg.Key], n)
+	c.total++
+	return nil
+}
+
+// values returns the set of handled values.
+func (c *collector) values() map[int]bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	values := make(map[int]bool, c.total)
+	for _, ns := range c.byKey {
+		for _, n := range ns {
+			values[n] = true
+		}
+	}
+	return values
+}
+
+func (c *collector) count() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.total
+}
+
+// waitFor polls cond until it holds or a second passes.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func publishN(t *testing.T, broker messaging.MessageBroker, topic string, from, to, keys int) {
+	t.Helper()
+	for i := from; i < to; i++ {
+		key := "k" + strconv.Itoa(i%keys)
+		if err := broker.Publish(context.Background(), topic, key, []byte(strconv.Itoa(i))); err != nil {
+			t.Fatalf("Publish: %v", err)
+		}
+	}
+}
+
+func TestMemoryBrokerDeliversToEveryGroup(t *testing.T) {
+	broker := messaging.NewMemoryBroker()
+	defer broker.Close()
+	ctx := context.Background()
+
+	// Messages published before subscribing are delivered too.
+	publishN(t, broker, "properties", 0, 20, 4)
+	a, b := newCollector(), newCollector()
+	if err := broker.Subscribe(ctx, "properties", "a", a.handle); err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+	if err := broker.Subscribe(ctx, "properties", "b", b.handle); err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+	publishN(t, broker, "properties", 20, 40, 4)
+
+	waitFor(t, "both groups", func() bool { return a.count() == 40 && b.count() == 40 })
+}
+
+func TestMemoryBrokerKeepsKeyOrder(t *testing.T) {
+	broker := messaging.NewMemoryBroker()
+	defer broker.Close()
+
+	c := newCollector()
+	err := broker.Subscribe(context.Background(), "properties", "svc", c.handle, messaging.WithConcurrency(8))
+	if err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+	publishN(t, broker, "properties", 0, 200, 10)
+	waitFor(t, "every message", func() bool { return c.count() == 200 })
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key, values := range c.byKey {
+		for i := 1; i < len(values); i++ {
+			if values[i] < values[i-1] {
+				t.Fatalf("messages of %s out of order: %v", key, values)
+			}
+		}
+	}
+}
+
+func TestMemoryBrokerSharesPartitionsWithinGroup(t *testing.T) {
+	broker := messaging.NewMemoryBroker(messaging.WithMemoryPartitions(4))
+	defer broker.Close()
+	ctx := context.Background()
+
+	first, second := newCollector(), newCollector()
+	firstCtx, leave := context.WithCancel(ctx)
+	if err := broker.Subscribe(firstCtx, "properties", "svc", first.handle); err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+	if err := broker.Subscribe(ctx, "properties", "svc", second.handle); err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+	publishN(t, broker, "properties", 0, 40, 8)
+	waitFor(t, "the first batch", func() bool { return first.count()+second.count() == 40 })
+	if first.count() == 0 || second.count() == 0 {
+		t.Fatalf("partitions not shared: %d and %d messages", first.count(), second.count())
+	}
+
+	// The remaining member takes over the partitions of one that leaves. A
+	// message the leaving member already fetched may still be handled by
+	// it, so only the union is checked.
+	leave()
+	publishN(t, broker, "properties", 40, 80, 8)
+	waitFor(t, "the takeover", func() bool {
+		seen := first.values()
+		for n := range second.values() {
+			seen[n] = true
+		}
+		return len(seen) == 80
+	})
+}
+
+func TestMemoryBrokerRoutesFailures(t *testing.T) {
+	broker := messaging.NewMemoryBroker(messaging.WithMemoryPartitions(1))
+	defer broker.Close()
+	ctx := context.Background()
+
+	err := broker.Subscribe(ctx, "orders", "svc", func(ctx context.Context, msg messaging.Message) error {
+		return errors.New("handler failed")
+	},
+		messaging.WithHandlerRetry(messaging.RetryPolicy{MaxAttempts: 2, InitialBackoff: time.Millisecond}),
+		messaging.WithRetryTopics(20*time.Millisecond),
+		messaging.WithDeadLetterTopic(""),
+	)
+	if err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+	if err := messaging.PublishJSON(ctx, broker, "orders", "o1", map[string]int{"n": 1}, messaging.WithEventType("order.created")); err != nil {
+		t.Fatalf("PublishJSON: %v", err)
+	}
+
+	msg := messagingtest.ExpectMessage(t, broker, "orders.svc.dlt", messagingtest.HasKey("o1"), 2*time.Second)
+	if msg.Header(messaging.HeaderOriginalTopic) != "orders" {
+		t.Fatalf("original topic header = %q, want orders", msg.Header(messaging.HeaderOriginalTopic))
+	}
+	if msg.Header(messaging.HeaderType) != "order.created" {
+		t.Fatalf("event type header = %q, want order.created", msg.Header(messaging.HeaderType))
+	}
+	if n := len(broker.Messages("orders.svc.retry.1")); n != 1 {
+		t.Fatalf("%d messages on the retry topic, want 1", n)
+	}
+}
+
+func TestMemoryBrokerCopiesPublishedMessages(t *testing.T) {
+	broker := messaging.NewMemoryBroker()
+	defer broker.Close()
+
+	value := []byte("original")
+	msg := messaging.NewMessage("properties", "k", value)
+	if err := broker.PublishMessage(context.Background(), msg); err != nil {
+		t.Fatalf("PublishMessage: %v", err)
+	}
+	copy(value, "modified")
+	if got := string(broker.Messages("properties")[0].Value); got != "original" {
+		t.Fatalf("stored value = %q, want original", got)
+	}
+}
+
+func TestMemoryBrokerClose(t *testing.T) {
+	broker, err := messaging.NewMessageBroker("memory")
+	if err != nil {
+		t.Fatalf("NewMessageBroker: %v", err)
+	}
+	if err := broker.Init(); err != nil {
+		t.Fatalf("Init: %v", err)
+	}
+	if err := broker.HealthCheck(context.Background()); err != nil {
+		t.Fatalf("HealthCheck: %v", err)
+	}
+	if err := broker.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	if err := broker.Publish(context.Background(), "properties", "", nil); !errors.Is(err, messaging.ErrBrokerClosed) {
+		t.Fatalf("Publish after Close error = %v, want ErrBrokerClosed", err)
+	}
+	if err := broker.HealthCheck(context.Background()); !errors.Is(err, messaging.ErrBrokerClosed) {
+		t.Fatalf("HealthCheck after Close error = %v, want ErrBrokerClosed", err)
+	}
+}
@@ -0,0 +1,179 @@
+package messaging
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// Message is a record published to or delivered from a topic. Partition and
+// Offset are only set on delivered messages.
+type Message struct {
+	Topic     string
+	Partition int
+	Offset    int64
+	Key       string
+	Value     []byte
+	// ID uniquely identifies the message; PublishMessage generates one if
+	// empty.
+	ID string
+	// ContentType describes Value, e.g. "application/json".
+	ContentType string
+	// Headers carries metadata such as HeaderType or HeaderCorrelationID.
+	Headers map[string]string
+	// Time is when the message was produced; PublishMessage defaults it to
+	// now.
+	Time time.Time
+}
+
+// Header returns the header name, or "" if it is not set.
+func (m Message) Header(name string) string {
+	return m.Headers[name]
+}
+
+// Handler processes a message. Returning an error leaves the message
+// uncommitted; it is retried and never skipped, so delivery is at least
+// once and handlers must be idempotent. Errors wrapped with Permanent are
+// not retried, and errors wrapped with RetryLater are retried without
+// counting an attempt.
+type Handler func(ctx context.Context, msg Message) error
+
+// PermanentError marks a handler error that retrying cannot fix, such as a
+// malformed payload.
+type PermanentError struct {
+	Err error
+}
+
+func (e *PermanentError) Error() string {
+	return "permanent failure: " + e.Err.Error()
+}
+
+func (e *PermanentError) Unwrap() error {
+	return e.Err
+}
+
+// Permanent wraps err so the message is not retried; it is logged and
+// committed instead.
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &PermanentError{Err: err}
+}
+
+// IsPermanent reports whether err was wrapped with Permanent.
+func IsPermanent(err error) bool {
+	var permanent *PermanentError
+	return errors.As(err, &permanent)
+}
+
+// RetryLaterError marks a handler error that says nothing about the
+// message itself, such as another consumer still processing a duplicate of
+// it.
+type RetryLaterError struct {
+	Err error
+	// After is how long to wait before the handler is called again.
+	After time.Duration
+}
+
+func (e *RetryLaterError) Error() string {
+	return "retry later: " + e.Err.Error()
+}
+
+func (e *RetryLaterError) Unwrap() error {
+	return e.Err
+}
+
+// RetryLater wraps err so the handler is called again after the given
+// delay without counting an attempt: the message is never moved to a retry
+// or dead-letter topic because of it. A zero delay uses the first backoff
+// of the subscription's retry policy.
+func RetryLater(err error, after time.Duration) error {
+	if err == nil {
+		return nil
+	}
+	return &RetryLaterError{Err: err, After: after}
+}
+
+// retryLaterDelay returns the delay of err if it was wrapped with
+// RetryLater.
+func retryLaterDelay(err error) (time.Duration, bool) {
+	var retryLater *RetryLaterError
+	if !errors.As(err, &retryLater) {
+		return 0, false
+	}
+	return retryLater.After, true
+}
+
+// SubscribeOption configures a subscription.
+type SubscribeOption func(*subscribeOptions)
+
+type subscribeOptions struct {
+	concurrency     int
+	retry           RetryPolicy
+	retryDelays     []time.Duration
+	deadLetter      bool
+	deadLetterTopic string
+}
+
+// defaultRoutedAttempts is how often a handler is called in place before a
+// failed message is moved to a retry or dead-letter topic.
+const defaultRoutedAttempts = 3
+
+// WithConcurrency sets how many messages of the same partition are handled
+// at once. Messages are spread over the workers by key, so messages sharing
+// a key are still handled in order. Defaults to 1.
+func WithConcurrency(n int) SubscribeOption {
+	return func(o *subscribeOptions) {
+		o.concurrency = n
+	}
+}
+
+// WithHandlerRetry sets how a failing handler is retried in place, blocking
+// its worker. Without retry or dead-letter topics a message is never given
+// up, so MaxAttempts only applies when one of them is configured, and then
+// defaults to 3.
+func WithHandlerRetry(policy RetryPolicy) SubscribeOption {
+	return func(o *subscribeOptions) {
+		o.retry = policy
+	}
+}
+
+// WithRetryTopics moves messages that still fail after the in-place
+// attempts to a chain of retry topics, named "<topic>.<group>.retry.<n>",
+// where they are handled again once delays[n-1] has passed. The partition
+// they came from is committed and keeps flowing.
+func WithRetryTopics(delays ...time.Duration) SubscribeOption {
+	return func(o *subscribeOptions) {
+		o.retryDelays = delays
+	}
+}
+
+// WithDeadLetterTopic moves messages that fail permanently, or still fail
+// after the last retry topic, to topic with their original headers plus
+// the failure reason. An empty topic defaults to "<topic>.<group>.dlt".
+func WithDeadLetterTopic(topic string) SubscribeOption {
+	return func(o *subscribeOptions) {
+		o.deadLetter = true
+		o.deadLetterTopic = topic
+	}
+}
+
+func newSubscribeOptions(opts []SubscribeOption) subscribeOptions {
+	options := subscribeOptions{concurrency: 1}
+	for _, opt := range opts {
+		opt(&options)
+	}
+	if options.concurrency < 1 {
+		options.concurrency = 1
+	}
+	if options.routesFailures() && options.retry.MaxAttempts <= 0 {
+		options.retry.MaxAttempts = defaultRoutedAttempts
+	}
+	return options
+}
+
+// routesFailures reports whether failed messages leave their partition.
+func (o subscribeOptions) routesFailures() bool {
+	return o.deadLetter || len(o.retryDelays) > 0
+}
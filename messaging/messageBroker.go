@@ -5,5 +5,18 @@ import "context"
 type MessageBroker interface {
 	Init() error
 	Publish(ctx context.Context, topic string, key string, message []byte) error
+	// PublishMessage publishes msg with its headers to msg.Topic, filling
+	// in the ID and time when empty.
+	PublishMessage(ctx context.Context, msg Message) error
+	// Subscribe starts consuming topic as part of the consumer group group
+	// in the background and returns once the subscription is set up. Each
+	// message is committed after handler returns nil. Consumption stops
+	// when ctx is canceled or the broker is closed.
+	Subscribe(ctx context.Context, topic, group string, handler Handler, opts ...SubscribeOption) error
+	// HealthCheck verifies that the brokers are reachable and the
+	// subscribed topics are available, for readiness probes.
+	HealthCheck(ctx context.Context) error
+	// Close stops every subscription, waiting for in-flight handlers to
+	// return, and releases the broker.
 	Close() error
 }
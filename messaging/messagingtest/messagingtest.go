@@ -0,0 +1,131 @@
+// Package messagingtest provides assertions for tests that publish or
+// consume messages, typically against a messaging.MemoryBroker.
+package messagingtest
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/mauriciomartinezc/real-estate-mc-common/messaging"
+)
+
+// Predicate selects the messages an assertion is looking for.
+type Predicate func(msg messaging.Message) bool
+
+// Any matches every message.
+func Any(messaging.Message) bool {
+	return true
+}
+
+// HasKey matches messages with the given key.
+func HasKey(key string) Predicate {
+	return func(msg messaging.Message) bool {
+		return msg.Key == key
+	}
+}
+
+// HasHeader matches messages whose header name equals value.
+func HasHeader(name, value string) Predicate {
+	return func(msg messaging.Message) bool {
+		return msg.Header(name) == value
+	}
+}
+
+// HasEventType matches messages with the given CloudEvents type.
+func HasEventType(eventType string) Predicate {
+	return HasHeader(messaging.HeaderType, eventType)
+}
+
+// All matches messages that match every predicate.
+func All(predicates ...Predicate) Predicate {
+	return func(msg messaging.Message) bool {
+		for _, predicate := range predicates {
+			if !predicate(msg) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// ExpectMessage waits up to timeout for a message on topic that matches
+// and returns it, failing the test otherwise. It reads topic through a
+// consumer group of its own, so on a MemoryBroker messages published before
+// the call are seen too.
+func ExpectMessage(t testing.TB, broker messaging.MessageBroker, topic string, match Predicate, timeout time.Duration) messaging.Message {
+	t.Helper()
+	msg, seen, found := await(t, broker, topic, match, timeout)
+	if !found {
+		t.Fatalf("no matching message on topic %s after %s (%d messages seen)", topic, timeout, seen)
+	}
+	return msg
+}
+
+// ExpectNoMessage fails the test if a message on topic matches within wait.
+func ExpectNoMessage(t testing.TB, broker messaging.MessageBroker, topic string, match Predicate, wait time.Duration) {
+	t.Helper()
+	msg, _, found := await(t, broker, topic, match, wait)
+	if found {
+		t.Fatalf("unexpected message on topic %s: key %q, id %q, value %s", topic, msg.Key, msg.ID, msg.Value)
+	}
+}
+
+// ExpectJSON waits for a message on topic whose JSON payload matches and
+// returns the payload. Messages that do not decode as T are ignored.
+func ExpectJSON[T any](t testing.TB, broker messaging.MessageBroker, topic string, match func(msg messaging.Message, payload T) bool, timeout time.Duration) T {
+	t.Helper()
+	var payload T
+	ExpectMessage(t, broker, topic, func(msg messaging.Message) bool {
+		var decoded T
+		if err := json.Unmarshal(msg.Value, &decoded); err != nil {
+			return false
+		}
+		if match != nil && !match(msg, decoded) {
+			return false
+		}
+		payload = decoded
+		return true
+	}, timeout)
+	return payload
+}
+
+// await subscribes to topic until a message matches or timeout elapses,
+// returning the match, how many messages were seen and whether one
+// matched.
+func await(t testing.TB, broker messaging.MessageBroker, topic string, match Predicate, timeout time.Duration) (messaging.Message, int, bool) {
+	t.Helper()
+	if match == nil {
+		match = Any
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	messages := make(chan messaging.Message)
+	group := "messagingtest-" + uuid.NewString()
+	err := broker.Subscribe(ctx, topic, group, func(ctx context.Context, msg messaging.Message) error {
+		select {
+		case messages <- msg:
+		case <-ctx.Done():
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("error subscribing to topic %s: %v", topic, err)
+	}
+
+	seen := 0
+	for {
+		select {
+		case msg := <-messages:
+			seen++
+			if match(msg) {
+				return msg, seen, true
+			}
+		case <-ctx.Done():
+			return messaging.Message{}, seen, false
+		}
+	}
+}
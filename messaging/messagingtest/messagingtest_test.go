@@ -0,0 +1,143 @@
+package messagingtest_test
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mauriciomartinezc/real-estate-mc-common/messaging"
+	"github.com/mauriciomartinezc/real-estate-mc-common/messaging/messagingtest"
+)
+
+// recorder is a testing.TB whose Fatalf records the failure and stops the
+// calling goroutine, so assertions can be checked to fail.
+type recorder struct {
+	testing.TB
+	mu     sync.Mutex
+	failed string
+}
+
+func (r *recorder) Fatalf(format string, args ...interface{}) {
+	r.mu.Lock()
+	r.failed = fmt.Sprintf(format, args...)
+	r.mu.Unlock()
+	runtime.Goexit()
+}
+
+// run calls fn with a recorder and returns the failure message, empty if
+// fn did not fail.
+func run(t *testing.T, fn func(tb testing.TB)) string {
+	r := &recorder{TB: t}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		fn(r)
+	}()
+	<-done
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.failed
+}
+
+func newBroker(t *testing.T) *messaging.MemoryBroker {
+	broker := messaging.NewMemoryBroker()
+	t.Cleanup(func() { broker.Close() })
+	return broker
+}
+
+func TestPredicates(t *testing.T) {
+	msg := messaging.NewMessage("orders", "o1", nil,
+		messaging.WithEventType("order.created"),
+		messaging.WithCompanyID("c1"),
+	)
+	tests := []struct {
+		name  string
+		match messagingtest.Predicate
+		want  bool
+	}{
+		{"Any", messagingtest.Any, true},
+		{"HasKey", messagingtest.HasKey("o1"), true},
+		{"HasKeyOther", messagingtest.HasKey("o2"), false},
+		{"HasHeader", messagingtest.HasHeader(messaging.HeaderCompanyID, "c1"), true},
+		{"HasHeaderOther", messagingtest.HasHeader(messaging.HeaderCompanyID, "c2"), false},
+		{"HasEventType", messagingtest.HasEventType("order.created"), true},
+		{"All", messagingtest.All(messagingtest.HasKey("o1"), messagingtest.HasEventType("order.created")), true},
+		{"AllOneFails", messagingtest.All(messagingtest.HasKey("o1"), messagingtest.HasEventType("order.paid")), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.match(msg); got != tt.want {
+				t.Fatalf("predicate = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExpectMessage(t *testing.T) {
+	broker := newBroker(t)
+	ctx := context.Background()
+	if err := broker.Publish(ctx, "orders", "o1", []byte("first")); err != nil {
+		t.Fatalf("Publish: %v", err)
+	}
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		_ = broker.Publish(ctx, "orders", "o2", []byte("second"))
+	}()
+
+	// Messages published before and after the call are both seen.
+	if msg := messagingtest.ExpectMessage(t, broker, "orders", messagingtest.HasKey("o1"), time.Second); string(msg.Value) != "first" {
+		t.Fatalf("ExpectMessage value = %q, want first", msg.Value)
+	}
+	if msg := messagingtest.ExpectMessage(t, broker, "orders", messagingtest.HasKey("o2"), time.Second); string(msg.Value) != "second" {
+		t.Fatalf("ExpectMessage value = %q, want second", msg.Value)
+	}
+
+	failure := run(t, func(tb testing.TB) {
+		messagingtest.ExpectMessage(tb, broker, "orders", messagingtest.HasKey("o3"), 50*time.Millisecond)
+	})
+	if failure == "" {
+		t.Fatal("ExpectMessage did not fail without a matching message")
+	}
+}
+
+func TestExpectNoMessage(t *testing.T) {
+	broker := newBroker(t)
+	if err := broker.Publish(context.Background(), "orders", "o1", nil); err != nil {
+		t.Fatalf("Publish: %v", err)
+	}
+
+	messagingtest.ExpectNoMessage(t, broker, "orders", messagingtest.HasKey("o2"), 50*time.Millisecond)
+	failure := run(t, func(tb testing.TB) {
+		messagingtest.ExpectNoMessage(tb, broker, "orders", messagingtest.HasKey("o1"), time.Second)
+	})
+	if failure == "" {
+		t.Fatal("ExpectNoMessage did not fail on a matching message")
+	}
+}
+
+func TestExpectJSON(t *testing.T) {
+	type order struct {
+		ID    string `json:"id"`
+		Total int    `json:"total"`
+	}
+	broker := newBroker(t)
+	ctx := context.Background()
+	if err := broker.Publish(ctx, "orders", "bad", []byte("not json")); err != nil {
+		t.Fatalf("Publish: %v", err)
+	}
+	for _, o := range []order{{"o1", 10}, {"o2", 20}} {
+		if err := messaging.PublishJSON(ctx, broker, "orders", o.ID, o); err != nil {
+			t.Fatalf("PublishJSON: %v", err)
+		}
+	}
+
+	got := messagingtest.ExpectJSON(t, broker, "orders", func(msg messaging.Message, o order) bool {
+		return o.Total > 15
+	}, time.Second)
+	if got.ID != "o2" {
+		t.Fatalf("ExpectJSON = %+v, want o2", got)
+	}
+}
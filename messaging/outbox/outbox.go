@@ -0,0 +1,104 @@
+// Package outbox implements the transactional outbox pattern: messages are
+// written to an outbox table in the same database transaction as the data
+// they describe, and a Relay publishes them afterwards, so an event is sent
+// if and only if its transaction commits.
+package outbox
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/mauriciomartinezc/real-estate-mc-common/messaging"
+	"gorm.io/gorm"
+)
+
+const tableName = "outbox_messages"
+
+// Record is a row of the outbox table.
+type Record struct {
+	ID          uint64            `gorm:"primaryKey;autoIncrement"`
+	MessageID   string            `gorm:"size:64;not null;uniqueIndex"`
+	Topic       string            `gorm:"size:255;not null;index:idx_outbox_messages_topic_key,priority:1"`
+	Key         string            `gorm:"column:message_key;size:255;not null;index:idx_outbox_messages_topic_key,priority:2"`
+	Value       []byte            `gorm:"not null"`
+	ContentType string            `gorm:"size:255"`
+	Headers     map[string]string `gorm:"serializer:json"`
+	CreatedAt   time.Time         `gorm:"not null"`
+	// AvailableAt delays the next publish attempt after a failure.
+	AvailableAt time.Time `gorm:"not null;index:idx_outbox_messages_pending,priority:2"`
+	Attempts    int       `gorm:"not null;default:0"`
+	LastError   string
+	// PublishedAt is set once the message was published; published rows
+	// are kept for the relay's retention period.
+	PublishedAt *time.Time `gorm:"index:idx_outbox_messages_pending,priority:1"`
+}
+
+func (Record) TableName() string {
+	return tableName
+}
+
+// AutoMigrate creates or updates the outbox table.
+func AutoMigrate(db *gorm.DB) error {
+	return db.AutoMigrate(&Record{})
+}
+
+// Enqueue stores msgs in the outbox through tx, which should be the
+// transaction that writes the data the messages describe. Messages without
+// an ID get one, so consumers can deduplicate redeliveries.
+func Enqueue(tx *gorm.DB, msgs ...messaging.Message) error {
+	if len(msgs) == 0 {
+		return nil
+	}
+	now := time.Now().UTC()
+	records := make([]Record, len(msgs))
+	for i, msg := range msgs {
+		if msg.ID == "" {
+			msg.ID = uuid.NewString()
+		}
+		createdAt := msg.Time
+		if createdAt.IsZero() {
+			createdAt = now
+		}
+		records[i] = Record{
+			MessageID:   msg.ID,
+			Topic:       msg.Topic,
+			Key:         msg.Key,
+			Value:       msg.Value,
+			ContentType: msg.ContentType,
+			Headers:     msg.Headers,
+			CreatedAt:   createdAt,
+			AvailableAt: now,
+		}
+	}
+	if err := tx.Create(&records).Error; err != nil {
+		return fmt.Errorf("error enqueuing outbox messages: %w", err)
+	}
+	return nil
+}
+
+// EnqueueJSON encodes payload as JSON and enqueues it, the outbox
+// counterpart of messaging.PublishJSON.
+func EnqueueJSON[T any](tx *gorm.DB, topic, key string, payload T, opts ...messaging.MessageOption) error {
+	value, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("error encoding message for topic %s: %w", topic, err)
+	}
+	msg := messaging.NewMessage(topic, key, value, opts...)
+	msg.ContentType = messaging.ContentTypeJSON
+	return Enqueue(tx, msg)
+}
+
+// message converts the record back to the message that was enqueued.
+func (r Record) message() messaging.Message {
+	return messaging.Message{
+		Topic:       r.Topic,
+		Key:         r.Key,
+		Value:       r.Value,
+		ID:          r.MessageID,
+		ContentType: r.ContentType,
+		Headers:     r.Headers,
+		Time:        r.CreatedAt,
+	}
+}
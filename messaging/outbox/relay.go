@@ -0,0 +1,228 @@
+package outbox
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/mauriciomartinezc/real-estate-mc-common/logger"
+	"github.com/mauriciomartinezc/real-estate-mc-common/messaging"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	defaultPollInterval    = time.Second
+	defaultBatchSize       = 100
+	defaultRetention       = 24 * time.Hour
+	defaultCleanupInterval = time.Hour
+)
+
+// RelayConfig configures a Relay.
+type RelayConfig struct {
+	// PollInterval is the wait between polls once the outbox is drained.
+	// Defaults to 1s.
+	PollInterval time.Duration
+	// BatchSize is how many rows are locked and published per transaction.
+	// Defaults to 100.
+	BatchSize int
+	// Retry sets the backoff between publish attempts of a row. Attempts
+	// are unlimited whatever MaxAttempts says, since giving up on a row
+	// would also block every later message with the same key.
+	Retry messaging.RetryPolicy
+	// Retention is how long published rows are kept. Defaults to 24h; a
+	// negative value deletes rows as soon as they are published.
+	Retention time.Duration
+	// CleanupInterval is how often published rows past the retention are
+	// deleted. Defaults to 1h.
+	CleanupInterval time.Duration
+}
+
+// Relay publishes the rows of the outbox through a MessageBroker. Rows are
+// locked with FOR UPDATE SKIP LOCKED, so any number of replicas can run a
+// relay against the same table, and a row is only picked once every earlier
+// row with the same topic and key is published, so messages sharing a key
+// are delivered in order. Delivery is at least once: a relay that dies
+// after publishing but before committing publishes the row again.
+type Relay struct {
+	db     *gorm.DB
+	broker messaging.MessageBroker
+	config RelayConfig
+
+	cancel    context.CancelFunc
+	startOnce sync.Once
+	wg        sync.WaitGroup
+}
+
+// NewRelay creates a relay; call Start to run it.
+func NewRelay(db *gorm.DB, broker messaging.MessageBroker, config RelayConfig) *Relay {
+	if config.PollInterval <= 0 {
+		config.PollInterval = defaultPollInterval
+	}
+	if config.BatchSize <= 0 {
+		config.BatchSize = defaultBatchSize
+	}
+	if config.Retention == 0 {
+		config.Retention = defaultRetention
+	}
+	if config.CleanupInterval <= 0 {
+		config.CleanupInterval = defaultCleanupInterval
+	}
+	config.Retry.MaxAttempts = 0
+
+	return &Relay{db: db, broker: broker, config: config}
+}
+
+// Start runs the relay in the background until ctx is done or Close is
+// called. Only the first call has an effect.
+func (r *Relay) Start(ctx context.Context) {
+	r.startOnce.Do(func() {
+		ctx, r.cancel = context.WithCancel(ctx)
+		r.wg.Add(2)
+		go r.poll(ctx)
+		go r.cleanup(ctx)
+	})
+}
+
+// Close stops the relay, waiting for the batch in progress.
+func (r *Relay) Close() error {
+	if r.cancel != nil {
+		r.cancel()
+	}
+	r.wg.Wait()
+	return nil
+}
+
+// ProcessBatch locks and publishes up to BatchSize available rows in one
+// transaction and returns how many were published. Brokers implementing
+// messaging.AsyncPublisher get the rows in a single PublishBatch, so the
+// locks are held for one round trip.
+func (r *Relay) ProcessBatch(ctx context.Context) (int, error) {
+	published := 0
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		now := time.Now().UTC()
+
+		// Earlier unpublished rows with the same key, locked or not, hold
+		// back the later ones.
+		earlier := tx.Table(tableName + " AS earlier").
+			Select("1").
+			Where("earlier.topic = " + tableName + ".topic").
+			Where("earlier.message_key = " + tableName + ".message_key").
+			Where("earlier.published_at IS NULL").
+			Where("earlier.id < " + tableName + ".id")
+
+		var records []Record
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("published_at IS NULL AND available_at <= ?", now).
+			Where("NOT EXISTS (?)", earlier).
+			Order("id").
+			Limit(r.config.BatchSize).
+			Find(&records).Error
+		if err != nil {
+			return fmt.Errorf("error reading outbox: %w", err)
+		}
+
+		errs := r.publishAll(ctx, records)
+		for i, record := range records {
+			if err := r.recordOutcome(tx, record, errs[i]); err != nil {
+				return err
+			}
+			if errs[i] == nil {
+				published++
+			}
+		}
+		return nil
+	})
+	return published, err
+}
+
+// publishAll publishes records and returns the error of each.
+func (r *Relay) publishAll(ctx context.Context, records []Record) []error {
+	if len(records) == 0 {
+		return nil
+	}
+	msgs := make([]messaging.Message, len(records))
+	for i, record := range records {
+		msgs[i] = record.message()
+	}
+	return messaging.BatchErrors(messaging.PublishBatch(ctx, r.broker, msgs), len(msgs))
+}
+
+// recordOutcome stores the outcome of publishing record. Only database
+// errors are returned; a failed publish is scheduled for a later attempt.
+func (r *Relay) recordOutcome(tx *gorm.DB, record Record, publishErr error) error {
+	if publishErr != nil {
+		attempts := record.Attempts + 1
+		logger.Warn().Err(publishErr).
+			Str("topic", record.Topic).
+			Str("message_id", record.MessageID).
+			Int("attempts", attempts).
+			Msg("Failed to publish outbox message")
+		return tx.Model(&record).Updates(map[string]interface{}{
+			"attempts":     attempts,
+			"available_at": time.Now().UTC().Add(r.config.Retry.Backoff(attempts)),
+			"last_error":   publishErr.Error(),
+		}).Error
+	}
+
+	if r.config.Retention < 0 {
+		return tx.Delete(&record).Error
+	}
+	return tx.Model(&record).Updates(map[string]interface{}{
+		"attempts":     record.Attempts + 1,
+		"published_at": time.Now().UTC(),
+		"last_error":   "",
+	}).Error
+}
+
+// Cleanup deletes published rows older than the retention period and
+// returns how many were deleted.
+func (r *Relay) Cleanup(ctx context.Context) (int64, error) {
+	if r.config.Retention < 0 {
+		return 0, nil
+	}
+	result := r.db.WithContext(ctx).
+		Where("published_at IS NOT NULL AND published_at < ?", time.Now().UTC().Add(-r.config.Retention)).
+		Delete(&Record{})
+	if result.Error != nil {
+		return 0, fmt.Errorf("error cleaning up outbox: %w", result.Error)
+	}
+	return result.RowsAffected, nil
+}
+
+func (r *Relay) poll(ctx context.Context) {
+	defer r.wg.Done()
+	for {
+		published, err := r.ProcessBatch(ctx)
+		if err != nil && ctx.Err() == nil {
+			logger.Error().Err(err).Msg("Outbox relay failed")
+		}
+		// Keep draining while there is work, one row per key at a time.
+		wait := r.config.PollInterval
+		if err == nil && published > 0 {
+			wait = 0
+		}
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (r *Relay) cleanup(ctx context.Context) {
+	defer r.wg.Done()
+	ticker := time.NewTicker(r.config.CleanupInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if _, err := r.Cleanup(ctx); err != nil && ctx.Err() == nil {
+				logger.Error().Err(err).Msg("Outbox cleanup failed")
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
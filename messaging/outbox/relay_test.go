@@ -0,0 +1,265 @@
+package outbox_test
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/glebarez/sqlite"
+	"github.com/mauriciomartinezc/real-estate-mc-common/messaging"
+	"github.com/mauriciomartinezc/real-estate-mc-common/messaging/outbox"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func newDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "outbox.db")), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		t.Fatalf("gorm.Open: %v", err)
+	}
+	if err := outbox.AutoMigrate(db); err != nil {
+		t.Fatalf("AutoMigrate: %v", err)
+	}
+	return db
+}
+
+func enqueue(t *testing.T, db *gorm.DB, topic, key string, values ...int) {
+	t.Helper()
+	err := db.Transaction(func(tx *gorm.DB) error {
+		for _, value := range values {
+			if err := outbox.EnqueueJSON(tx, topic, key, value); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("EnqueueJSON: %v", err)
+	}
+}
+
+// drain processes batches until nothing is left to publish.
+func drain(t *testing.T, relay *outbox.Relay) {
+	t.Helper()
+	for i := 0; i < 100; i++ {
+		published, err := relay.ProcessBatch(context.Background())
+		if err != nil {
+			t.Fatalf("ProcessBatch: %v", err)
+		}
+		if published == 0 {
+			return
+		}
+	}
+	t.Fatal("outbox not drained after 100 batches")
+}
+
+// failingBroker fails the first publishes of the keys in fail. It does not
+// implement messaging.AsyncPublisher, see batchBroker.
+type failingBroker struct {
+	messaging.MessageBroker
+	mu   sync.Mutex
+	sent []messaging.Message
+	fail map[string]int
+}
+
+func (f *failingBroker) PublishMessage(ctx context.Context, msg messaging.Message) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.fail[msg.Key] > 0 {
+		f.fail[msg.Key]--
+		return errors.New("broker unavailable")
+	}
+	f.sent = append(f.sent, msg)
+	return nil
+}
+
+// batchBroker adds PublishBatch to failingBroker, reporting failures per
+// message like KafkaProvider.
+type batchBroker struct {
+	*failingBroker
+	batches int
+}
+
+func (b *batchBroker) PublishAsync(ctx context.Context, msg messaging.Message) *messaging.PublishFuture {
+	panic("not used by the relay")
+}
+
+func (b *batchBroker) PublishBatch(ctx context.Context, msgs []messaging.Message) error {
+	b.batches++
+	errs := make([]error, len(msgs))
+	failed := false
+	for i, msg := range msgs {
+		if errs[i] = b.PublishMessage(ctx, msg); errs[i] != nil {
+			failed = true
+		}
+	}
+	if failed {
+		return &messaging.BatchError{Errs: errs}
+	}
+	return nil
+}
+
+func (b *batchBroker) Flush(ctx context.Context) error {
+	return nil
+}
+
+func TestRelayPublishesInKeyOrder(t *testing.T) {
+	db := newDB(t)
+	enqueue(t, db, "properties", "a", 0, 1, 2)
+	enqueue(t, db, "properties", "b", 0)
+	broker := messaging.NewMemoryBroker()
+	defer broker.Close()
+
+	drain(t, outbox.NewRelay(db, broker, outbox.RelayConfig{}))
+
+	var order []string
+	for _, msg := range broker.Messages("properties") {
+		if msg.Key == "a" {
+			order = append(order, string(msg.Value))
+		}
+		if msg.ID == "" || msg.ContentType != messaging.ContentTypeJSON {
+			t.Fatalf("message lost its ID or content type: %+v", msg)
+		}
+	}
+	if len(order) != 3 || order[0] != "0" || order[1] != "1" || order[2] != "2" {
+		t.Fatalf("messages of key a = %v, want [0 1 2]", order)
+	}
+	if n := len(broker.Messages("properties")); n != 4 {
+		t.Fatalf("published %d messages, want 4", n)
+	}
+
+	var pending int64
+	db.Model(&outbox.Record{}).Where("published_at IS NULL").Count(&pending)
+	if pending != 0 {
+		t.Fatalf("%d rows left unpublished", pending)
+	}
+}
+
+func TestRelaySkipsRolledBackMessages(t *testing.T) {
+	db := newDB(t)
+	_ = db.Transaction(func(tx *gorm.DB) error {
+		if err := outbox.EnqueueJSON(tx, "properties", "a", 1); err != nil {
+			t.Fatalf("EnqueueJSON: %v", err)
+		}
+		return errors.New("rollback")
+	})
+	broker := messaging.NewMemoryBroker()
+	defer broker.Close()
+
+	drain(t, outbox.NewRelay(db, broker, outbox.RelayConfig{}))
+	if msgs := broker.Messages("properties"); len(msgs) != 0 {
+		t.Fatalf("published %d rolled back messages", len(msgs))
+	}
+}
+
+func TestRelayRetriesFailedMessages(t *testing.T) {
+	tests := []struct {
+		name   string
+		broker func(f *failingBroker) messaging.MessageBroker
+	}{
+		{"PublishMessage", func(f *failingBroker) messaging.MessageBroker { return f }},
+		{"PublishBatch", func(f *failingBroker) messaging.MessageBroker { return &batchBroker{failingBroker: f} }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newDB(t)
+			enqueue(t, db, "properties", "a", 0)
+			enqueue(t, db, "properties", "b", 0, 1)
+			failing := &failingBroker{fail: map[string]int{"b": 1}}
+			relay := outbox.NewRelay(db, tt.broker(failing), outbox.RelayConfig{
+				Retry: messaging.RetryPolicy{InitialBackoff: time.Nanosecond},
+			})
+
+			published, err := relay.ProcessBatch(context.Background())
+			if err != nil || published != 1 {
+				t.Fatalf("ProcessBatch = %d, %v, want 1 published", published, err)
+			}
+			drain(t, relay)
+
+			var keys []string
+			for _, msg := range failing.sent {
+				keys = append(keys, msg.Key+string(msg.Value))
+			}
+			if len(keys) != 3 || keys[0] != "a0" || keys[1] != "b0" || keys[2] != "b1" {
+				t.Fatalf("published %v, want [a0 b0 b1]", keys)
+			}
+
+			var record outbox.Record
+			if err := db.Where("message_key = ?", "b").Order("id").First(&record).Error; err != nil {
+				t.Fatalf("reading record: %v", err)
+			}
+			if record.Attempts != 2 || record.PublishedAt == nil || record.LastError != "" {
+				t.Fatalf("record after retry = %+v, want 2 attempts and published", record)
+			}
+		})
+	}
+}
+
+func TestRelayPublishesBatchInOneCall(t *testing.T) {
+	db := newDB(t)
+	enqueue(t, db, "properties", "a", 0)
+	enqueue(t, db, "properties", "b", 0)
+	enqueue(t, db, "properties", "c", 0)
+	broker := &batchBroker{failingBroker: &failingBroker{}}
+
+	published, err := outbox.NewRelay(db, broker, outbox.RelayConfig{}).ProcessBatch(context.Background())
+	if err != nil || published != 3 {
+		t.Fatalf("ProcessBatch = %d, %v, want 3 published", published, err)
+	}
+	if broker.batches != 1 {
+		t.Fatalf("PublishBatch called %d times, want 1", broker.batches)
+	}
+}
+
+func TestRelayCleanup(t *testing.T) {
+	db := newDB(t)
+	enqueue(t, db, "properties", "a", 0, 1)
+	broker := messaging.NewMemoryBroker()
+	defer broker.Close()
+	relay := outbox.NewRelay(db, broker, outbox.RelayConfig{Retention: time.Hour})
+	drain(t, relay)
+
+	old := time.Now().UTC().Add(-2 * time.Hour)
+	var first outbox.Record
+	if err := db.Order("id").First(&first).Error; err != nil {
+		t.Fatalf("reading record: %v", err)
+	}
+	db.Model(&first).Update("published_at", old)
+	deleted, err := relay.Cleanup(context.Background())
+	if err != nil || deleted != 1 {
+		t.Fatalf("Cleanup = %d, %v, want 1 deleted", deleted, err)
+	}
+
+	enqueue(t, db, "properties", "b", 0)
+	drain(t, outbox.NewRelay(db, broker, outbox.RelayConfig{Retention: -1}))
+	var count int64
+	db.Model(&outbox.Record{}).Where("message_key = ?", "b").Count(&count)
+	if count != 0 {
+		t.Fatalf("%d rows kept with a negative retention, want 0", count)
+	}
+}
+
+func TestRelayStartAndClose(t *testing.T) {
+	db := newDB(t)
+	enqueue(t, db, "properties", "a", 0)
+	broker := messaging.NewMemoryBroker()
+	defer broker.Close()
+
+	relay := outbox.NewRelay(db, broker, outbox.RelayConfig{PollInterval: 10 * time.Millisecond})
+	relay.Start(context.Background())
+	defer relay.Close()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for len(broker.Messages("properties")) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("relay did not publish the message")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
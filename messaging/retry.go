@@ -0,0 +1,82 @@
+package messaging
+
+import (
+	"context"
+	"math"
+	"math/rand"
+	"time"
+)
+
+// RetryPolicy describes exponential backoff with jitter.
+type RetryPolicy struct {
+	// MaxAttempts is the total number of attempts, including the first.
+	// Zero or negative means unlimited.
+	MaxAttempts int
+	// InitialBackoff is the wait after the first failure. Defaults to
+	// 100ms.
+	InitialBackoff time.Duration
+	// MaxBackoff caps the wait between attempts. Defaults to 30s.
+	MaxBackoff time.Duration
+	// Multiplier grows the wait after every failure. Defaults to 2.
+	Multiplier float64
+	// Jitter randomizes every wait by up to this fraction, e.g. 0.2 for
+	// ±20%, so that replicas failing together do not retry in lockstep.
+	Jitter float64
+}
+
+// DefaultPublishRetryPolicy is used by KafkaProvider unless configured
+// otherwise.
+var DefaultPublishRetryPolicy = RetryPolicy{
+	MaxAttempts:    3,
+	InitialBackoff: 100 * time.Millisecond,
+	MaxBackoff:     2 * time.Second,
+	Multiplier:     2,
+	Jitter:         0.2,
+}
+
+const (
+	defaultRetryInitialBackoff = 100 * time.Millisecond
+	defaultRetryMaxBackoff     = 30 * time.Second
+	defaultRetryMultiplier     = 2
+)
+
+// Backoff returns the wait after the given failed attempt, starting at 1.
+func (p RetryPolicy) Backoff(attempt int) time.Duration {
+	initial, limit, multiplier := p.InitialBackoff, p.MaxBackoff, p.Multiplier
+	if initial <= 0 {
+		initial = defaultRetryInitialBackoff
+	}
+	if limit <= 0 {
+		limit = defaultRetryMaxBackoff
+	}
+	if multiplier < 1 {
+		multiplier = defaultRetryMultiplier
+	}
+
+	wait := math.Min(float64(initial)*math.Pow(multiplier, float64(max(attempt-1, 0))), float64(limit))
+	if p.Jitter > 0 {
+		wait += wait * p.Jitter * (2*rand.Float64() - 1)
+	}
+	return time.Duration(wait)
+}
+
+// exhausted reports whether no attempt is left after attempt.
+func (p RetryPolicy) exhausted(attempt int) bool {
+	return p.MaxAttempts > 0 && attempt >= p.MaxAttempts
+}
+
+// Do calls fn until it succeeds, returns a Permanent error, runs out of
+// attempts or ctx is done, and returns the last error.
+func (p RetryPolicy) Do(ctx context.Context, fn func(ctx context.Context) error) error {
+	for attempt := 1; ; attempt++ {
+		err := fn(ctx)
+		if err == nil || IsPermanent(err) || p.exhausted(attempt) {
+			return err
+		}
+		select {
+		case <-time.After(p.Backoff(attempt)):
+		case <-ctx.Done():
+			return err
+		}
+	}
+}
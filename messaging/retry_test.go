@@ -0,0 +1,88 @@
+package messaging_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/mauriciomartinezc/real-estate-mc-common/messaging"
+)
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	var defaults messaging.RetryPolicy
+	for attempt, want := range map[int]time.Duration{
+		0: 100 * time.Millisecond,
+		1: 100 * time.Millisecond,
+		2: 200 * time.Millisecond,
+		3: 400 * time.Millisecond,
+		// Capped at 30s.
+		20: 30 * time.Second,
+	} {
+		if got := defaults.Backoff(attempt); got != want {
+			t.Fatalf("Backoff(%d) = %v, want %v", attempt, got, want)
+		}
+	}
+
+	policy := messaging.RetryPolicy{InitialBackoff: 10 * time.Millisecond, MaxBackoff: time.Second, Multiplier: 3}
+	for attempt, want := range map[int]time.Duration{1: 10 * time.Millisecond, 2: 30 * time.Millisecond, 3: 90 * time.Millisecond, 6: time.Second} {
+		if got := policy.Backoff(attempt); got != want {
+			t.Fatalf("Backoff(%d) = %v, want %v", attempt, got, want)
+		}
+	}
+
+	policy.Jitter = 0.2
+	for i := 0; i < 100; i++ {
+		if got := policy.Backoff(2); got < 24*time.Millisecond || got > 36*time.Millisecond {
+			t.Fatalf("Backoff(2) with jitter = %v, want 30ms ±20%%", got)
+		}
+	}
+}
+
+func TestRetryPolicyDo(t *testing.T) {
+	policy := messaging.RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond}
+	ctx := context.Background()
+	failure := errors.New("unavailable")
+
+	calls := 0
+	err := policy.Do(ctx, func(ctx context.Context) error {
+		calls++
+		return failure
+	})
+	if !errors.Is(err, failure) || calls != 3 {
+		t.Fatalf("Do = %v after %d calls, want the last error after 3", err, calls)
+	}
+
+	calls = 0
+	err = policy.Do(ctx, func(ctx context.Context) error {
+		calls++
+		if calls < 2 {
+			return failure
+		}
+		return nil
+	})
+	if err != nil || calls != 2 {
+		t.Fatalf("Do = %v after %d calls, want success after 2", err, calls)
+	}
+
+	calls = 0
+	err = policy.Do(ctx, func(ctx context.Context) error {
+		calls++
+		return messaging.Permanent(failure)
+	})
+	if !messaging.IsPermanent(err) || calls != 1 {
+		t.Fatalf("Do = %v after %d calls, want the permanent error after 1", err, calls)
+	}
+
+	// A done context ends the backoff.
+	ctx, cancel := context.WithCancel(ctx)
+	cancel()
+	calls = 0
+	err = messaging.RetryPolicy{InitialBackoff: time.Hour}.Do(ctx, func(ctx context.Context) error {
+		calls++
+		return failure
+	})
+	if !errors.Is(err, failure) || calls != 1 {
+		t.Fatalf("Do with a canceled context = %v after %d calls, want the error after 1", err, calls)
+	}
+}
@@ -0,0 +1,260 @@
+package schema
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mauriciomartinezc/real-estate-mc-common/messaging"
+)
+
+const defaultCacheTTL = time.Minute
+
+// BrokerConfig configures a validating Broker.
+type BrokerConfig struct {
+	// Subject maps the topic and CloudEvents type of a message to its
+	// schema subject. Defaults to SubjectName.
+	Subject func(topic, eventType string) string
+	// Required rejects messages whose subject has no schema. By default
+	// they are published and consumed without validation.
+	Required bool
+	// CacheTTL is how long the latest version of a subject is used before
+	// the registry is asked again. Defaults to 1m.
+	CacheTTL time.Duration
+}
+
+// Broker wraps a MessageBroker to validate payloads against the latest
+// schema of their subject on publish, stamping its version in the
+// messaging.HeaderSchemaVersion header, and against the stamped version on
+// consume. Invalid payloads are rejected on publish and fail permanently on
+// consume, so they reach the dead-letter topic if there is one. Broker is
+// an AsyncPublisher whose PublishAsync and PublishBatch validate too,
+// publishing synchronously if the wrapped broker is not one.
+//
+// Payloads of Avro and Protobuf schemas are not validated, only stamped.
+type Broker struct {
+	messaging.MessageBroker
+	registry Registry
+	config   BrokerConfig
+
+	mu         sync.Mutex
+	latest     map[string]cachedSchema
+	validators map[string]Validator // by subject and version
+}
+
+type cachedSchema struct {
+	schema  Schema
+	expires time.Time
+}
+
+// NewBroker wraps broker with validation against registry.
+func NewBroker(broker messaging.MessageBroker, registry Registry, config BrokerConfig) *Broker {
+	if config.Subject == nil {
+		config.Subject = SubjectName
+	}
+	if config.CacheTTL <= 0 {
+		config.CacheTTL = defaultCacheTTL
+	}
+	return &Broker{
+		MessageBroker: broker,
+		registry:      registry,
+		config:        config,
+		latest:        make(map[string]cachedSchema),
+		validators:    make(map[string]Validator),
+	}
+}
+
+func (b *Broker) Publish(ctx context.Context, topic string, key string, message []byte) error {
+	return b.PublishMessage(ctx, messaging.Message{Topic: topic, Key: key, Value: message})
+}
+
+// PublishMessage validates and stamps msg, then publishes it.
+func (b *Broker) PublishMessage(ctx context.Context, msg messaging.Message) error {
+	msg, err := b.Validate(ctx, msg)
+	if err != nil {
+		return err
+	}
+	return b.MessageBroker.PublishMessage(ctx, msg)
+}
+
+// PublishAsync validates and stamps msg, then queues it, see
+// messaging.PublishAsync. An invalid msg returns a failed future.
+func (b *Broker) PublishAsync(ctx context.Context, msg messaging.Message) *messaging.PublishFuture {
+	validated, err := b.Validate(ctx, msg)
+	if err != nil {
+		return messaging.FailedFuture(msg, err)
+	}
+	return messaging.PublishAsync(ctx, b.MessageBroker, validated)
+}
+
+// PublishBatch validates and stamps msgs, then publishes the valid ones,
+// see messaging.PublishBatch. The invalid ones fail in the *BatchError.
+func (b *Broker) PublishBatch(ctx context.Context, msgs []messaging.Message) error {
+	errs := make([]error, len(msgs))
+	valid := make([]messaging.Message, 0, len(msgs))
+	indexes := make([]int, 0, len(msgs))
+	for i, msg := range msgs {
+		validated, err := b.Validate(ctx, msg)
+		if err != nil {
+			errs[i] = err
+			continue
+		}
+		valid = append(valid, validated)
+		indexes = append(indexes, i)
+	}
+	if len(valid) > 0 {
+		published := messaging.BatchErrors(messaging.PublishBatch(ctx, b.MessageBroker, valid), len(valid))
+		for j, err := range published {
+			errs[indexes[j]] = err
+		}
+	}
+	for _, err := range errs {
+		if err != nil {
+			return &messaging.BatchError{Errs: errs}
+		}
+	}
+	return nil
+}
+
+// Flush waits for the messages queued on the wrapped broker, if it is an
+// AsyncPublisher.
+func (b *Broker) Flush(ctx context.Context) error {
+	if async, ok := b.MessageBroker.(messaging.AsyncPublisher); ok {
+		return async.Flush(ctx)
+	}
+	return nil
+}
+
+// Subscribe validates every message before it reaches handler.
+func (b *Broker) Subscribe(ctx context.Context, topic, group string, handler messaging.Handler, opts ...messaging.SubscribeOption) error {
+	return b.MessageBroker.Subscribe(ctx, topic, group, b.Handler(handler), opts...)
+}
+
+// Validate checks msg against the latest schema of its subject and returns
+// it with the schema version header set. Use it to validate messages
+// published through other paths, such as an outbox.
+func (b *Broker) Validate(ctx context.Context, msg messaging.Message) (messaging.Message, error) {
+	eventType, data, err := payload(msg)
+	if err != nil {
+		return msg, err
+	}
+	subject := b.config.Subject(msg.Topic, eventType)
+	s, err := b.latestSchema(ctx, subject)
+	if errors.Is(err, ErrNotFound) && !b.config.Required {
+		return msg, nil
+	}
+	if err != nil {
+		return msg, err
+	}
+	if err := b.validate(s, data); err != nil {
+		return msg, err
+	}
+
+	headers := make(map[string]string, len(msg.Headers)+1)
+	for name, value := range msg.Headers {
+		headers[name] = value
+	}
+	headers[messaging.HeaderSchemaVersion] = strconv.Itoa(s.Version)
+	msg.Headers = headers
+	return msg, nil
+}
+
+// Handler wraps handler to validate consumed messages against the schema
+// version they were stamped with, or the latest one if unstamped.
+// Registry errors are returned as is so the message is retried.
+func (b *Broker) Handler(handler messaging.Handler) messaging.Handler {
+	return func(ctx context.Context, msg messaging.Message) error {
+		eventType, data, err := payload(msg)
+		if err != nil {
+			return messaging.Permanent(err)
+		}
+		// Messages on retry topics keep the subject of their original topic.
+		topic := msg.Topic
+		if original := msg.Header(messaging.HeaderOriginalTopic); original != "" {
+			topic = original
+		}
+		subject := b.config.Subject(topic, eventType)
+
+		var s Schema
+		if stamped := msg.Header(messaging.HeaderSchemaVersion); stamped != "" {
+			version, err := strconv.Atoi(stamped)
+			if err != nil {
+				return messaging.Permanent(fmt.Errorf("invalid schema version %q: %w", stamped, err))
+			}
+			s, err = b.registry.Version(ctx, subject, version)
+		} else {
+			s, err = b.latestSchema(ctx, subject)
+		}
+		switch {
+		case errors.Is(err, ErrNotFound) && !b.config.Required:
+			return handler(ctx, msg)
+		case errors.Is(err, ErrNotFound):
+			return messaging.Permanent(err)
+		case err != nil:
+			return err
+		}
+
+		if err := b.validate(s, data); err != nil {
+			return messaging.Permanent(err)
+		}
+		return handler(ctx, msg)
+	}
+}
+
+func (b *Broker) latestSchema(ctx context.Context, subject string) (Schema, error) {
+	now := time.Now()
+	b.mu.Lock()
+	cached, found := b.latest[subject]
+	b.mu.Unlock()
+	if found && now.Before(cached.expires) {
+		return cached.schema, nil
+	}
+
+	s, err := b.registry.Latest(ctx, subject)
+	if err != nil {
+		return Schema{}, err
+	}
+	b.mu.Lock()
+	b.latest[subject] = cachedSchema{schema: s, expires: now.Add(b.config.CacheTTL)}
+	b.mu.Unlock()
+	return s, nil
+}
+
+// validate checks data against s, compiling it once.
+func (b *Broker) validate(s Schema, data []byte) error {
+	key := versionKey(s.Subject, s.Version)
+	b.mu.Lock()
+	validator, found := b.validators[key]
+	b.mu.Unlock()
+	if !found {
+		var err error
+		validator, err = Compile(s)
+		if errors.Is(err, ErrUnsupportedFormat) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		b.mu.Lock()
+		b.validators[key] = validator
+		b.mu.Unlock()
+	}
+	return validator.Validate(data)
+}
+
+// payload returns the event type and data of msg, unwrapping structured
+// CloudEvents.
+func payload(msg messaging.Message) (string, []byte, error) {
+	if strings.HasPrefix(msg.ContentType, messaging.ContentTypeCloudEventsJSON) {
+		event, err := messaging.CloudEventFromMessage(msg)
+		if err != nil {
+			return "", nil, err
+		}
+		return event.Type, event.Data, nil
+	}
+	return msg.Header(messaging.HeaderType), msg.Value, nil
+}
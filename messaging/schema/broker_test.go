@@ -0,0 +1,245 @@
+package schema_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mauriciomartinezc/real-estate-mc-common/messaging"
+	"github.com/mauriciomartinezc/real-estate-mc-common/messaging/messagingtest"
+	"github.com/mauriciomartinezc/real-estate-mc-common/messaging/schema"
+)
+
+const orderSubject = "orders-order.created"
+
+func newFileRegistry(t *testing.T) *schema.FileRegistry {
+	t.Helper()
+	registry, err := schema.NewFileRegistry(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewFileRegistry: %v", err)
+	}
+	return registry
+}
+
+// newValidatingBroker returns a Broker over a MemoryBroker, and the
+// MemoryBroker to publish and read around the validation.
+func newValidatingBroker(t *testing.T, registry schema.Registry, config schema.BrokerConfig) (*schema.Broker, *messaging.MemoryBroker) {
+	t.Helper()
+	memory := messaging.NewMemoryBroker(messaging.WithMemoryPartitions(1))
+	t.Cleanup(func() { memory.Close() })
+	return schema.NewBroker(memory, registry, config), memory
+}
+
+func register(t *testing.T, registry schema.Registry, subject, definition string) {
+	t.Helper()
+	if _, err := registry.Register(context.Background(), subject, schema.FormatJSON, definition); err != nil {
+		t.Fatalf("Register: %v", err)
+	}
+}
+
+func order(key, payload string, opts ...messaging.MessageOption) messaging.Message {
+	opts = append([]messaging.MessageOption{messaging.WithEventType("order.created")}, opts...)
+	msg := messaging.NewMessage("orders", key, []byte(payload), opts...)
+	msg.ContentType = "application/json"
+	return msg
+}
+
+func TestBrokerValidatesOnPublish(t *testing.T) {
+	registry := newFileRegistry(t)
+	register(t, registry, orderSubject, orderV1)
+	broker, memory := newValidatingBroker(t, registry, schema.BrokerConfig{})
+	ctx := context.Background()
+
+	if err := broker.PublishMessage(ctx, order("o1", `{"id":1}`)); err != nil {
+		t.Fatalf("PublishMessage: %v", err)
+	}
+	if err := broker.PublishMessage(ctx, order("o2", `{"total":1}`)); !errors.Is(err, schema.ErrInvalidPayload) {
+		t.Fatalf("PublishMessage of an invalid payload error = %v, want ErrInvalidPayload", err)
+	}
+	if err := broker.PublishMessage(ctx, order("o3", `{"id":`)); !errors.Is(err, schema.ErrInvalidPayload) {
+		t.Fatalf("PublishMessage of malformed JSON error = %v, want ErrInvalidPayload", err)
+	}
+
+	msgs := memory.Messages("orders")
+	if len(msgs) != 1 || msgs[0].Key != "o1" {
+		t.Fatalf("published %d messages, want only o1", len(msgs))
+	}
+	if version := msgs[0].Header(messaging.HeaderSchemaVersion); version != "1" {
+		t.Fatalf("schema version header = %q, want 1", version)
+	}
+
+	// The stamp follows the latest version.
+	register(t, registry, orderSubject, orderV2)
+	broker, memory = newValidatingBroker(t, registry, schema.BrokerConfig{})
+	if err := broker.PublishMessage(ctx, order("o1", `{"id":1,"total":2}`)); err != nil {
+		t.Fatalf("PublishMessage: %v", err)
+	}
+	if version := memory.Messages("orders")[0].Header(messaging.HeaderSchemaVersion); version != "2" {
+		t.Fatalf("schema version header = %q, want 2", version)
+	}
+}
+
+func TestBrokerSubjectsWithoutSchema(t *testing.T) {
+	ctx := context.Background()
+
+	broker, memory := newValidatingBroker(t, newFileRegistry(t), schema.BrokerConfig{})
+	if err := broker.PublishMessage(ctx, order("o1", `not json`)); err != nil {
+		t.Fatalf("PublishMessage without a schema: %v", err)
+	}
+	if msg := memory.Messages("orders")[0]; msg.Header(messaging.HeaderSchemaVersion) != "" {
+		t.Fatalf("message without a schema stamped with version %q", msg.Header(messaging.HeaderSchemaVersion))
+	}
+
+	broker, memory = newValidatingBroker(t, newFileRegistry(t), schema.BrokerConfig{Required: true})
+	if err := broker.PublishMessage(ctx, order("o1", `{"id":1}`)); !errors.Is(err, schema.ErrNotFound) {
+		t.Fatalf("PublishMessage without a required schema error = %v, want ErrNotFound", err)
+	}
+	if n := len(memory.Messages("orders")); n != 0 {
+		t.Fatalf("published %d messages without a required schema", n)
+	}
+}
+
+func TestBrokerValidatesAsyncAndBatch(t *testing.T) {
+	registry := newFileRegistry(t)
+	register(t, registry, orderSubject, orderV1)
+	broker, memory := newValidatingBroker(t, registry, schema.BrokerConfig{})
+	ctx := context.Background()
+
+	if err := broker.PublishAsync(ctx, order("o1", `{"id":1}`)).Wait(ctx); err != nil {
+		t.Fatalf("PublishAsync: %v", err)
+	}
+	rejected := broker.PublishAsync(ctx, order("o2", `{}`))
+	if err := rejected.Wait(ctx); !errors.Is(err, schema.ErrInvalidPayload) {
+		t.Fatalf("PublishAsync of an invalid payload error = %v, want ErrInvalidPayload", err)
+	}
+	if rejected.Message().ID == "" {
+		t.Fatal("rejected future has no message ID")
+	}
+
+	err := broker.PublishBatch(ctx, []messaging.Message{
+		order("o3", `{"id":3}`),
+		order("o4", `{}`),
+		order("o5", `{"id":5}`),
+	})
+	var batchErr *messaging.BatchError
+	if !errors.As(err, &batchErr) || len(batchErr.Errs) != 3 {
+		t.Fatalf("PublishBatch error = %v, want a BatchError of 3", err)
+	}
+	if batchErr.Errs[0] != nil || !errors.Is(batchErr.Errs[1], schema.ErrInvalidPayload) || batchErr.Errs[2] != nil {
+		t.Fatalf("PublishBatch errors = %v, want only the second invalid", batchErr.Errs)
+	}
+	if err := broker.Flush(ctx); err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+
+	msgs := memory.Messages("orders")
+	var keys []string
+	for _, msg := range msgs {
+		if msg.Header(messaging.HeaderSchemaVersion) != "1" {
+			t.Fatalf("message %s not stamped", msg.Key)
+		}
+		keys = append(keys, msg.Key)
+	}
+	if len(keys) != 3 || keys[0] != "o1" || keys[1] != "o3" || keys[2] != "o5" {
+		t.Fatalf("published %v, want o1, o3 and o5", keys)
+	}
+}
+
+// recorder records the keys of the messages it handles.
+type recorder struct {
+	mu   sync.Mutex
+	keys []string
+}
+
+func (r *recorder) handle(ctx context.Context, msg messaging.Message) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.keys = append(r.keys, msg.Key)
+	return nil
+}
+
+func (r *recorder) handled() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string(nil), r.keys...)
+}
+
+func TestBrokerValidatesOnConsume(t *testing.T) {
+	registry := newFileRegistry(t)
+	register(t, registry, orderSubject, orderV1)
+	register(t, registry, orderSubject, orderV2)
+	broker, memory := newValidatingBroker(t, registry, schema.BrokerConfig{})
+	ctx := context.Background()
+
+	var handled recorder
+	if err := broker.Subscribe(ctx, "orders", "svc", handled.handle, messaging.WithDeadLetterTopic("")); err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+	// Published around the validation, as another producer could.
+	for _, msg := range []messaging.Message{
+		// Valid for the version it was stamped with, not the latest.
+		order("v1", `{"id":1}`, messaging.WithSchemaVersion("1")),
+		order("stamped-invalid", `{"total":1}`, messaging.WithSchemaVersion("1")),
+		// Unstamped messages are checked against the latest version.
+		order("latest-invalid", `{"id":1}`),
+		order("bad-version", `{"id":1}`, messaging.WithSchemaVersion("one")),
+		order("missing-version", `{"id":1}`, messaging.WithSchemaVersion("9")),
+		order("latest", `{"id":1,"total":2}`),
+	} {
+		if err := memory.PublishMessage(ctx, msg); err != nil {
+			t.Fatalf("PublishMessage: %v", err)
+		}
+	}
+
+	for _, key := range []string{"stamped-invalid", "latest-invalid", "bad-version"} {
+		messagingtest.ExpectMessage(t, memory, "orders.svc.dlt", messagingtest.HasKey(key), 2*time.Second)
+	}
+	// A version missing from the registry is handled unvalidated, as
+	// schemas are not required.
+	want := []string{"v1", "missing-version", "latest"}
+	deadline := time.Now().Add(2 * time.Second)
+	for len(handled.handled()) < len(want) && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	keys := handled.handled()
+	if len(keys) != len(want) {
+		t.Fatalf("handled %v, want %v", keys, want)
+	}
+	for i := range want {
+		if keys[i] != want[i] {
+			t.Fatalf("handled %v, want %v", keys, want)
+		}
+	}
+}
+
+func TestBrokerValidatesRetriedMessagesAgainstTheOriginalTopic(t *testing.T) {
+	registry := newFileRegistry(t)
+	register(t, registry, orderSubject, orderV1)
+	// Required, so a retry topic subject would fail.
+	broker, memory := newValidatingBroker(t, registry, schema.BrokerConfig{Required: true})
+	ctx := context.Background()
+
+	var handled recorder
+	err := broker.Subscribe(ctx, "orders", "svc", handled.handle,
+		messaging.WithRetryTopics(time.Millisecond),
+		messaging.WithDeadLetterTopic(""),
+	)
+	if err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+	retried := order("o1", `{"id":1}`, messaging.WithHeader(messaging.HeaderOriginalTopic, "orders"))
+	retried.Topic = "orders.svc.retry.1"
+	if err := memory.PublishMessage(ctx, retried); err != nil {
+		t.Fatalf("PublishMessage: %v", err)
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for len(handled.handled()) == 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if keys := handled.handled(); len(keys) != 1 {
+		t.Fatalf("handled %v, want the retried message validated against orders", keys)
+	}
+}
@@ -0,0 +1,163 @@
+package schema
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// fileExtensions maps each format to the extension of its files.
+var fileExtensions = map[Format]string{
+	FormatJSON:     ".json",
+	FormatAvro:     ".avsc",
+	FormatProtobuf: ".proto",
+}
+
+// FileRegistry keeps schemas in a local directory, one subdirectory per
+// subject and one file per version, e.g. "orders-order.created/1.json".
+// Files can be committed alongside the code that produces the events.
+type FileRegistry struct {
+	dir string
+	mu  sync.Mutex
+}
+
+// NewFileRegistry uses dir, creating it if needed.
+func NewFileRegistry(dir string) (*FileRegistry, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("error creating schema directory %s: %w", dir, err)
+	}
+	return &FileRegistry{dir: dir}, nil
+}
+
+// Register writes definition as the next version of subject unless it
+// matches the latest one, see Registry.
+func (r *FileRegistry) Register(ctx context.Context, subject string, format Format, definition string) (Schema, error) {
+	extension, ok := fileExtensions[format]
+	if !ok {
+		return Schema{}, fmt.Errorf("%w: %s", ErrUnsupportedFormat, format)
+	}
+	if err := checkSubject(subject); err != nil {
+		return Schema{}, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	latest, err := r.Latest(ctx, subject)
+	switch {
+	case err == nil:
+		if latest.Format == format && normalize(format, latest.Definition) == normalize(format, definition) {
+			return latest, nil
+		}
+	case !errors.Is(err, ErrNotFound):
+		return Schema{}, err
+	}
+
+	s := Schema{Subject: subject, Version: latest.Version + 1, Format: format, Definition: definition}
+	if err := os.MkdirAll(filepath.Join(r.dir, subject), 0o755); err != nil {
+		return Schema{}, fmt.Errorf("error creating schema directory for %s: %w", subject, err)
+	}
+	// O_EXCL keeps two processes from writing the same version.
+	path := filepath.Join(r.dir, subject, strconv.Itoa(s.Version)+extension)
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		return Schema{}, fmt.Errorf("error registering schema %s version %d: %w", subject, s.Version, err)
+	}
+	if _, err := file.WriteString(definition); err != nil {
+		file.Close()
+		return Schema{}, fmt.Errorf("error writing schema %s version %d: %w", subject, s.Version, err)
+	}
+	if err := file.Close(); err != nil {
+		return Schema{}, fmt.Errorf("error writing schema %s version %d: %w", subject, s.Version, err)
+	}
+	return s, nil
+}
+
+// Latest returns the highest version of subject, see Registry.
+func (r *FileRegistry) Latest(ctx context.Context, subject string) (Schema, error) {
+	versions, err := r.versions(subject)
+	if err != nil {
+		return Schema{}, err
+	}
+	if len(versions) == 0 {
+		return Schema{}, fmt.Errorf("%w: subject %s", ErrNotFound, subject)
+	}
+	return r.read(subject, versions[len(versions)-1])
+}
+
+// Version returns the given version of subject, see Registry.
+func (r *FileRegistry) Version(ctx context.Context, subject string, version int) (Schema, error) {
+	versions, err := r.versions(subject)
+	if err != nil {
+		return Schema{}, err
+	}
+	for _, v := range versions {
+		if v.version == version {
+			return r.read(subject, v)
+		}
+	}
+	return Schema{}, fmt.Errorf("%w: subject %s version %d", ErrNotFound, subject, version)
+}
+
+type fileVersion struct {
+	version int
+	format  Format
+	name    string
+}
+
+// versions lists the version files of subject in ascending order.
+func (r *FileRegistry) versions(subject string) ([]fileVersion, error) {
+	if err := checkSubject(subject); err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(filepath.Join(r.dir, subject))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading schemas of %s: %w", subject, err)
+	}
+
+	var versions []fileVersion
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		extension := filepath.Ext(entry.Name())
+		version, err := strconv.Atoi(strings.TrimSuffix(entry.Name(), extension))
+		if err != nil || version < 1 {
+			continue
+		}
+		for format, ext := range fileExtensions {
+			if ext == extension {
+				versions = append(versions, fileVersion{version: version, format: format, name: entry.Name()})
+			}
+		}
+	}
+	sort.Slice(versions, func(i, j int) bool {
+		return versions[i].version < versions[j].version
+	})
+	return versions, nil
+}
+
+func (r *FileRegistry) read(subject string, v fileVersion) (Schema, error) {
+	definition, err := os.ReadFile(filepath.Join(r.dir, subject, v.name))
+	if err != nil {
+		return Schema{}, fmt.Errorf("error reading schema %s version %d: %w", subject, v.version, err)
+	}
+	return Schema{Subject: subject, Version: v.version, Format: v.format, Definition: string(definition)}, nil
+}
+
+// checkSubject rejects subjects that are not a single path element.
+func checkSubject(subject string) error {
+	if subject == "" || subject == "." || subject == ".." || strings.ContainsAny(subject, `/\`) {
+		return fmt.Errorf("invalid schema subject %q", subject)
+	}
+	return nil
+}
@@ -0,0 +1,97 @@
+package schema_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mauriciomartinezc/real-estate-mc-common/messaging/schema"
+)
+
+func TestFileRegistryRegister(t *testing.T) {
+	dir := t.TempDir()
+	registry, err := schema.NewFileRegistry(dir)
+	if err != nil {
+		t.Fatalf("NewFileRegistry: %v", err)
+	}
+	ctx := context.Background()
+
+	first, err := registry.Register(ctx, orderSubject, schema.FormatJSON, orderV1)
+	want := schema.Schema{Subject: orderSubject, Version: 1, Format: schema.FormatJSON, Definition: orderV1}
+	if err != nil || first != want {
+		t.Fatalf("Register = %+v, %v, want %+v", first, err, want)
+	}
+	// The same definition, formatted differently, is not a new version.
+	reformatted := "{\n  \"type\": \"object\",\n  \"required\": [\"id\"]\n}"
+	if again, err := registry.Register(ctx, orderSubject, schema.FormatJSON, reformatted); err != nil || again != first {
+		t.Fatalf("Register of a reformatted definition = %+v, %v, want %+v", again, err, first)
+	}
+	second, err := registry.Register(ctx, orderSubject, schema.FormatJSON, orderV2)
+	if err != nil || second.Version != 2 {
+		t.Fatalf("Register of a new definition = %+v, %v, want version 2", second, err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, orderSubject, "2.json")); err != nil {
+		t.Fatalf("version 2 not written: %v", err)
+	}
+
+	avro, err := registry.Register(ctx, "users-value", schema.FormatAvro, `"string"`)
+	if err != nil || avro.Version != 1 || avro.Format != schema.FormatAvro {
+		t.Fatalf("Register of an Avro schema = %+v, %v, want version 1 in Avro", avro, err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "users-value", "1.avsc")); err != nil {
+		t.Fatalf("Avro schema not written: %v", err)
+	}
+
+	if _, err := registry.Register(ctx, orderSubject, schema.Format("XML"), "<a/>"); !errors.Is(err, schema.ErrUnsupportedFormat) {
+		t.Fatalf("Register of an unknown format error = %v, want ErrUnsupportedFormat", err)
+	}
+	for _, subject := range []string{"", "..", "a/b", `a\b`} {
+		if _, err := registry.Register(ctx, subject, schema.FormatJSON, orderV1); err == nil {
+			t.Fatalf("Register accepted the subject %q", subject)
+		}
+	}
+}
+
+func TestFileRegistryLatestAndVersion(t *testing.T) {
+	dir := t.TempDir()
+	registry, err := schema.NewFileRegistry(dir)
+	if err != nil {
+		t.Fatalf("NewFileRegistry: %v", err)
+	}
+	ctx := context.Background()
+	register(t, registry, orderSubject, orderV1)
+	register(t, registry, orderSubject, orderV2)
+
+	// Versions are ordered numerically, and unrelated files are ignored.
+	for name, content := range map[string]string{"10.json": `{"type":"object"}`, "notes.txt": "", "draft.json": "{}"} {
+		if err := os.WriteFile(filepath.Join(dir, orderSubject, name), []byte(content), 0o644); err != nil {
+			t.Fatalf("WriteFile: %v", err)
+		}
+	}
+	latest, err := registry.Latest(ctx, orderSubject)
+	if err != nil || latest.Version != 10 || latest.Definition != `{"type":"object"}` {
+		t.Fatalf("Latest = %+v, %v, want version 10", latest, err)
+	}
+	v2, err := registry.Version(ctx, orderSubject, 2)
+	if err != nil || v2.Definition != orderV2 || v2.Format != schema.FormatJSON {
+		t.Fatalf("Version 2 = %+v, %v", v2, err)
+	}
+
+	if _, err := registry.Version(ctx, orderSubject, 3); !errors.Is(err, schema.ErrNotFound) {
+		t.Fatalf("Version of a missing version error = %v, want ErrNotFound", err)
+	}
+	if _, err := registry.Latest(ctx, "missing-value"); !errors.Is(err, schema.ErrNotFound) {
+		t.Fatalf("Latest of a missing subject error = %v, want ErrNotFound", err)
+	}
+
+	// Another registry on the same directory sees the same versions.
+	other, err := schema.NewFileRegistry(dir)
+	if err != nil {
+		t.Fatalf("NewFileRegistry: %v", err)
+	}
+	if again, err := other.Latest(ctx, orderSubject); err != nil || again != latest {
+		t.Fatalf("Latest from another registry = %+v, %v, want %+v", again, err, latest)
+	}
+}
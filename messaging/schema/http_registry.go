@@ -0,0 +1,216 @@
+package schema
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	registryContentType    = "application/vnd.schemaregistry.v1+json"
+	defaultRegistryTimeout = 10 * time.Second
+)
+
+// Error codes of the Confluent schema registry API that mean not found.
+const (
+	errorSubjectNotFound = 40401
+	errorVersionNotFound = 40402
+	errorSchemaNotFound  = 40403
+)
+
+// HTTPRegistryConfig configures an HTTPRegistry.
+type HTTPRegistryConfig struct {
+	// URL is the base URL of the registry, e.g. "http://localhost:8081".
+	URL string
+	// Username and Password enable basic authentication, as used by
+	// Confluent Cloud API keys.
+	Username string
+	Password string
+	// Client defaults to an http.Client with a 10s timeout.
+	Client *http.Client
+}
+
+// HTTPRegistry is a client of the Confluent schema registry REST API,
+// also implemented by Redpanda, Apicurio and Karapace. Versions are
+// immutable, so they are cached after the first lookup.
+type HTTPRegistry struct {
+	baseURL  string
+	username string
+	password string
+	client   *http.Client
+
+	mu       sync.RWMutex
+	versions map[string]Schema // by subject and version
+}
+
+// NewHTTPRegistry creates a client for the registry at config.URL.
+func NewHTTPRegistry(config HTTPRegistryConfig) (*HTTPRegistry, error) {
+	if _, err := url.ParseRequestURI(config.URL); err != nil {
+		return nil, fmt.Errorf("invalid schema registry URL %q: %w", config.URL, err)
+	}
+	client := config.Client
+	if client == nil {
+		client = &http.Client{Timeout: defaultRegistryTimeout}
+	}
+	return &HTTPRegistry{
+		baseURL:  strings.TrimRight(config.URL, "/"),
+		username: config.Username,
+		password: config.Password,
+		client:   client,
+		versions: make(map[string]Schema),
+	}, nil
+}
+
+// registrySchema is the schema object of the registry API. SchemaType is
+// omitted for Avro.
+type registrySchema struct {
+	Subject    string `json:"subject,omitempty"`
+	ID         int    `json:"id,omitempty"`
+	Version    int    `json:"version,omitempty"`
+	SchemaType string `json:"schemaType,omitempty"`
+	Schema     string `json:"schema"`
+}
+
+func (s registrySchema) toSchema() Schema {
+	format := Format(s.SchemaType)
+	if format == "" {
+		format = FormatAvro
+	}
+	return Schema{Subject: s.Subject, Version: s.Version, ID: s.ID, Format: format, Definition: s.Schema}
+}
+
+type registryError struct {
+	ErrorCode int    `json:"error_code"`
+	Message   string `json:"message"`
+}
+
+// Register posts definition to subject and looks up the resulting version,
+// see Registry. The registry returns the existing version when the
+// definition is already registered.
+func (r *HTTPRegistry) Register(ctx context.Context, subject string, format Format, definition string) (Schema, error) {
+	request := registrySchema{Schema: definition}
+	if format != FormatAvro {
+		request.SchemaType = string(format)
+	}
+	path := "/subjects/" + url.PathEscape(subject)
+
+	var registered struct {
+		ID int `json:"id"`
+	}
+	if err := r.do(ctx, http.MethodPost, path+"/versions", request, &registered); err != nil {
+		return Schema{}, fmt.Errorf("error registering schema %s: %w", subject, err)
+	}
+	var found registrySchema
+	if err := r.do(ctx, http.MethodPost, path, request, &found); err != nil {
+		return Schema{}, fmt.Errorf("error looking up schema %s: %w", subject, err)
+	}
+	s := found.toSchema()
+	s.Subject = subject
+	if s.ID == 0 {
+		s.ID = registered.ID
+	}
+	r.remember(s)
+	return s, nil
+}
+
+// Latest returns the newest version of subject, see Registry. It is not
+// cached.
+func (r *HTTPRegistry) Latest(ctx context.Context, subject string) (Schema, error) {
+	return r.get(ctx, subject, "latest")
+}
+
+// Version returns the given version of subject, see Registry.
+func (r *HTTPRegistry) Version(ctx context.Context, subject string, version int) (Schema, error) {
+	r.mu.RLock()
+	s, found := r.versions[versionKey(subject, version)]
+	r.mu.RUnlock()
+	if found {
+		return s, nil
+	}
+	return r.get(ctx, subject, strconv.Itoa(version))
+}
+
+func (r *HTTPRegistry) get(ctx context.Context, subject, version string) (Schema, error) {
+	var found registrySchema
+	path := "/subjects/" + url.PathEscape(subject) + "/versions/" + version
+	if err := r.do(ctx, http.MethodGet, path, nil, &found); err != nil {
+		return Schema{}, fmt.Errorf("error getting schema %s version %s: %w", subject, version, err)
+	}
+	s := found.toSchema()
+	s.Subject = subject
+	r.remember(s)
+	return s, nil
+}
+
+func (r *HTTPRegistry) remember(s Schema) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.versions[versionKey(s.Subject, s.Version)] = s
+}
+
+func versionKey(subject string, version int) string {
+	return subject + "\x00" + strconv.Itoa(version)
+}
+
+// do sends a request with an optional JSON body and decodes the JSON
+// response into out. Not found errors wrap ErrNotFound.
+func (r *HTTPRegistry) do(ctx context.Context, method, path string, body, out interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, r.baseURL+path, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", registryContentType)
+	if body != nil {
+		req.Header.Set("Content-Type", registryContentType)
+	}
+	if r.username != "" {
+		req.SetBasicAuth(r.username, r.password)
+	}
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 10<<20))
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		var registryErr registryError
+		_ = json.Unmarshal(data, &registryErr)
+		message := registryErr.Message
+		if message == "" {
+			message = http.StatusText(resp.StatusCode)
+		}
+		switch {
+		case registryErr.ErrorCode == errorSubjectNotFound,
+			registryErr.ErrorCode == errorVersionNotFound,
+			registryErr.ErrorCode == errorSchemaNotFound,
+			resp.StatusCode == http.StatusNotFound:
+			return fmt.Errorf("%w: %s", ErrNotFound, message)
+		}
+		return fmt.Errorf("schema registry returned %d (code %d): %s", resp.StatusCode, registryErr.ErrorCode, message)
+	}
+	if err := json.Unmarshal(data, out); err != nil {
+		return fmt.Errorf("error decoding schema registry response: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,256 @@
+package schema_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/mauriciomartinezc/real-estate-mc-common/messaging/schema"
+)
+
+const registryContentType = "application/vnd.schemaregistry.v1+json"
+
+type stubSchema struct {
+	Subject    string `json:"subject"`
+	ID         int    `json:"id"`
+	Version    int    `json:"version"`
+	SchemaType string `json:"schemaType,omitempty"`
+	Schema     string `json:"schema"`
+}
+
+// stubRegistry implements the parts of the Confluent schema registry API
+// used by HTTPRegistry.
+type stubRegistry struct {
+	t        *testing.T
+	username string
+	password string
+
+	mu       sync.Mutex
+	subjects map[string][]stubSchema
+	nextID   int
+	gets     int
+}
+
+// newStubRegistry starts a stub registry requiring the given credentials,
+// if any, and returns its URL.
+func newStubRegistry(t *testing.T, username, password string) (*stubRegistry, string) {
+	stub := &stubRegistry{t: t, username: username, password: password, subjects: make(map[string][]stubSchema), nextID: 100}
+	server := httptest.NewServer(stub)
+	t.Cleanup(server.Close)
+	return stub, server.URL
+}
+
+func newHTTPRegistry(t *testing.T, url, username, password string) *schema.HTTPRegistry {
+	t.Helper()
+	registry, err := schema.NewHTTPRegistry(schema.HTTPRegistryConfig{URL: url + "/", Username: username, Password: password})
+	if err != nil {
+		t.Fatalf("NewHTTPRegistry: %v", err)
+	}
+	return registry
+}
+
+func (s *stubRegistry) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if username, password, _ := r.BasicAuth(); username != s.username || password != s.password {
+		s.fail(w, http.StatusUnauthorized, 40101, "Unauthorized")
+		return
+	}
+	if accept := r.Header.Get("Accept"); accept != registryContentType {
+		s.t.Errorf("Accept = %q, want %s", accept, registryContentType)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/subjects/"), "/")
+	subject := parts[0]
+	switch {
+	case r.Method == http.MethodPost && len(parts) == 2 && parts[1] == "versions":
+		body := s.decode(r)
+		if existing, found := s.lookup(subject, body); found {
+			s.reply(w, map[string]int{"id": existing.ID})
+			return
+		}
+		s.nextID++
+		body.Subject, body.ID, body.Version = subject, s.nextID, len(s.subjects[subject])+1
+		s.subjects[subject] = append(s.subjects[subject], body)
+		s.reply(w, map[string]int{"id": body.ID})
+	case r.Method == http.MethodPost && len(parts) == 1:
+		existing, found := s.lookup(subject, s.decode(r))
+		if !found {
+			s.fail(w, http.StatusNotFound, 40403, "Schema not found")
+			return
+		}
+		s.reply(w, existing)
+	case r.Method == http.MethodGet && len(parts) == 3 && parts[1] == "versions":
+		s.gets++
+		versions := s.subjects[subject]
+		if len(versions) == 0 {
+			s.fail(w, http.StatusNotFound, 40401, "Subject '"+subject+"' not found.")
+			return
+		}
+		if parts[2] == "latest" {
+			s.reply(w, versions[len(versions)-1])
+			return
+		}
+		version, err := strconv.Atoi(parts[2])
+		if err != nil || version < 1 || version > len(versions) {
+			s.fail(w, http.StatusNotFound, 40402, "Version not found.")
+			return
+		}
+		s.reply(w, versions[version-1])
+	default:
+		s.fail(w, http.StatusInternalServerError, 50001, "unexpected "+r.Method+" "+r.URL.Path)
+	}
+}
+
+func (s *stubRegistry) decode(r *http.Request) stubSchema {
+	if contentType := r.Header.Get("Content-Type"); contentType != registryContentType {
+		s.t.Errorf("Content-Type = %q, want %s", contentType, registryContentType)
+	}
+	var body stubSchema
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		s.t.Errorf("decoding request: %v", err)
+	}
+	return body
+}
+
+func (s *stubRegistry) lookup(subject string, body stubSchema) (stubSchema, bool) {
+	for _, existing := range s.subjects[subject] {
+		if existing.Schema == body.Schema && existing.SchemaType == body.SchemaType {
+			return existing, true
+		}
+	}
+	return stubSchema{}, false
+}
+
+func (s *stubRegistry) reply(w http.ResponseWriter, body interface{}) {
+	w.Header().Set("Content-Type", registryContentType)
+	_ = json.NewEncoder(w).Encode(body)
+}
+
+func (s *stubRegistry) fail(w http.ResponseWriter, status, code int, message string) {
+	w.Header().Set("Content-Type", registryContentType)
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{"error_code": code, "message": message})
+}
+
+func (s *stubRegistry) getCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.gets
+}
+
+const (
+	orderV1 = `{"type":"object","required":["id"]}`
+	orderV2 = `{"type":"object","required":["id","total"]}`
+)
+
+func TestHTTPRegistryRegister(t *testing.T) {
+	_, url := newStubRegistry(t, "", "")
+	registry := newHTTPRegistry(t, url, "", "")
+	ctx := context.Background()
+
+	first, err := registry.Register(ctx, "orders-value", schema.FormatJSON, orderV1)
+	if err != nil {
+		t.Fatalf("Register: %v", err)
+	}
+	want := schema.Schema{Subject: "orders-value", Version: 1, ID: 101, Format: schema.FormatJSON, Definition: orderV1}
+	if first != want {
+		t.Fatalf("Register = %+v, want %+v", first, want)
+	}
+
+	again, err := registry.Register(ctx, "orders-value", schema.FormatJSON, orderV1)
+	if err != nil || again != first {
+		t.Fatalf("Register of the same definition = %+v, %v, want %+v", again, err, first)
+	}
+	second, err := registry.Register(ctx, "orders-value", schema.FormatJSON, orderV2)
+	if err != nil || second.Version != 2 || second.ID != 102 {
+		t.Fatalf("Register of a new definition = %+v, %v, want version 2 with ID 102", second, err)
+	}
+
+	avro, err := registry.Register(ctx, "users-value", schema.FormatAvro, `"string"`)
+	if err != nil || avro.Format != schema.FormatAvro || avro.Version != 1 {
+		t.Fatalf("Register of an Avro schema = %+v, %v, want version 1 in Avro", avro, err)
+	}
+}
+
+func TestHTTPRegistryLatestAndVersion(t *testing.T) {
+	stub, url := newStubRegistry(t, "", "")
+	ctx := context.Background()
+	writer := newHTTPRegistry(t, url, "", "")
+	for _, definition := range []string{orderV1, orderV2} {
+		if _, err := writer.Register(ctx, "orders-value", schema.FormatJSON, definition); err != nil {
+			t.Fatalf("Register: %v", err)
+		}
+	}
+
+	// A client that did not register the schemas fetches them.
+	registry := newHTTPRegistry(t, url, "", "")
+	latest, err := registry.Latest(ctx, "orders-value")
+	if err != nil || latest.Version != 2 || latest.Definition != orderV2 || latest.ID != 102 {
+		t.Fatalf("Latest = %+v, %v, want version 2", latest, err)
+	}
+
+	// Versions are immutable, so each is fetched once.
+	gets := stub.getCount()
+	for i := 0; i < 3; i++ {
+		v1, err := registry.Version(ctx, "orders-value", 1)
+		if err != nil || v1.Definition != orderV1 || v1.Format != schema.FormatJSON || v1.Subject != "orders-value" {
+			t.Fatalf("Version 1 = %+v, %v", v1, err)
+		}
+		if _, err := registry.Version(ctx, "orders-value", 2); err != nil {
+			t.Fatalf("Version 2: %v", err)
+		}
+	}
+	if n := stub.getCount() - gets; n != 1 {
+		t.Fatalf("Version made %d requests, want 1 for version 1 and none for the version Latest returned", n)
+	}
+
+	// Latest is not cached.
+	if _, err := writer.Register(ctx, "orders-value", schema.FormatJSON, `{"type":"object"}`); err != nil {
+		t.Fatalf("Register: %v", err)
+	}
+	if latest, err := registry.Latest(ctx, "orders-value"); err != nil || latest.Version != 3 {
+		t.Fatalf("Latest after a new version = %+v, %v, want version 3", latest, err)
+	}
+}
+
+func TestHTTPRegistryNotFound(t *testing.T) {
+	_, url := newStubRegistry(t, "", "")
+	registry := newHTTPRegistry(t, url, "", "")
+	ctx := context.Background()
+
+	if _, err := registry.Latest(ctx, "missing-value"); !errors.Is(err, schema.ErrNotFound) {
+		t.Fatalf("Latest of a missing subject error = %v, want ErrNotFound", err)
+	}
+	if _, err := registry.Register(ctx, "orders-value", schema.FormatJSON, orderV1); err != nil {
+		t.Fatalf("Register: %v", err)
+	}
+	if _, err := registry.Version(ctx, "orders-value", 7); !errors.Is(err, schema.ErrNotFound) {
+		t.Fatalf("Version of a missing version error = %v, want ErrNotFound", err)
+	}
+}
+
+func TestHTTPRegistryBasicAuth(t *testing.T) {
+	_, url := newStubRegistry(t, "key", "secret")
+	ctx := context.Background()
+
+	if _, err := newHTTPRegistry(t, url, "key", "secret").Register(ctx, "orders-value", schema.FormatJSON, orderV1); err != nil {
+		t.Fatalf("Register with credentials: %v", err)
+	}
+	_, err := newHTTPRegistry(t, url, "key", "wrong").Latest(ctx, "orders-value")
+	if err == nil || errors.Is(err, schema.ErrNotFound) || !strings.Contains(err.Error(), "401") {
+		t.Fatalf("Latest with wrong credentials error = %v, want a 401 error", err)
+	}
+}
+
+func TestNewHTTPRegistryRejectsInvalidURL(t *testing.T) {
+	if _, err := schema.NewHTTPRegistry(schema.HTTPRegistryConfig{URL: "registry"}); err == nil {
+		t.Fatal("NewHTTPRegistry accepted a relative URL")
+	}
+}
@@ -0,0 +1,129 @@
+// Package schema validates event payloads against schemas kept in a
+// registry, either a local directory (FileRegistry) or a Confluent
+// compatible schema registry (HTTPRegistry), and stamps the schema version
+// on published messages.
+package schema
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	neturl "net/url"
+	"strings"
+
+	"github.com/santhosh-tekuri/jsonschema/v5"
+)
+
+var (
+	// ErrNotFound is returned when a subject or version does not exist.
+	ErrNotFound = errors.New("schema not found")
+	// ErrUnsupportedFormat is returned by Compile for schemas that cannot
+	// be validated, such as Avro and Protobuf.
+	ErrUnsupportedFormat = errors.New("unsupported schema format")
+	// ErrInvalidPayload is wrapped by validation failures.
+	ErrInvalidPayload = errors.New("payload does not match schema")
+)
+
+// Format is the language of a schema definition, named as in the Confluent
+// schema registry.
+type Format string
+
+const (
+	FormatJSON     Format = "JSON"
+	FormatAvro     Format = "AVRO"
+	FormatProtobuf Format = "PROTOBUF"
+)
+
+// Schema is a registered version of a subject.
+type Schema struct {
+	Subject string
+	Version int
+	// ID is the global ID assigned by the registry; FileRegistry leaves it
+	// at zero.
+	ID         int
+	Format     Format
+	Definition string
+}
+
+// Registry stores the schema versions of each subject.
+type Registry interface {
+	// Register adds definition as the next version of subject and returns
+	// it, or returns the existing version if the definition is already
+	// registered.
+	Register(ctx context.Context, subject string, format Format, definition string) (Schema, error)
+	// Latest returns the newest version of subject.
+	Latest(ctx context.Context, subject string) (Schema, error)
+	// Version returns the given version of subject.
+	Version(ctx context.Context, subject string, version int) (Schema, error)
+}
+
+// SubjectName returns the subject of the events of eventType on topic:
+// "<topic>-<eventType>", or "<topic>-value" for messages without a type as
+// in Confluent's topic name strategy.
+func SubjectName(topic, eventType string) string {
+	if eventType == "" {
+		return topic + "-value"
+	}
+	return topic + "-" + eventType
+}
+
+// Validator checks payloads against a compiled schema.
+type Validator interface {
+	Validate(payload []byte) error
+}
+
+type jsonValidator struct {
+	subject string
+	version int
+	schema  *jsonschema.Schema
+}
+
+// Compile prepares s for validation. Only JSON Schema is supported; other
+// formats return ErrUnsupportedFormat. References to other documents are
+// not resolved.
+func Compile(s Schema) (Validator, error) {
+	if s.Format != FormatJSON {
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedFormat, s.Format)
+	}
+	url := fmt.Sprintf("registry:///%s/%d", neturl.PathEscape(s.Subject), s.Version)
+	compiler := jsonschema.NewCompiler()
+	compiler.LoadURL = func(ref string) (io.ReadCloser, error) {
+		return nil, fmt.Errorf("external schema reference %s is not supported", ref)
+	}
+	if err := compiler.AddResource(url, strings.NewReader(s.Definition)); err != nil {
+		return nil, fmt.Errorf("error parsing schema %s version %d: %w", s.Subject, s.Version, err)
+	}
+	compiled, err := compiler.Compile(url)
+	if err != nil {
+		return nil, fmt.Errorf("error compiling schema %s version %d: %w", s.Subject, s.Version, err)
+	}
+	return &jsonValidator{subject: s.Subject, version: s.Version, schema: compiled}, nil
+}
+
+func (v *jsonValidator) Validate(payload []byte) error {
+	decoder := json.NewDecoder(bytes.NewReader(payload))
+	decoder.UseNumber()
+	var document interface{}
+	if err := decoder.Decode(&document); err != nil {
+		return fmt.Errorf("%w %s version %d: invalid JSON: %v", ErrInvalidPayload, v.subject, v.version, err)
+	}
+	if err := v.schema.Validate(document); err != nil {
+		return fmt.Errorf("%w %s version %d: %v", ErrInvalidPayload, v.subject, v.version, err)
+	}
+	return nil
+}
+
+// normalize makes equivalent definitions compare equal: JSON is compacted
+// and anything else trimmed.
+func normalize(format Format, definition string) string {
+	if format == FormatJSON || format == "" {
+		var compact bytes.Buffer
+		if json.Compact(&compact, []byte(definition)) == nil {
+			return compact.String()
+		}
+	}
+	return strings.TrimSpace(definition)
+}
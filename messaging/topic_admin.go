@@ -0,0 +1,171 @@
+package messaging
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/mauriciomartinezc/real-estate-mc-common/logger"
+)
+
+// ErrTopicNotFound is returned when describing a topic that does not exist.
+var ErrTopicNotFound = errors.New("topic not found")
+
+// Topic config names set by TopicSpec.
+const (
+	TopicConfigRetentionMs   = "retention.ms"
+	TopicConfigCleanupPolicy = "cleanup.policy"
+)
+
+// Drift settings that are not topic configs.
+const (
+	TopicSettingPartitions        = "partitions"
+	TopicSettingReplicationFactor = "replication.factor"
+)
+
+// TopicAdmin is implemented by brokers that can declare and inspect
+// topics. Both KafkaProvider and MemoryBroker implement it.
+type TopicAdmin interface {
+	// EnsureTopics creates the topics of specs that do not exist and
+	// compares the rest with their spec. Existing topics are never
+	// altered: their differences are logged and returned as drift.
+	EnsureTopics(ctx context.Context, specs ...TopicSpec) ([]TopicDrift, error)
+	// DescribeTopics returns the partitions, replication and configs of
+	// the named topics, failing with ErrTopicNotFound if one is missing.
+	DescribeTopics(ctx context.Context, names ...string) ([]TopicInfo, error)
+}
+
+// TopicSpec declares a topic owned by a service.
+type TopicSpec struct {
+	Name string
+	// Partitions and ReplicationFactor are required: topics are created
+	// with a version of the API that does not accept broker defaults.
+	Partitions        int
+	ReplicationFactor int
+	// Retention sets retention.ms; negative keeps messages forever and
+	// zero leaves the broker default.
+	Retention time.Duration
+	// Compacted sets cleanup.policy to compact, keeping the last message
+	// of each key instead of deleting by age.
+	Compacted bool
+	// Configs sets any other topic config, e.g. "min.insync.replicas".
+	// They take precedence over Retention and Compacted.
+	Configs map[string]string
+}
+
+// configs returns every config set by s.
+func (s TopicSpec) configs() map[string]string {
+	configs := make(map[string]string, len(s.Configs)+2)
+	switch {
+	case s.Retention < 0:
+		configs[TopicConfigRetentionMs] = "-1"
+	case s.Retention > 0:
+		configs[TopicConfigRetentionMs] = strconv.FormatInt(s.Retention.Milliseconds(), 10)
+	}
+	if s.Compacted {
+		configs[TopicConfigCleanupPolicy] = "compact"
+	}
+	for name, value := range s.Configs {
+		configs[name] = value
+	}
+	return configs
+}
+
+func (s TopicSpec) validate() error {
+	if s.Name == "" {
+		return fmt.Errorf("topic name is required")
+	}
+	if s.Partitions <= 0 {
+		return fmt.Errorf("topic %s needs at least one partition", s.Name)
+	}
+	if s.ReplicationFactor <= 0 {
+		return fmt.Errorf("topic %s needs a replication factor of at least one", s.Name)
+	}
+	return nil
+}
+
+func validateTopicSpecs(specs []TopicSpec) error {
+	seen := make(map[string]bool, len(specs))
+	for _, spec := range specs {
+		if err := spec.validate(); err != nil {
+			return err
+		}
+		if seen[spec.Name] {
+			return fmt.Errorf("topic %s is declared twice", spec.Name)
+		}
+		seen[spec.Name] = true
+	}
+	return nil
+}
+
+// TopicInfo describes an existing topic.
+type TopicInfo struct {
+	Name              string
+	Partitions        int
+	ReplicationFactor int
+	// Configs holds the topic configs, including broker defaults.
+	Configs map[string]string
+}
+
+// TopicDrift is a difference between a TopicSpec and its existing topic.
+type TopicDrift struct {
+	Topic string
+	// Setting is TopicSettingPartitions, TopicSettingReplicationFactor or
+	// the name of a topic config.
+	Setting  string
+	Declared string
+	Actual   string
+}
+
+func (d TopicDrift) String() string {
+	return fmt.Sprintf("topic %s: %s is %s, declared %s", d.Topic, d.Setting, d.Actual, d.Declared)
+}
+
+// topicDrift compares spec with the existing topic info, in a stable
+// order.
+func topicDrift(spec TopicSpec, info TopicInfo) []TopicDrift {
+	var drift []TopicDrift
+	if info.Partitions != spec.Partitions {
+		drift = append(drift, TopicDrift{
+			Topic:    spec.Name,
+			Setting:  TopicSettingPartitions,
+			Declared: strconv.Itoa(spec.Partitions),
+			Actual:   strconv.Itoa(info.Partitions),
+		})
+	}
+	if info.ReplicationFactor != spec.ReplicationFactor {
+		drift = append(drift, TopicDrift{
+			Topic:    spec.Name,
+			Setting:  TopicSettingReplicationFactor,
+			Declared: strconv.Itoa(spec.ReplicationFactor),
+			Actual:   strconv.Itoa(info.ReplicationFactor),
+		})
+	}
+
+	configs := spec.configs()
+	names := make([]string, 0, len(configs))
+	for name := range configs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if actual := info.Configs[name]; actual != configs[name] {
+			drift = append(drift, TopicDrift{Topic: spec.Name, Setting: name, Declared: configs[name], Actual: actual})
+		}
+	}
+	return drift
+}
+
+func logTopicDrift(drift []TopicDrift) {
+	for _, d := range drift {
+		logger.Warn().
+			Str("topic", d.Topic).
+			Str("setting", d.Setting).
+			Str("declared", d.Declared).
+			Str("actual", d.Actual).
+			Msg("Topic differs from its declaration")
+	}
+}
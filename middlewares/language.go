@@ -7,17 +7,24 @@ import (
 	"golang.org/x/text/language"
 )
 
+var languageMatcher = language.NewMatcher([]language.Tag{
+	language.English, // "en"
+	language.Spanish, // "es"
+})
+
+// negotiateLanguage returns the supported language that best matches an
+// Accept-Language header.
+func negotiateLanguage(acceptLanguage string) language.Tag {
+	bestMatch, _ := language.MatchStrings(languageMatcher, acceptLanguage)
+	return bestMatch
+}
+
 func LanguageHandler() echo.MiddlewareFunc {
 	bundle := i18n2.NewLocalization()
 	return func(next echo.HandlerFunc) echo.HandlerFunc {
 		return func(c echo.Context) error {
-			supportedLangs := []language.Tag{
-				language.English, // "en"
-				language.Spanish, // "es"
-			}
 			lang := c.Request().Header.Get("Accept-Language")
-			matcher := language.NewMatcher(supportedLangs)
-			bestMatch, _ := language.MatchStrings(matcher, lang)
+			bestMatch := negotiateLanguage(lang)
 
 			localize := i18n.NewLocalizer(bundle, bestMatch.String())
 
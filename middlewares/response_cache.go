@@ -0,0 +1,386 @@
+package middlewares
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/mauriciomartinezc/real-estate-mc-common/cache"
+)
+
+const (
+	defaultResponseCacheTTL     = time.Minute
+	defaultResponseCachePrefix  = "httpcache:"
+	defaultResponseCacheMaxBody = 1 << 20
+)
+
+// ResponseCacheConfig holds response cache middleware configuration
+type ResponseCacheConfig struct {
+	// Cache stores the responses.
+	Cache cache.Cache
+	// TTL applies to routes of RouteTTLs mapped to zero. Defaults to one
+	// minute.
+	TTL time.Duration
+	// RouteTTLs lists the cached routes by Echo route path, e.g.
+	// "/properties/:id", with their TTL. Routes missing from it or mapped
+	// to a negative TTL are not cached.
+	RouteTTLs map[string]time.Duration
+	// KeyPrefix is prepended to every cache key so the entries can be
+	// dropped with cache.BatchCache.DeleteByPrefix. Defaults to
+	// "httpcache:".
+	KeyPrefix string
+	// MaxBodySize is the largest response body, in bytes, that is cached.
+	// Larger responses are streamed to the client untouched. Defaults to
+	// 1MB.
+	MaxBodySize int
+	// Skipper bypasses the cache for matching requests.
+	Skipper func(c echo.Context) bool
+}
+
+// cachedResponse is what ResponseCache stores per key.
+type cachedResponse struct {
+	Status       int         `json:"status"`
+	Header       http.Header `json:"header"`
+	Body         []byte      `json:"body"`
+	ETag         string      `json:"etag"`
+	LastModified time.Time   `json:"last_modified"`
+	StoredAt     time.Time   `json:"stored_at"`
+	// Public is set for responses marked Cache-Control: public, the only
+	// ones served to requests with credentials.
+	Public bool `json:"public"`
+}
+
+// Headers that belong to a single exchange and are never replayed.
+var uncachedHeaders = []string{
+	"Set-Cookie",
+	"Date",
+	"Age",
+	"X-Cache",
+	echo.HeaderXRequestID,
+}
+
+// ResponseCache creates a middleware that caches successful GET responses
+// of the routes in config.RouteTTLs. Entries are keyed by path, query string,
+// X-Company-Id and the negotiated language, and are served with ETag and
+// Last-Modified validators so clients can revalidate with If-None-Match or
+// If-Modified-Since and receive a 304. The request directives no-store,
+// no-cache, max-age and only-if-cached are honored, and responses carrying
+// Set-Cookie or a no-store or private Cache-Control are never stored. The
+// key has nothing per user, so requests with an Authorization or Cookie
+// header only share responses marked Cache-Control: public. The X-Cache
+// response header reports HIT or MISS.
+//
+// Cache failures never fail a request; the handler is called as if the
+// entry were missing.
+func ResponseCache(config ResponseCacheConfig) echo.MiddlewareFunc {
+	store := cache.ToContextCache(config.Cache)
+	if config.TTL <= 0 {
+		config.TTL = defaultResponseCacheTTL
+	}
+	if config.KeyPrefix == "" {
+		config.KeyPrefix = defaultResponseCachePrefix
+	}
+	if config.MaxBodySize <= 0 {
+		config.MaxBodySize = defaultResponseCacheMaxBody
+	}
+
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			req := c.Request()
+			if req.Method != http.MethodGet || (config.Skipper != nil && config.Skipper(c)) {
+				return next(c)
+			}
+			ttl, found := config.RouteTTLs[c.Path()]
+			if !found || ttl < 0 {
+				return next(c)
+			}
+			if ttl == 0 {
+				ttl = config.TTL
+			}
+			credentials := hasCredentials(req)
+
+			directives := parseCacheControl(req.Header.Get("Cache-Control"))
+			if _, noStore := directives["no-store"]; noStore {
+				return next(c)
+			}
+
+			ctx := req.Context()
+			key := responseCacheKey(config.KeyPrefix, c)
+			if cached, ok := lookupResponse(ctx, store, key, directives); ok && (cached.Public || !credentials) {
+				return serveCached(c, cached)
+			}
+			if _, onlyIfCached := directives["only-if-cached"]; onlyIfCached {
+				return c.NoContent(http.StatusGatewayTimeout)
+			}
+
+			res := c.Response()
+			recorder := &responseRecorder{ResponseWriter: res.Writer, status: http.StatusOK, limit: config.MaxBodySize}
+			res.Writer = recorder
+			err := next(c)
+			res.Writer = recorder.ResponseWriter
+
+			if recorder.passthrough {
+				return err
+			}
+			if !recorder.wroteHeader {
+				// Nothing was written; let the error handler respond.
+				return err
+			}
+			if err != nil || !cacheableResponse(recorder.status, res.Header(), credentials) {
+				recorder.flush()
+				return err
+			}
+
+			cached := newCachedResponse(recorder.status, res.Header(), recorder.body.Bytes())
+			// Store even when the client went away so the next one benefits.
+			// Stored by value so caches without a codec return it to
+			// lookupResponse as is.
+			_ = store.SetContext(context.WithoutCancel(ctx), key, *cached, ttl)
+
+			setValidators(res.Header(), cached)
+			res.Header().Set("X-Cache", "MISS")
+			if notModified(req, cached) {
+				res.Status = http.StatusNotModified
+				recorder.ResponseWriter.WriteHeader(http.StatusNotModified)
+				return nil
+			}
+			recorder.flush()
+			return nil
+		}
+	}
+}
+
+// responseCacheKey builds the cache key of the request in c.
+func responseCacheKey(prefix string, c echo.Context) string {
+	req := c.Request()
+	query := req.URL.Query()
+	base, _ := negotiateLanguage(req.Header.Get("Accept-Language")).Base()
+
+	var b strings.Builder
+	b.WriteString(prefix)
+	b.WriteString(req.Method)
+	b.WriteByte(':')
+	b.WriteString(req.URL.Path)
+	if len(query) > 0 {
+		// Encode sorts by parameter name and keeps the order of the values
+		// of each, which may matter, as in ?sort=price&sort=date.
+		b.WriteByte('?')
+		b.WriteString(query.Encode())
+	}
+	b.WriteString("|company=")
+	b.WriteString(url.QueryEscape(req.Header.Get("X-Company-Id")))
+	b.WriteString("|lang=")
+	b.WriteString(base.String())
+	return b.String()
+}
+
+// lookupResponse returns the cached response for key unless the request
+// directives require a fresh one.
+func lookupResponse(ctx context.Context, store cache.ContextCache, key string, directives map[string]string) (*cachedResponse, bool) {
+	if _, noCache := directives["no-cache"]; noCache {
+		return nil, false
+	}
+	var cached cachedResponse
+	if err := store.GetContext(ctx, key, &cached); err != nil {
+		return nil, false
+	}
+	if maxAge, found := directives["max-age"]; found {
+		seconds, err := strconv.Atoi(maxAge)
+		if err != nil || time.Since(cached.StoredAt) > time.Duration(seconds)*time.Second {
+			return nil, false
+		}
+	}
+	return &cached, true
+}
+
+// serveCached writes cached, or a 304 if the client already has it.
+func serveCached(c echo.Context, cached *cachedResponse) error {
+	// Headers set by earlier middlewares for this request, e.g. CORS, win
+	// over the stored ones.
+	header := c.Response().Header()
+	for name, values := range cached.Header {
+		if _, found := header[name]; !found {
+			header[name] = append([]string(nil), values...)
+		}
+	}
+	setValidators(header, cached)
+	header.Set("Age", strconv.Itoa(int(time.Since(cached.StoredAt).Seconds())))
+	header.Set("X-Cache", "HIT")
+
+	if notModified(c.Request(), cached) {
+		return c.NoContent(http.StatusNotModified)
+	}
+	c.Response().WriteHeader(cached.Status)
+	_, err := c.Response().Write(cached.Body)
+	return err
+}
+
+func newCachedResponse(status int, header http.Header, body []byte) *cachedResponse {
+	now := time.Now().UTC()
+	cached := &cachedResponse{
+		Status:       status,
+		Header:       header.Clone(),
+		Body:         append([]byte(nil), body...),
+		ETag:         header.Get("ETag"),
+		LastModified: now.Truncate(time.Second),
+		StoredAt:     now,
+	}
+	for _, name := range uncachedHeaders {
+		cached.Header.Del(name)
+	}
+	_, cached.Public = parseCacheControl(header.Get("Cache-Control"))["public"]
+	if cached.ETag == "" {
+		sum := sha256.Sum256(body)
+		cached.ETag = `"` + hex.EncodeToString(sum[:16]) + `"`
+	}
+	if lastModified, err := http.ParseTime(header.Get("Last-Modified")); err == nil {
+		cached.LastModified = lastModified
+	}
+	return cached
+}
+
+func setValidators(header http.Header, cached *cachedResponse) {
+	header.Set("ETag", cached.ETag)
+	header.Set("Last-Modified", cached.LastModified.UTC().Format(http.TimeFormat))
+	addVary(header, "Accept-Language", "X-Company-Id")
+}
+
+// addVary merges tokens into the Vary tokens the handler may have set, as
+// a single header value without duplicates.
+func addVary(header http.Header, tokens ...string) {
+	var merged []string
+	seen := make(map[string]bool)
+	for _, token := range append(strings.Split(strings.Join(header.Values("Vary"), ","), ","), tokens...) {
+		token = strings.TrimSpace(token)
+		if token == "" || seen[strings.ToLower(token)] {
+			continue
+		}
+		seen[strings.ToLower(token)] = true
+		merged = append(merged, token)
+	}
+	header.Set("Vary", strings.Join(merged, ", "))
+}
+
+// cacheableResponse reports whether a response may be stored. Responses
+// to requests with credentials must be marked public.
+func cacheableResponse(status int, header http.Header, credentials bool) bool {
+	if status != http.StatusOK || header.Get("Set-Cookie") != "" {
+		return false
+	}
+	directives := parseCacheControl(header.Get("Cache-Control"))
+	_, noStore := directives["no-store"]
+	_, private := directives["private"]
+	_, public := directives["public"]
+	return !noStore && !private && (public || !credentials)
+}
+
+// hasCredentials reports whether req may get a response personal to its
+// user.
+func hasCredentials(req *http.Request) bool {
+	return req.Header.Get(echo.HeaderAuthorization) != "" || req.Header.Get("Cookie") != ""
+}
+
+// notModified evaluates If-None-Match, or If-Modified-Since when no entity
+// tags were sent.
+func notModified(req *http.Request, cached *cachedResponse) bool {
+	if ifNoneMatch := req.Header.Get("If-None-Match"); ifNoneMatch != "" {
+		for _, tag := range strings.Split(ifNoneMatch, ",") {
+			tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+			if tag == "*" || tag == strings.TrimPrefix(cached.ETag, "W/") {
+				return true
+			}
+		}
+		return false
+	}
+	since, err := http.ParseTime(req.Header.Get("If-Modified-Since"))
+	return err == nil && !cached.LastModified.After(since)
+}
+
+// parseCacheControl splits a Cache-Control header into lower-cased
+// directives and their unquoted values.
+func parseCacheControl(header string) map[string]string {
+	directives := make(map[string]string)
+	for _, part := range strings.Split(header, ",") {
+		name, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+		if name == "" {
+			continue
+		}
+		directives[strings.ToLower(name)] = strings.Trim(value, `"`)
+	}
+	return directives
+}
+
+// responseRecorder buffers a response so it can be stored and given
+// validators before reaching the client. Responses outgrowing limit, or
+// flushed by the handler, are passed through instead.
+type responseRecorder struct {
+	http.ResponseWriter
+	status      int
+	body        bytes.Buffer
+	limit       int
+	wroteHeader bool
+	passthrough bool
+}
+
+func (r *responseRecorder) WriteHeader(code int) {
+	if r.passthrough {
+		r.ResponseWriter.WriteHeader(code)
+		return
+	}
+	r.status = code
+	r.wroteHeader = true
+}
+
+func (r *responseRecorder) Write(b []byte) (int, error) {
+	if !r.wroteHeader {
+		r.WriteHeader(http.StatusOK)
+	}
+	if r.passthrough {
+		return r.ResponseWriter.Write(b)
+	}
+	if r.body.Len()+len(b) > r.limit {
+		r.flush()
+		return r.ResponseWriter.Write(b)
+	}
+	return r.body.Write(b)
+}
+
+// Flush switches to pass-through so streamed responses are not held back.
+func (r *responseRecorder) Flush() {
+	if !r.passthrough {
+		r.flush()
+	}
+	http.NewResponseController(r.ResponseWriter).Flush()
+}
+
+// Hijack hands the connection to the handler, e.g. for a websocket
+// upgrade, and stops recording.
+func (r *responseRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	r.passthrough = true
+	return http.NewResponseController(r.ResponseWriter).Hijack()
+}
+
+// Unwrap returns the wrapped writer for http.ResponseController.
+func (r *responseRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// flush writes the buffered response and passes later writes through.
+func (r *responseRecorder) flush() {
+	r.passthrough = true
+	r.wroteHeader = true
+	r.ResponseWriter.WriteHeader(r.status)
+	if r.body.Len() > 0 {
+		r.ResponseWriter.Write(r.body.Bytes())
+		r.body.Reset()
+	}
+}
@@ -0,0 +1,212 @@
+package middlewares_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/mauriciomartinezc/real-estate-mc-common/cache"
+	"github.com/mauriciomartinezc/real-estate-mc-common/middlewares"
+)
+
+// cachedServer serves the routes of its RouteTTLs and /uncached/:id through
+// ResponseCache, counting the handler calls. header is applied to every
+// response.
+type cachedServer struct {
+	e      *echo.Echo
+	calls  atomic.Int32
+	header http.Header
+}
+
+func newCachedServer(t *testing.T, routeTTLs map[string]time.Duration) *cachedServer {
+	t.Helper()
+	s := &cachedServer{e: echo.New(), header: make(http.Header)}
+	s.e.Use(middlewares.ResponseCache(middlewares.ResponseCacheConfig{
+		Cache:     cache.NewInMemoryCache(),
+		RouteTTLs: routeTTLs,
+	}))
+	handler := func(c echo.Context) error {
+		n := s.calls.Add(1)
+		for name, values := range s.header {
+			c.Response().Header()[name] = values
+		}
+		return c.String(http.StatusOK, c.Param("id")+"#"+strconv.Itoa(int(n)))
+	}
+	for path := range routeTTLs {
+		s.e.GET(path, handler)
+	}
+	s.e.GET("/uncached/:id", handler)
+	return s
+}
+
+func (s *cachedServer) get(path string, header ...string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	for i := 0; i+1 < len(header); i += 2 {
+		req.Header.Set(header[i], header[i+1])
+	}
+	rec := httptest.NewRecorder()
+	s.e.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestResponseCacheMissThenHit(t *testing.T) {
+	s := newCachedServer(t, map[string]time.Duration{"/properties/:id": 0})
+	s.header.Set("Vary", "Accept-Encoding")
+
+	first := s.get("/properties/1")
+	if first.Code != http.StatusOK || first.Header().Get("X-Cache") != "MISS" {
+		t.Fatalf("first response = %d %s, want 200 MISS", first.Code, first.Header().Get("X-Cache"))
+	}
+	second := s.get("/properties/1")
+	if second.Header().Get("X-Cache") != "HIT" || second.Body.String() != first.Body.String() {
+		t.Fatalf("second response = %s %q, want HIT %q", second.Header().Get("X-Cache"), second.Body, first.Body)
+	}
+	if n := s.calls.Load(); n != 1 {
+		t.Fatalf("handler called %d times, want 1", n)
+	}
+	if second.Header().Get("ETag") == "" || second.Header().Get("Age") == "" {
+		t.Fatalf("HIT lacks validators: %v", second.Header())
+	}
+
+	for _, rec := range []*httptest.ResponseRecorder{first, second} {
+		vary := rec.Header().Values("Vary")
+		if len(vary) != 1 || vary[0] != "Accept-Encoding, Accept-Language, X-Company-Id" {
+			t.Fatalf("Vary = %q, want the handler's and the cache's tokens in one value", vary)
+		}
+	}
+
+	if s.get("/properties/2").Header().Get("X-Cache") != "MISS" {
+		t.Fatal("another path was served from the cache")
+	}
+	if s.get("/properties/1", "X-Company-Id", "c2").Header().Get("X-Cache") != "MISS" {
+		t.Fatal("another company was served from the cache")
+	}
+}
+
+func TestResponseCacheNotModified(t *testing.T) {
+	s := newCachedServer(t, map[string]time.Duration{"/properties/:id": 0})
+	first := s.get("/properties/1")
+	etag, lastModified := first.Header().Get("ETag"), first.Header().Get("Last-Modified")
+
+	if rec := s.get("/properties/1", "If-None-Match", etag); rec.Code != http.StatusNotModified || rec.Body.Len() != 0 {
+		t.Fatalf("If-None-Match response = %d %q, want an empty 304", rec.Code, rec.Body)
+	}
+	if rec := s.get("/properties/1", "If-None-Match", `"other"`); rec.Code != http.StatusOK {
+		t.Fatalf("If-None-Match of another tag = %d, want 200", rec.Code)
+	}
+	if rec := s.get("/properties/1", "If-Modified-Since", lastModified); rec.Code != http.StatusNotModified {
+		t.Fatalf("If-Modified-Since response = %d, want 304", rec.Code)
+	}
+	earlier := time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat)
+	if rec := s.get("/properties/1", "If-Modified-Since", earlier); rec.Code != http.StatusOK {
+		t.Fatalf("If-Modified-Since an hour ago = %d, want 200", rec.Code)
+	}
+
+	// The response that fills the cache is revalidated too.
+	s = newCachedServer(t, map[string]time.Duration{"/properties/:id": 0})
+	if rec := s.get("/properties/1", "If-None-Match", "*"); rec.Code != http.StatusNotModified {
+		t.Fatalf("If-None-Match * on a MISS = %d, want 304", rec.Code)
+	}
+}
+
+func TestResponseCacheNeverSharesCredentialedResponses(t *testing.T) {
+	for _, credential := range [][]string{
+		{echo.HeaderAuthorization, "Bearer token"},
+		{"Cookie", "session=1"},
+	} {
+		t.Run(credential[0], func(t *testing.T) {
+			s := newCachedServer(t, map[string]time.Duration{"/properties/:id": 0})
+
+			s.get("/properties/1", credential...)
+			s.get("/properties/1")
+			if n := s.calls.Load(); n != 2 {
+				t.Fatalf("handler called %d times, want the credentialed response not stored", n)
+			}
+			if rec := s.get("/properties/1", credential...); rec.Header().Get("X-Cache") == "HIT" {
+				t.Fatal("an anonymous response was served to a credentialed request")
+			}
+			if rec := s.get("/properties/1"); rec.Header().Get("X-Cache") != "HIT" {
+				t.Fatal("the anonymous response was not cached")
+			}
+		})
+	}
+
+	// Responses marked public are shared.
+	s := newCachedServer(t, map[string]time.Duration{"/properties/:id": 0})
+	s.header.Set("Cache-Control", "public, max-age=60")
+	s.get("/properties/1", echo.HeaderAuthorization, "Bearer token")
+	if rec := s.get("/properties/1", echo.HeaderAuthorization, "Bearer other"); rec.Header().Get("X-Cache") != "HIT" {
+		t.Fatal("a public response was not shared")
+	}
+}
+
+func TestResponseCacheRespectsHandlerNoStore(t *testing.T) {
+	for _, cacheControl := range []string{"no-store", "private"} {
+		s := newCachedServer(t, map[string]time.Duration{"/properties/:id": 0})
+		s.header.Set("Cache-Control", cacheControl)
+		for i := 0; i < 2; i++ {
+			if rec := s.get("/properties/1"); rec.Code != http.StatusOK || rec.Header().Get("X-Cache") == "HIT" {
+				t.Fatalf("%s response = %d %s", cacheControl, rec.Code, rec.Header().Get("X-Cache"))
+			}
+		}
+		if n := s.calls.Load(); n != 2 {
+			t.Fatalf("%s: handler called %d times, want 2", cacheControl, n)
+		}
+	}
+
+	// The request's no-store skips the cache too.
+	s := newCachedServer(t, map[string]time.Duration{"/properties/:id": 0})
+	s.get("/properties/1", "Cache-Control", "no-store")
+	if rec := s.get("/properties/1"); rec.Header().Get("X-Cache") != "MISS" {
+		t.Fatal("a response to a no-store request was stored")
+	}
+}
+
+func TestResponseCacheRouteTTLs(t *testing.T) {
+	s := newCachedServer(t, map[string]time.Duration{
+		"/short/:id":    30 * time.Millisecond,
+		"/default/:id":  0,
+		"/disabled/:id": -1,
+	})
+
+	s.get("/short/1")
+	if rec := s.get("/short/1"); rec.Header().Get("X-Cache") != "HIT" {
+		t.Fatal("route with a TTL not cached")
+	}
+	time.Sleep(60 * time.Millisecond)
+	if rec := s.get("/short/1"); rec.Header().Get("X-Cache") != "MISS" {
+		t.Fatal("entry served past its route TTL")
+	}
+
+	s.get("/default/1")
+	if rec := s.get("/default/1"); rec.Header().Get("X-Cache") != "HIT" {
+		t.Fatal("route with a zero TTL not cached for the default TTL")
+	}
+
+	for _, path := range []string{"/disabled/1", "/uncached/1"} {
+		calls := s.calls.Load()
+		s.get(path)
+		if rec := s.get(path); rec.Header().Get("X-Cache") != "" || s.calls.Load() != calls+2 {
+			t.Fatalf("%s was cached", path)
+		}
+	}
+}
+
+func TestResponseCacheQueryOrder(t *testing.T) {
+	s := newCachedServer(t, map[string]time.Duration{"/properties/:id": 0})
+
+	s.get("/properties/1?b=2&a=1")
+	if rec := s.get("/properties/1?a=1&b=2"); rec.Header().Get("X-Cache") != "HIT" {
+		t.Fatal("reordered parameters missed the cache")
+	}
+
+	// The order of the values of one parameter is significant.
+	s.get("/properties/1?sort=price&sort=date")
+	if rec := s.get("/properties/1?sort=date&sort=price"); rec.Header().Get("X-Cache") != "MISS" {
+		t.Fatal("reordered values of one parameter shared an entry")
+	}
+}
@@ -0,0 +1,58 @@
+package security
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+)
+
+// TLSOptions describes a client TLS configuration loaded from PEM files
+type TLSOptions struct {
+	Enabled            bool
+	CAFile             string
+	CertFile           string
+	KeyFile            string
+	ServerName         string
+	InsecureSkipVerify bool
+}
+
+// NewTLSConfig builds a client *tls.Config from options. It returns nil when
+// TLS is not enabled. CAFile replaces the system roots; CertFile and KeyFile
+// enable mutual TLS and must be set together.
+func NewTLSConfig(options TLSOptions) (*tls.Config, error) {
+	if !options.Enabled {
+		return nil, nil
+	}
+
+	config := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		ServerName:         options.ServerName,
+		InsecureSkipVerify: options.InsecureSkipVerify,
+	}
+
+	if options.CAFile != "" {
+		caPEM, err := os.ReadFile(options.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("error reading CA file %s: %w", options.CAFile, err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caPEM) {
+			return nil, fmt.Errorf("no valid certificates found in CA file %s", options.CAFile)
+		}
+		config.RootCAs = pool
+	}
+
+	if options.CertFile != "" || options.KeyFile != "" {
+		if options.CertFile == "" || options.KeyFile == "" {
+			return nil, fmt.Errorf("both TLS certificate and key files are required for client authentication")
+		}
+		cert, err := tls.LoadX509KeyPair(options.CertFile, options.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("error loading TLS key pair: %w", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	return config, nil
+}
@@ -15,12 +15,16 @@ import (
 type AWSProvider struct {
 	Client *s3.Client
 	Region string
+	// Bucket es el bucket que usa el servicio; HealthCheck lo consulta.
+	Bucket string
 }
 
 // NewAWSProvider crea e inicializa una instancia de AWSProvider leyendo la configuración desde variables de entorno.
 // Se requieren las siguientes variables:
 //   - AWS_REGION: Región de AWS (por ejemplo, "us-west-2")
 //
+// STORAGE_BUCKET, opcional, indica el bucket que consulta HealthCheck.
+//
 // Además, AWS SDK buscará las credenciales en el entorno o archivos de configuración estándar.
 func NewAWSProvider() (*AWSProvider, error) {
 	region := os.Getenv("AWS_REGION")
@@ -39,6 +43,7 @@ func NewAWSProvider() (*AWSProvider, error) {
 	return &AWSProvider{
 		Client: client,
 		Region: region,
+		Bucket: os.Getenv("STORAGE_BUCKET"),
 	}, nil
 }
 
@@ -50,6 +55,19 @@ func (p *AWSProvider) Init() error {
 	return nil
 }
 
+// HealthCheck consulta el bucket configurado con HeadBucket para verificar
+// que S3 responde y que las credenciales tienen acceso a él. A diferencia
+// de ListBuckets, no requiere el permiso s3:ListAllMyBuckets.
+func (p *AWSProvider) HealthCheck(ctx context.Context) error {
+	if p.Bucket == "" {
+		return fmt.Errorf("no hay un bucket configurado para verificar S3 (STORAGE_BUCKET)")
+	}
+	if _, err := p.Client.HeadBucket(ctx, &s3.HeadBucketInput{Bucket: &p.Bucket}); err != nil {
+		return fmt.Errorf("error verificando el bucket %s en S3: %w", p.Bucket, err)
+	}
+	return nil
+}
+
 // CreateBucket crea un bucket en S3 si no existe.
 func (p *AWSProvider) CreateBucket(bucketName string) error {
 	ctx := context.Background()
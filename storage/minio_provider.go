@@ -14,9 +14,12 @@ import (
 // MinioProvider implementa StorageProvider usando MinIO.
 type MinioProvider struct {
 	Client *minio.Client
+	// Bucket es el bucket que usa el servicio; HealthCheck lo consulta.
+	Bucket string
 }
 
-// NewMinioProvider crea una nueva instancia de MinioProvider.
+// NewMinioProvider crea una nueva instancia de MinioProvider. STORAGE_BUCKET,
+// opcional, indica el bucket que consulta HealthCheck.
 func NewMinioProvider() (*MinioProvider, error) {
 	endpoint := os.Getenv("MINIO_ENDPOINT")
 	if endpoint == "" {
@@ -48,7 +51,7 @@ func NewMinioProvider() (*MinioProvider, error) {
 	if err != nil {
 		return nil, fmt.Errorf("error al inicializar el cliente de MinIO: %v", err)
 	}
-	return &MinioProvider{Client: client}, nil
+	return &MinioProvider{Client: client, Bucket: os.Getenv("STORAGE_BUCKET")}, nil
 }
 
 // Init en este caso no requiere acciones adicionales.
@@ -56,6 +59,23 @@ func (m *MinioProvider) Init() error {
 	return nil
 }
 
+// HealthCheck verifica que el bucket configurado existe, lo que comprueba
+// que MinIO responde y que las credenciales tienen acceso a él sin
+// requerir permiso para listar todos los buckets.
+func (m *MinioProvider) HealthCheck(ctx context.Context) error {
+	if m.Bucket == "" {
+		return fmt.Errorf("no hay un bucket configurado para verificar MinIO (STORAGE_BUCKET)")
+	}
+	exists, err := m.Client.BucketExists(ctx, m.Bucket)
+	if err != nil {
+		return fmt.Errorf("error verificando el bucket %s en MinIO: %w", m.Bucket, err)
+	}
+	if !exists {
+		return fmt.Errorf("el bucket %s no existe en MinIO", m.Bucket)
+	}
+	return nil
+}
+
 // CreateBucket crea un bucket si no existe.
 func (m *MinioProvider) CreateBucket(bucketName string) error {
 	ctx := context.Background()
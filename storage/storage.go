@@ -1,6 +1,9 @@
 package storage
 
-import "io"
+import (
+	"context"
+	"io"
+)
 
 type StorageProvider interface {
 	// Init inicializa el proveedor.
@@ -15,4 +18,7 @@ type StorageProvider interface {
 	DeleteObject(bucketName, objectName string) error
 	// MoveObject mueve/renombra un objeto dentro del bucket.
 	MoveObject(bucketName, srcObjectName, dstObjectName string) error
+	// HealthCheck verifica que el servicio de almacenamiento responde y que
+	// el bucket configurado es accesible.
+	HealthCheck(ctx context.Context) error
 }
@@ -1,13 +1,41 @@
 package utils
 
 import (
-	"github.com/labstack/echo/v4"
 	"net/http"
+
+	"github.com/labstack/echo/v4"
+	"github.com/mauriciomartinezc/real-estate-mc-common/health"
 )
 
 func RouteHealth(e *echo.Echo) {
+	RouteHealthWith(e, health.Default)
+}
+
+// RouteHealthWith expone /health y /live, que responden mientras el proceso
+// está vivo, y /ready, que ejecuta los checks de registry y responde 503 si
+// alguno requerido falla. Las tres responden con Cache-Control: no-store
+// para que ni ResponseCache ni los proxies sirvan un estado viejo.
+func RouteHealthWith(e *echo.Echo, registry *health.Registry) {
 	// Ruta de salud para Consul
 	e.GET("/health", func(c echo.Context) error {
 		return SendResponse(c, http.StatusOK, true, "Health", nil)
-	})
+	}, noStore)
+	e.GET("/live", func(c echo.Context) error {
+		return SendResponse(c, http.StatusOK, true, "Live", nil)
+	}, noStore)
+	e.GET("/ready", func(c echo.Context) error {
+		report := registry.Check(c.Request().Context())
+		if !report.Up() {
+			return SendResponse(c, http.StatusServiceUnavailable, false, "Not ready", report)
+		}
+		return SendResponse(c, http.StatusOK, true, "Ready", report)
+	}, noStore)
+}
+
+// noStore marca la respuesta como no cacheable.
+func noStore(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		c.Response().Header().Set(echo.HeaderCacheControl, "no-store")
+		return next(c)
+	}
 }
@@ -0,0 +1,44 @@
+package utils_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/mauriciomartinezc/real-estate-mc-common/health"
+	"github.com/mauriciomartinezc/real-estate-mc-common/utils"
+)
+
+func TestRouteHealthWith(t *testing.T) {
+	registry := health.NewRegistry()
+	var failure error
+	registry.Register("db", func(ctx context.Context) error { return failure })
+	e := echo.New()
+	utils.RouteHealthWith(e, registry)
+
+	get := func(path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		return rec
+	}
+	for _, path := range []string{"/health", "/live", "/ready"} {
+		rec := get(path)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s status = %d, want 200", path, rec.Code)
+		}
+		if cacheControl := rec.Header().Get(echo.HeaderCacheControl); cacheControl != "no-store" {
+			t.Fatalf("%s Cache-Control = %q, want no-store", path, cacheControl)
+		}
+	}
+
+	failure = errors.New("connection refused")
+	if rec := get("/ready"); rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("/ready status with a failing check = %d, want 503", rec.Code)
+	}
+	if rec := get("/live"); rec.Code != http.StatusOK {
+		t.Fatalf("/live status with a failing check = %d, want 200", rec.Code)
+	}
+}